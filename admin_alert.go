@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/alert"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/dead_letter"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+	"github.com/slack-go/slack"
+)
+
+// AdminAlerter posts operational notices to the admin Slack and Discord channels in settings
+type AdminAlerter struct {
+	slack    *slack.Client
+	discord  *discordgo.Session
+	settings *settings.Handler
+	logger   *logging.Logger
+
+	// the channels read last are used while settings.json cannot be read
+	slackChannels   []string
+	discordChannels []string
+	mu              sync.Mutex
+}
+
+func NewAdminAlerter(slackToken string, discord *discordgo.Session, settings *settings.Handler) *AdminAlerter {
+	return &AdminAlerter{
+		slack:    slack.New(slackToken),
+		discord:  discord,
+		settings: settings,
+		logger:   logging.New("alert"),
+	}
+}
+
+// Send posts the text directly, as a notice should not fail into a dead letter or another notice
+func (a *AdminAlerter) Send(text string) {
+	slackChannels, discordChannels := a.channels()
+
+	for _, channel := range slackChannels {
+		_, _, err := a.slack.PostMessage(channel, slack.MsgOptionText(text, false))
+		if err != nil {
+			a.logger.Error("PostSlackAlert", "channel", channel, "error", err)
+		}
+	}
+	for _, channel := range discordChannels {
+		_, err := a.discord.ChannelMessageSend(channel, text)
+		if err != nil {
+			a.logger.Error("PostDiscordAlert", "channel", channel, "error", err)
+		}
+	}
+}
+
+func (a *AdminAlerter) channels() (slackChannels, discordChannels []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	slackChannels, discordChannels, err := a.settings.AdminChannels()
+	if err != nil {
+		a.logger.Warn("AdminChannels", "error", err)
+		return a.slackChannels, a.discordChannels
+	}
+	a.slackChannels, a.discordChannels = slackChannels, discordChannels
+	return slackChannels, discordChannels
+}
+
+// notifyDeadLetter reports the delivery given up after the retries
+func notifyDeadLetter(letter dead_letter.Letter) {
+	alert.Notify(alert.KindDelivery,
+		"%sへの転送に失敗しました（WebConfiguratorの配送失敗から確認・再送できます）", letter.Queue)
+}
@@ -0,0 +1,171 @@
+// Package alert gathers operational notices, such as lost connections and failed deliveries,
+// and sends them to the admin channels at a limited rate.
+package alert
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+)
+
+const (
+	// GroupDelay is how long notices are gathered before they are sent
+	GroupDelay = 5 * time.Second
+	// Interval is the least time between two posts of the same kind, and notices in between are sent together
+	Interval = time.Minute
+	// MaxLines bounds the distinct notices in a post
+	MaxLines = 10
+)
+
+type Kind string
+
+const (
+	KindLifecycle  Kind = "lifecycle"
+	KindConnection Kind = "connection"
+	KindWebhook    Kind = "webhook"
+	KindSettings   Kind = "settings"
+	KindDelivery   Kind = "delivery"
+	KindPlugin     Kind = "plugin"
+)
+
+var kindTitles = map[Kind]string{
+	KindLifecycle:  "起動・停止",
+	KindConnection: "接続",
+	KindWebhook:    "Webhook",
+	KindSettings:   "設定",
+	KindDelivery:   "配送",
+	KindPlugin:     "プラグイン",
+}
+
+var logger = logging.New("alert")
+
+// Sender posts the text to the admin channels
+type Sender func(text string)
+
+type Notifier struct {
+	send     Sender
+	delay    time.Duration
+	interval time.Duration
+
+	pending map[Kind]*group
+	timers  map[Kind]*time.Timer
+	last    map[Kind]time.Time
+	mu      sync.Mutex
+}
+
+// group is the notices of a kind waiting to be sent, with the number of times each was notified
+type group struct {
+	texts  []string
+	counts map[string]int
+}
+
+func New(send Sender) *Notifier {
+	return &Notifier{
+		send:     send,
+		delay:    GroupDelay,
+		interval: Interval,
+		pending:  map[Kind]*group{},
+		timers:   map[Kind]*time.Timer{},
+		last:     map[Kind]time.Time{},
+	}
+}
+
+// Notify gathers the notice, which is sent after GroupDelay, or Interval after the last post of the kind
+func (n *Notifier) Notify(kind Kind, text string) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var g = n.pending[kind]
+	if g == nil {
+		g = &group{counts: map[string]int{}}
+		n.pending[kind] = g
+	}
+	if g.counts[text] == 0 {
+		g.texts = append(g.texts, text)
+	}
+	g.counts[text]++
+
+	if n.timers[kind] != nil {
+		return
+	}
+
+	var wait = n.interval - time.Since(n.last[kind])
+	if wait < n.delay {
+		wait = n.delay
+	}
+	n.timers[kind] = time.AfterFunc(wait, func() { n.post(kind) })
+}
+
+// Flush sends the waiting notices at once, such as before the process exits
+func (n *Notifier) Flush() {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	var kinds []Kind
+	for kind, timer := range n.timers {
+		timer.Stop()
+		kinds = append(kinds, kind)
+	}
+	n.mu.Unlock()
+
+	for _, kind := range kinds {
+		n.post(kind)
+	}
+}
+
+func (n *Notifier) post(kind Kind) {
+	n.mu.Lock()
+	var g = n.pending[kind]
+	delete(n.pending, kind)
+	delete(n.timers, kind)
+	n.last[kind] = time.Now()
+	n.mu.Unlock()
+
+	if g == nil {
+		return
+	}
+
+	var lines = []string{fmt.Sprintf("【%s】", kindTitles[kind])}
+	for i, text := range g.texts {
+		if i == MaxLines {
+			lines = append(lines, fmt.Sprintf("ほか%d件", len(g.texts)-MaxLines))
+			break
+		}
+		if g.counts[text] > 1 {
+			text = fmt.Sprintf("%s (%d回)", text, g.counts[text])
+		}
+		lines = append(lines, "• "+text)
+	}
+
+	logger.Debug("Post", "kind", kind, "notices", len(g.texts))
+	n.send(strings.Join(lines, "\n"))
+}
+
+var defaultNotifier struct {
+	notifier *Notifier
+	mu       sync.RWMutex
+}
+
+// SetDefault sets the notifier used by Notify
+func SetDefault(n *Notifier) {
+	defaultNotifier.mu.Lock()
+	defer defaultNotifier.mu.Unlock()
+
+	defaultNotifier.notifier = n
+}
+
+// Notify formats the notice and gathers it into the default notifier, and does nothing before SetDefault
+func Notify(kind Kind, format string, args ...interface{}) {
+	defaultNotifier.mu.RLock()
+	var n = defaultNotifier.notifier
+	defaultNotifier.mu.RUnlock()
+
+	n.Notify(kind, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,44 @@
+package alert
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifier(t *testing.T) {
+	var posts = make(chan string, 10)
+	var n = New(func(text string) { posts <- text })
+	n.delay = 10 * time.Millisecond
+	n.interval = 200 * time.Millisecond
+
+	n.Notify(KindConnection, "discord_gateway lost")
+	n.Notify(KindConnection, "discord_gateway lost")
+	n.Notify(KindConnection, "slack lost")
+
+	var text = <-posts
+	for _, want := range []string{"【接続】", "discord_gateway lost (2回)", "slack lost"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("%q does not contain %q", text, want)
+		}
+	}
+
+	// the next post waits for the interval
+	var notified = time.Now()
+	n.Notify(KindConnection, "slack lost")
+	<-posts
+	if time.Since(notified) < 100*time.Millisecond {
+		t.Errorf("posted after %s", time.Since(notified))
+	}
+
+	n.Notify(KindLifecycle, "stopping")
+	n.Flush()
+	select {
+	case text = <-posts:
+		if !strings.Contains(text, "stopping") {
+			t.Errorf("flushed: %q", text)
+		}
+	default:
+		t.Error("not flushed")
+	}
+}
@@ -0,0 +1,257 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/metrics"
+	"github.com/pkg/errors"
+)
+
+const (
+	PlatformSlack   = "slack"
+	PlatformDiscord = "discord"
+
+	KindMessage        = "message"
+	KindEdit           = "edit"
+	KindDelete         = "delete"
+	KindReactionAdd    = "reaction_add"
+	KindReactionRemove = "reaction_remove"
+
+	// DayFormat is the name of the file of records of a day in the channel directory
+	DayFormat = "2006-01-02"
+	// AttachmentDirectory is the directory of downloaded attachments, named by the SHA-256 of the content
+	AttachmentDirectory = "attachments"
+	// MaxAttachmentSize is the size of attachments downloaded at most
+	MaxAttachmentSize = 100 << 20
+	// BufferSize is the number of records waiting to be written, and more records are dropped
+	BufferSize = 1000
+)
+
+var logger = logging.New("archive")
+
+// Record is an event of a bridged channel
+type Record struct {
+	Time     time.Time `json:"time"`
+	Platform string    `json:"platform"`
+	Kind     string    `json:"kind"`
+	// TeamID is the Slack team or the Discord guild
+	TeamID    string `json:"team_id,omitempty"`
+	ChannelID string `json:"channel_id"`
+	MessageID string `json:"message_id"`
+	// ThreadID is the root of the Slack thread, or the Discord message replied to
+	ThreadID    string       `json:"thread_id,omitempty"`
+	UserID      string       `json:"user_id,omitempty"`
+	UserName    string       `json:"user_name,omitempty"`
+	Text        string       `json:"text,omitempty"`
+	Reaction    string       `json:"reaction,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	// BridgedChannelID is the channel of the other side which the message is bridged to
+	BridgedChannelID string `json:"bridged_channel_id,omitempty"`
+	// SlackURL and DiscordURL link the message on both sides of the bridge
+	SlackURL   string `json:"slack_url,omitempty"`
+	DiscordURL string `json:"discord_url,omitempty"`
+}
+
+type Attachment struct {
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type,omitempty"`
+	// SHA256 is set if the content is downloaded, which is the name of the file in AttachmentDirectory
+	SHA256 string `json:"sha256,omitempty"`
+	// Authorization is the header to download the file, such as for Slack private files
+	Authorization string `json:"-"`
+}
+
+// Archive appends the records to JSONL files for each channel and day.
+// Records are written in the background, so that the bridge does not wait for downloads.
+type Archive struct {
+	dir      string
+	download bool
+
+	records chan Record
+	done    chan struct{}
+	closed  bool
+	// dropped is the number of records dropped as the buffer was full
+	dropped int
+	mu      sync.Mutex
+}
+
+// Open starts writing records under the directory. Attachments are downloaded if download is true.
+func Open(dir string, download bool) (*Archive, error) {
+	var err = os.MkdirAll(filepath.Join(dir, AttachmentDirectory), 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "MkdirAll")
+	}
+
+	var a = &Archive{
+		dir:      dir,
+		download: download,
+		records:  make(chan Record, BufferSize),
+		done:     make(chan struct{}),
+	}
+	go a.work()
+
+	return a, nil
+}
+
+// Write queues the record, and nothing is done if the archive is not enabled
+func (a *Archive) Write(record Record) {
+	if a == nil {
+		return
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// events received while shutting down are dropped
+	if a.closed {
+		return
+	}
+	// the bridge does not wait for slow downloads or disks
+	select {
+	case a.records <- record:
+	default:
+		a.dropped++
+		metrics.ArchiveDropped.Inc()
+		if a.dropped == 1 || a.dropped%100 == 0 {
+			logger.Warn("ArchiveBufferFull", "dropped", a.dropped)
+		}
+	}
+}
+
+// Dropped returns the number of records dropped as the buffer was full
+func (a *Archive) Dropped() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.dropped
+}
+
+// Close writes the queued records and stops
+func (a *Archive) Close() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.records)
+	}
+	a.mu.Unlock()
+
+	<-a.done
+}
+
+func (a *Archive) work() {
+	defer close(a.done)
+
+	for record := range a.records {
+		if a.download {
+			for i := range record.Attachments {
+				var err = a.fetch(&record.Attachments[i])
+				if err != nil {
+					logger.Warn("ArchiveAttachment", "url", record.Attachments[i].URL, "error", err)
+				}
+			}
+		}
+
+		var err = a.append(record)
+		if err != nil {
+			logger.Error("ArchiveRecord", "error", err)
+		}
+	}
+}
+
+func (a *Archive) append(record Record) error {
+	var dir = filepath.Join(a.dir, record.Platform, record.ChannelID)
+	var err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errors.Wrap(err, "MkdirAll")
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+
+	f, err := os.OpenFile(
+		filepath.Join(dir, record.Time.Local().Format(DayFormat)+".jsonl"),
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644,
+	)
+	if err != nil {
+		return errors.Wrap(err, "OpenFile")
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(b, '\n'))
+	return errors.Wrap(err, "Write")
+}
+
+// fetch downloads the attachment into AttachmentDirectory, where the same content is stored once
+func (a *Archive) fetch(attachment *Attachment) error {
+	req, err := http.NewRequest("GET", attachment.URL, nil)
+	if err != nil {
+		return errors.Wrap(err, "NewRequest")
+	}
+	if attachment.Authorization != "" {
+		req.Header.Set("Authorization", attachment.Authorization)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "Do")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("Status: %s", resp.Status)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Join(a.dir, AttachmentDirectory), "download-")
+	if err != nil {
+		return errors.Wrap(err, "TempFile")
+	}
+	defer os.Remove(tmp.Name())
+
+	var hash = sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(resp.Body, MaxAttachmentSize))
+	tmp.Close()
+	if err != nil {
+		return errors.Wrap(err, "Copy")
+	}
+
+	var sum = hex.EncodeToString(hash.Sum(nil))
+	var path = filepath.Join(a.dir, AttachmentPath(sum))
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return errors.Wrap(err, "MkdirAll")
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		err = os.Rename(tmp.Name(), path)
+		if err != nil {
+			return errors.Wrap(err, "Rename")
+		}
+	}
+
+	attachment.SHA256 = sum
+	return nil
+}
+
+// AttachmentPath returns the path of the attachment relative to the archive directory
+func AttachmentPath(sum string) string {
+	return filepath.Join(AttachmentDirectory, sum[:2], sum)
+}
@@ -0,0 +1,124 @@
+package archive
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTranscript(t *testing.T) {
+	var dir = t.TempDir()
+
+	a, err := Open(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var at = time.Date(2021, 4, 1, 12, 0, 0, 0, time.Local)
+	var records = []Record{
+		{Time: at, Kind: KindMessage, MessageID: "1", UserName: "alice", Text: "hello"},
+		{Time: at.Add(time.Minute), Kind: KindMessage, MessageID: "2", UserName: "bob", Text: "typo", ThreadID: "1"},
+		{Time: at.Add(2 * time.Minute), Kind: KindEdit, MessageID: "2", UserName: "bob", Text: "fixed"},
+		{Time: at.Add(3 * time.Minute), Kind: KindReactionAdd, MessageID: "1", UserID: "U1", Reaction: "+1"},
+		{Time: at.Add(4 * time.Minute), Kind: KindReactionAdd, MessageID: "1", UserID: "U2", Reaction: "+1"},
+		{Time: at.Add(5 * time.Minute), Kind: KindReactionRemove, MessageID: "1", UserID: "U2", Reaction: "+1"},
+		{Time: at.Add(6 * time.Minute), Kind: KindMessage, MessageID: "3", UserName: "carol", Text: "oops"},
+		{Time: at.Add(7 * time.Minute), Kind: KindDelete, MessageID: "3"},
+		// the next day is in another file
+		{Time: at.Add(24 * time.Hour), Kind: KindMessage, MessageID: "4", UserName: "alice", Text: "tomorrow"},
+	}
+	for _, record := range records {
+		record.Platform = PlatformSlack
+		record.ChannelID = "C1"
+		a.Write(record)
+	}
+	a.Close()
+
+	read, err := Read(dir, PlatformSlack, "C1", at, at.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 8 {
+		t.Fatalf("Read: %d records", len(read))
+	}
+
+	var messages = Transcript(read)
+	if len(messages) != 3 {
+		t.Fatalf("Transcript: %d messages", len(messages))
+	}
+	if messages[1].Text != "fixed" || !messages[1].Edited {
+		t.Errorf("edit: %+v", messages[1])
+	}
+	if !messages[2].Deleted {
+		t.Errorf("delete: %+v", messages[2])
+	}
+	if len(messages[0].Reactions) != 1 || messages[0].Reactions[0].Count != 1 {
+		t.Errorf("reactions: %+v", messages[0].Reactions)
+	}
+
+	var b strings.Builder
+	err = ExportMarkdown(&b, messages, ExportOptions{Title: "general"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# general", "## 2021-04-01", "**alice** 12:00", "> fixed", ":+1: 1", "(削除済み)"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("markdown does not contain %q:\n%s", want, b.String())
+		}
+	}
+	if strings.Contains(b.String(), "oops") {
+		t.Errorf("markdown contains the deleted text:\n%s", b.String())
+	}
+}
+
+func TestSearch(t *testing.T) {
+	var dir = t.TempDir()
+
+	a, err := Open(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var at = time.Date(2021, 4, 1, 12, 0, 0, 0, time.Local)
+	a.Write(Record{Time: at, Platform: PlatformSlack, Kind: KindMessage, ChannelID: "C1", BridgedChannelID: "D1", MessageID: "1", UserName: "alice", Text: "Lunch at noon"})
+	a.Write(Record{Time: at.Add(time.Hour), Platform: PlatformDiscord, Kind: KindMessage, ChannelID: "D1", BridgedChannelID: "C1", MessageID: "2", UserName: "bob", Text: "lunch is over"})
+	a.Write(Record{Time: at.Add(2 * time.Hour), Platform: PlatformDiscord, Kind: KindMessage, ChannelID: "D2", BridgedChannelID: "C2", MessageID: "3", UserName: "bob", Text: "lunch elsewhere"})
+	a.Close()
+
+	results, err := Search(dir, Query{Text: "LUNCH", SlackChannel: "C1", DiscordChannel: "D1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].MessageID != "2" || results[1].MessageID != "1" {
+		t.Errorf("channel pair: %+v", results)
+	}
+
+	results, err = Search(dir, Query{Text: "lunch noon", Author: "ali"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].MessageID != "1" {
+		t.Errorf("author: %+v", results)
+	}
+}
+
+func TestWriteBufferFull(t *testing.T) {
+	// the archive without the worker keeps the records in the buffer
+	var a = &Archive{records: make(chan Record, 1)}
+
+	var done = make(chan struct{})
+	go func() {
+		a.Write(Record{Kind: KindMessage, MessageID: "1"})
+		a.Write(Record{Kind: KindMessage, MessageID: "2"})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Write blocks while the buffer is full")
+	}
+	if a.Dropped() != 1 {
+		t.Errorf("dropped %d, want 1", a.Dropped())
+	}
+}
@@ -0,0 +1,167 @@
+package archive
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+)
+
+type ExportOptions struct {
+	Title string
+	// AttachmentBase is the path from the exported file to the archive directory, under which downloaded attachments are linked
+	AttachmentBase string
+}
+
+// Export writes the transcript in the format
+func Export(w io.Writer, format string, messages []Message, options ExportOptions) error {
+	switch format {
+	case FormatMarkdown:
+		return ExportMarkdown(w, messages, options)
+	case FormatHTML:
+		return ExportHTML(w, messages, options)
+	default:
+		return fmt.Errorf("UnknownFormat: %s", format)
+	}
+}
+
+// ExportMarkdown writes the transcript with a heading for each day
+func ExportMarkdown(w io.Writer, messages []Message, options ExportOptions) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", options.Title)
+
+	var day string
+	for _, message := range messages {
+		var local = message.Time.Local()
+		if local.Format(DayFormat) != day {
+			day = local.Format(DayFormat)
+			fmt.Fprintf(&b, "\n## %s\n", day)
+		}
+
+		var lines []string
+		lines = append(lines, fmt.Sprintf("**%s** %s%s", authorName(message), local.Format("15:04"), status(message)))
+		if !message.Deleted {
+			if message.Text != "" {
+				lines = append(lines, strings.Split(message.Text, "\n")...)
+			}
+			for _, attachment := range message.Attachments {
+				lines = append(lines, fmt.Sprintf("- [%s](%s)", attachment.Name, attachmentLink(attachment, options)))
+			}
+		}
+		if len(message.Reactions) > 0 {
+			lines = append(lines, reactionText(message.Reactions))
+		}
+
+		// replies are quoted under the messages of the channel
+		var prefix string
+		if message.ThreadID != "" && message.ThreadID != message.MessageID {
+			prefix = "> "
+		}
+
+		b.WriteString("\n")
+		for _, line := range lines {
+			b.WriteString(prefix + line + "  \n")
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var htmlTemplate = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"author":     authorName,
+	"status":     status,
+	"reactions":  reactionText,
+	"attachment": attachmentLink,
+	"isReply": func(message Message) bool {
+		return message.ThreadID != "" && message.ThreadID != message.MessageID
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 960px; margin: auto; }
+.message { margin: 0.5em 0; white-space: pre-wrap; }
+.reply { margin-left: 2em; border-left: 3px solid #ddd; padding-left: 0.5em; }
+.time, .status, .reactions { color: #888; font-size: small; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{- range .Messages}}
+<div class="message{{if isReply .}} reply{{end}}">
+<b>{{author .}}</b> <span class="time">{{.Time.Local.Format "2006-01-02 15:04"}}</span><span class="status">{{status .}}</span>
+{{- if not .Deleted}}
+<div>{{.Text}}</div>
+{{- range .Attachments}}
+<div><a href="{{attachment . $.Options}}">{{.Name}}</a></div>
+{{- end}}
+{{- end}}
+{{- if .Reactions}}
+<div class="reactions">{{reactions .Reactions}}</div>
+{{- end}}
+</div>
+{{- end}}
+</body>
+</html>
+`))
+
+// ExportHTML writes the transcript as a single HTML page
+func ExportHTML(w io.Writer, messages []Message, options ExportOptions) error {
+	return htmlTemplate.Execute(w, struct {
+		Title    string
+		Messages []Message
+		Options  ExportOptions
+	}{
+		Title:    options.Title,
+		Messages: messages,
+		Options:  options,
+	})
+}
+
+func authorName(message Message) string {
+	switch {
+	case message.UserName != "":
+		return message.UserName
+	case message.UserID != "":
+		return message.UserID
+	default:
+		return "unknown"
+	}
+}
+
+func status(message Message) string {
+	switch {
+	case message.Deleted:
+		return " (削除済み)"
+	case message.Edited:
+		return " (編集済み)"
+	default:
+		return ""
+	}
+}
+
+func reactionText(reactions []Reaction) string {
+	var texts []string
+	for _, reaction := range reactions {
+		texts = append(texts, fmt.Sprintf(":%s: %d", reaction.Name, reaction.Count))
+	}
+	return strings.Join(texts, " ")
+}
+
+// attachmentLink links the downloaded file, or the original URL if it is not downloaded
+func attachmentLink(attachment Attachment, options ExportOptions) string {
+	if attachment.SHA256 == "" {
+		return attachment.URL
+	}
+	return path.Join(options.AttachmentBase, filepath.ToSlash(AttachmentPath(attachment.SHA256)))
+}
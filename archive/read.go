@@ -0,0 +1,156 @@
+package archive
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Message is a message of the transcript, whose text is the last edit
+type Message struct {
+	Record
+	Edited    bool
+	Deleted   bool
+	Reactions []Reaction
+}
+
+type Reaction struct {
+	Name  string
+	Count int
+}
+
+// Read returns the records of the channel in the range, the oldest first
+func Read(dir, platform, channelID string, from, to time.Time) ([]Record, error) {
+	var channelDir = filepath.Join(dir, platform, channelID)
+
+	files, err := ioutil.ReadDir(channelDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadDir")
+	}
+
+	var firstDay = from.Local().Format(DayFormat)
+	var lastDay = to.Local().Format(DayFormat)
+
+	var records []Record
+	for _, file := range files {
+		var day = strings.TrimSuffix(file.Name(), ".jsonl")
+		if day == file.Name() || day < firstDay || day > lastDay {
+			continue
+		}
+
+		dayRecords, err := readFile(filepath.Join(channelDir, file.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "ReadArchive: %s", file.Name())
+		}
+		for _, record := range dayRecords {
+			if record.Time.Before(from) || !record.Time.Before(to) {
+				continue
+			}
+			records = append(records, record)
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	return records, nil
+}
+
+func readFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	var scanner = bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for scanner.Scan() {
+		var record Record
+		// a line broken by a crash is skipped
+		if json.Unmarshal(scanner.Bytes(), &record) != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Transcript applies the edits, deletions and reactions to the messages, in the order of the records.
+// Deletions of the messages not archived, such as the originals replaced by the bridge, are ignored.
+func Transcript(records []Record) []Message {
+	var messages []*Message
+	var index = map[string]*Message{}
+	var reactions = map[string]map[string]map[string]bool{}
+
+	for _, record := range records {
+		switch record.Kind {
+		case KindMessage:
+			if _, ok := index[record.MessageID]; ok {
+				continue
+			}
+			var message = &Message{Record: record}
+			messages = append(messages, message)
+			index[record.MessageID] = message
+		case KindEdit:
+			var message, ok = index[record.MessageID]
+			if !ok {
+				// the message was sent before the range, and the edit is shown instead
+				message = &Message{Record: record}
+				message.Kind = KindMessage
+				messages = append(messages, message)
+				index[record.MessageID] = message
+			}
+			message.Text = record.Text
+			message.Edited = true
+		case KindDelete:
+			// deletions and reactions of messages out of the range are not shown
+			if message, ok := index[record.MessageID]; ok {
+				message.Deleted = true
+			}
+		case KindReactionAdd, KindReactionRemove:
+			var message, ok = index[record.MessageID]
+			if !ok {
+				continue
+			}
+			if reactions[message.MessageID] == nil {
+				reactions[message.MessageID] = map[string]map[string]bool{}
+			}
+			var users = reactions[message.MessageID][record.Reaction]
+			if users == nil {
+				users = map[string]bool{}
+				reactions[message.MessageID][record.Reaction] = users
+			}
+			if record.Kind == KindReactionAdd {
+				users[record.UserID] = true
+			} else {
+				delete(users, record.UserID)
+			}
+		}
+	}
+
+	var transcript = make([]Message, 0, len(messages))
+	for _, message := range messages {
+		for name, users := range reactions[message.MessageID] {
+			if len(users) > 0 {
+				message.Reactions = append(message.Reactions, Reaction{Name: name, Count: len(users)})
+			}
+		}
+		sort.Slice(message.Reactions, func(i, j int) bool {
+			return message.Reactions[i].Name < message.Reactions[j].Name
+		})
+		transcript = append(transcript, *message)
+	}
+	return transcript
+}
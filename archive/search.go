@@ -0,0 +1,100 @@
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MaxSearchResults is the number of messages returned by Search at most
+const MaxSearchResults = 100
+
+type Query struct {
+	// Text matches the messages containing all the words, ignoring case
+	Text string
+	// Author matches a part of the name or the user ID
+	Author string
+	// SlackChannel and DiscordChannel match the messages of the channel pair from either side
+	SlackChannel   string
+	DiscordChannel string
+	From           time.Time
+	To             time.Time
+}
+
+// Search returns the messages matching the query, the newest first.
+// Every day file in the range is read, as the archive is not indexed.
+func (a *Archive) Search(query Query) ([]Message, error) {
+	return Search(a.dir, query)
+}
+
+func Search(dir string, query Query) ([]Message, error) {
+	if query.To.IsZero() {
+		query.To = time.Now().Add(time.Minute)
+	}
+
+	var words = strings.Fields(strings.ToLower(query.Text))
+	var author = strings.ToLower(query.Author)
+
+	var results []Message
+	for _, platform := range []string{PlatformSlack, PlatformDiscord} {
+		var channel, bridged = query.SlackChannel, query.DiscordChannel
+		if platform == PlatformDiscord {
+			channel, bridged = query.DiscordChannel, query.SlackChannel
+		}
+
+		channels, err := ioutil.ReadDir(filepath.Join(dir, platform))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "ReadDir")
+		}
+
+		for _, ch := range channels {
+			if !ch.IsDir() || (channel != "" && ch.Name() != channel) {
+				continue
+			}
+
+			records, err := Read(dir, platform, ch.Name(), query.From, query.To)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, message := range Transcript(records) {
+				if message.Deleted || (bridged != "" && message.BridgedChannelID != bridged) {
+					continue
+				}
+				if author != "" && !strings.Contains(strings.ToLower(message.UserName), author) && message.UserID != query.Author {
+					continue
+				}
+				if !containsWords(message.Text, words) {
+					continue
+				}
+				results = append(results, message)
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Time.After(results[j].Time)
+	})
+	if len(results) > MaxSearchResults {
+		results = results[:MaxSearchResults]
+	}
+	return results, nil
+}
+
+func containsWords(text string, words []string) bool {
+	text = strings.ToLower(text)
+	for _, word := range words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
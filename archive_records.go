@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/archive"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/discord_webhook"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// archiveMessage records the Slack message bridged to Discord.
+// ts is the message reposted by the bot if any, as reactions are given to it.
+func (s *SlackHandler) archiveMessage(ev *slackevents.MessageEvent, name, ts, guildID string, posted *discord_webhook.Message) {
+	if s.archive == nil {
+		return
+	}
+
+	var record = s.archiveRecord(archive.KindMessage, ev)
+	record.Time = slackTimestampTime(ev.TimeStamp)
+	record.MessageID = ts
+	record.UserName = name
+	record.SlackURL = slackPermalink(s.workspaceURI, ev.Channel, ts)
+	if posted != nil && posted.ID != "" {
+		record.BridgedChannelID = posted.ChannelID
+		record.DiscordURL = discordMessageURL(guildID, posted.ChannelID, posted.ID)
+	}
+	for _, f := range ev.Files {
+		record.Attachments = append(record.Attachments, archive.Attachment{
+			Name:          f.Name,
+			URL:           f.URLPrivate,
+			ContentType:   f.Mimetype,
+			Authorization: "Bearer " + s.apiToken,
+		})
+	}
+	s.archive.Write(record)
+}
+
+// archiveChange records the edit or the deletion of a message in a bridged channel.
+// Messages posted by the bot, such as ones bridged from Discord, are not recorded.
+func (s *SlackHandler) archiveChange(ev *slackevents.MessageEvent) {
+	if s.archive == nil {
+		return
+	}
+
+	switch ev.SubType {
+	case "message_changed":
+		if ev.Message == nil || ev.Message.User == s.hook.Identity.UserID {
+			return
+		}
+		var record = s.archiveRecord(archive.KindEdit, ev.Message)
+		record.ChannelID = ev.Channel
+		s.archive.Write(record)
+	case "message_deleted":
+		if ev.PreviousMessage == nil || ev.PreviousMessage.User == s.hook.Identity.UserID {
+			return
+		}
+		var record = s.archiveRecord(archive.KindDelete, ev.PreviousMessage)
+		record.ChannelID = ev.Channel
+		record.Text = ""
+		s.archive.Write(record)
+	}
+}
+
+// archiveReaction records the reaction to a message of a bridged channel
+func (s *SlackHandler) archiveReaction(channelID, ts, user, reaction string, added bool) {
+	if s.archive == nil {
+		return
+	}
+	if cs, _ := s.settings.LookupDiscordChannel(channelID); cs.DiscordChannel == "" {
+		return
+	}
+
+	var kind = archive.KindReactionAdd
+	if !added {
+		kind = archive.KindReactionRemove
+	}
+
+	s.archive.Write(archive.Record{
+		Platform:  archive.PlatformSlack,
+		Kind:      kind,
+		TeamID:    s.hook.Identity.TeamID,
+		ChannelID: channelID,
+		MessageID: ts,
+		UserID:    user,
+		Reaction:  reaction,
+	})
+}
+
+func (s *SlackHandler) archiveRecord(kind string, ev *slackevents.MessageEvent) archive.Record {
+	var record = archive.Record{
+		Platform:  archive.PlatformSlack,
+		Kind:      kind,
+		TeamID:    s.hook.Identity.TeamID,
+		ChannelID: ev.Channel,
+		MessageID: ev.TimeStamp,
+		UserID:    ev.User,
+		Text:      ev.Text,
+	}
+	if ev.ThreadTimeStamp != ev.TimeStamp {
+		record.ThreadID = ev.ThreadTimeStamp
+	}
+	return record
+}
+
+// archiveMessage records the Discord message bridged to Slack as ts.
+// The message reposted by the webhook is recorded if any, as reactions are given to it and the attachments of the original are deleted.
+func (d *DiscordHandler) archiveMessage(m *discordgo.Message, reposted discord_webhook.Message, name, slackChannel, ts string) {
+	if d.archive == nil {
+		return
+	}
+
+	var record = archive.Record{
+		Time:      m.Timestamp,
+		Platform:  archive.PlatformDiscord,
+		Kind:      archive.KindMessage,
+		TeamID:    m.GuildID,
+		ChannelID: m.ChannelID,
+		MessageID: m.ID,
+		UserID:    m.Author.ID,
+		UserName:  name,
+		Text:      m.Content,
+
+		BridgedChannelID: slackChannel,
+		SlackURL:         slackPermalink(d.slackHook.Identity.WorkspaceURI, slackChannel, ts),
+	}
+	if m.MessageReference != nil {
+		record.ThreadID = m.MessageReference.MessageID
+	}
+
+	if reposted.ID != "" {
+		record.MessageID = reposted.ID
+		for _, attachment := range reposted.Attachments {
+			record.Attachments = append(record.Attachments, archive.Attachment{
+				Name: attachment.Filename,
+				URL:  attachment.URL,
+			})
+		}
+	} else {
+		for _, attachment := range m.Attachments {
+			record.Attachments = append(record.Attachments, archive.Attachment{
+				Name:        attachment.Filename,
+				URL:         attachment.URL,
+				ContentType: attachment.ContentType,
+			})
+		}
+	}
+	record.DiscordURL = discordMessageURL(m.GuildID, m.ChannelID, record.MessageID)
+
+	d.archive.Write(record)
+}
+
+// messageUpdate records the edit of a message in a bridged channel, which is not bridged to Slack
+func (d *DiscordHandler) messageUpdate(_ *discordgo.Session, ev *discordgo.MessageUpdate) {
+	// updates of embeds have no author
+	if d.archive == nil || ev.GuildID == "" || ev.Author == nil || ev.Author.Bot {
+		return
+	}
+	if sdt := d.settings.LookupSlackChannel(ev.ChannelID, ev.GuildID); !sdt.Setting.DiscordToSlack {
+		return
+	}
+	// every edit has its own time
+	var edited string
+	if ev.EditedTimestamp != nil {
+		edited = strconv.FormatInt(ev.EditedTimestamp.UnixNano(), 10)
+	}
+	if d.events.Seen("discord:update:" + ev.ID + ":" + edited) {
+		return
+	}
+
+	d.archive.Write(archive.Record{
+		Platform:  archive.PlatformDiscord,
+		Kind:      archive.KindEdit,
+		TeamID:    ev.GuildID,
+		ChannelID: ev.ChannelID,
+		MessageID: ev.ID,
+		UserID:    ev.Author.ID,
+		UserName:  ev.Author.Username,
+		Text:      ev.Content,
+	})
+}
+
+// messageDelete records the deletion of a message in a bridged channel
+func (d *DiscordHandler) messageDelete(_ *discordgo.Session, ev *discordgo.MessageDelete) {
+	if d.archive == nil || ev.GuildID == "" {
+		return
+	}
+	if sdt := d.settings.LookupSlackChannel(ev.ChannelID, ev.GuildID); !sdt.Setting.DiscordToSlack {
+		return
+	}
+	if d.events.Seen("discord:delete:" + ev.ID) {
+		return
+	}
+
+	d.archive.Write(archive.Record{
+		Platform:  archive.PlatformDiscord,
+		Kind:      archive.KindDelete,
+		TeamID:    ev.GuildID,
+		ChannelID: ev.ChannelID,
+		MessageID: ev.ID,
+	})
+}
+
+// archiveReaction records the reaction to a message of a bridged channel
+func (d *DiscordHandler) archiveReaction(guildID, channelID, messageID, userID string, emoji discordgo.Emoji, added bool) {
+	if d.archive == nil {
+		return
+	}
+	if sdt := d.settings.LookupSlackChannel(channelID, guildID); sdt.SlackChannel == "" {
+		return
+	}
+
+	var kind = archive.KindReactionAdd
+	if !added {
+		kind = archive.KindReactionRemove
+	}
+
+	d.archive.Write(archive.Record{
+		Platform:  archive.PlatformDiscord,
+		Kind:      kind,
+		TeamID:    guildID,
+		ChannelID: channelID,
+		MessageID: messageID,
+		UserID:    userID,
+		Reaction:  emoji.Name,
+	})
+}
+
+// slackPermalink returns the link of the message in the workspace, such as https://example.slack.com/archives/C0123/p1617235200000100
+func slackPermalink(workspaceURI, channelID, ts string) string {
+	if workspaceURI == "" || ts == "" {
+		return ""
+	}
+	if !strings.HasSuffix(workspaceURI, "/") {
+		workspaceURI += "/"
+	}
+	return fmt.Sprintf("%sarchives/%s/p%s", workspaceURI, channelID, strings.Replace(ts, ".", "", 1))
+}
+
+func discordMessageURL(guildID, channelID, messageID string) string {
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, channelID, messageID)
+}
+
+func slackTimestampTime(ts string) time.Time {
+	seconds, err := strconv.ParseFloat(ts, 64)
+	if err != nil {
+		return time.Now()
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
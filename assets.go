@@ -0,0 +1,45 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// the web assets of the configurator, and the images of the default Slack emojis
+var (
+	//go:embed index.html static
+	embeddedWebAssets embed.FS
+	//go:embed NotoColorEmoji
+	embeddedEmojiAssets embed.FS
+)
+
+// WebAssets has index.html and static/ of the configurator, and EmojiAssets has the emoji images
+var WebAssets, EmojiAssets fs.FS
+
+// loadAssets uses the files in the directory instead of the embedded ones if they exist, so that they can be changed without a build
+func loadAssets(dir string) (web fs.FS, emoji fs.FS) {
+	emoji, _ = fs.Sub(embeddedEmojiAssets, "NotoColorEmoji")
+	if dir == "" {
+		return embeddedWebAssets, emoji
+	}
+	return overlayFS{os.DirFS(dir), embeddedWebAssets},
+		overlayFS{os.DirFS(filepath.Join(dir, "NotoColorEmoji")), emoji}
+}
+
+// overlayFS opens the file in dir, or in embedded if it is not in dir
+type overlayFS struct {
+	dir      fs.FS
+	embedded fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.dir.Open(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return f, err
+	}
+	return o.embedded.Open(name)
+}
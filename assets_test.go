@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadAssets(t *testing.T) {
+	var dir = t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, "static"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "static", "style.css"), []byte("overridden"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	web, emoji := loadAssets(dir)
+
+	b, err := fs.ReadFile(web, "static/style.css")
+	if err != nil || string(b) != "overridden" {
+		t.Errorf("overridden file: %q %v", b, err)
+	}
+	_, err = fs.ReadFile(web, "index.html")
+	if err != nil {
+		t.Errorf("embedded file: %v", err)
+	}
+	_, err = fs.Stat(emoji, "emoji_u1f600.png")
+	if err != nil {
+		t.Errorf("emoji: %v", err)
+	}
+}
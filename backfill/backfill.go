@@ -0,0 +1,379 @@
+package backfill
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/discord_webhook"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/slack_webhook"
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+)
+
+const (
+	DirectionSlackToDiscord = "slack2discord"
+	DirectionDiscordToSlack = "discord2slack"
+
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+
+	// TimestampFormat is the format of the timestamp prefixed to copied messages
+	TimestampFormat = "2006-01-02 15:04"
+)
+
+var logger = logging.New("backfill")
+
+// ErrLocked is returned by New when another process, such as the running bot, uses the jobs file
+var ErrLocked = errors.New("BackfillFileLocked")
+
+// Request is the range of history copied between the channel pair
+type Request struct {
+	Direction string `json:"direction"`
+	// SlackTeam is the team ID of the Slack channel, and the default workspace is used if it is empty
+	SlackTeam      string    `json:"slack_team,omitempty"`
+	SlackChannel   string    `json:"slack_channel"`
+	DiscordChannel string    `json:"discord_channel"`
+	From           time.Time `json:"from"`
+	To             time.Time `json:"to"`
+}
+
+// Job is a backfill persisted with its progress, so that it is resumed after restarts
+type Job struct {
+	ID string `json:"id"`
+	Request
+
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Total  int    `json:"total"`
+	Done   int    `json:"done"`
+
+	// Copied maps the copied messages to the messages sent, which are skipped on resuming
+	Copied map[string]string `json:"copied"`
+	// Threads maps the Slack thread roots to the Discord threads, or the Discord replies to the messages replied to
+	Threads map[string]string `json:"threads"`
+
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+type workspace struct {
+	api   *slack.Client
+	token string
+	hook  *slack_webhook.Handler
+}
+
+// Handler runs backfill jobs one by one
+type Handler struct {
+	path string
+	lock *os.File
+
+	discord     *discordgo.Session
+	discordHook *discord_webhook.Handler
+	workspaces  map[string]workspace
+	defaultTeam string
+
+	jobs     []*Job
+	pending  chan string
+	progress func(job Job)
+
+	mu sync.Mutex
+}
+
+// New locks and loads the jobs from the file. The lock is held until Close,
+// so that the jobs saved by another process are not overwritten.
+func New(path string, discord *discordgo.Session, discordHook *discord_webhook.Handler) (*Handler, error) {
+	lock, err := lockFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var h = &Handler{
+		path:        path,
+		lock:        lock,
+		discord:     discord,
+		discordHook: discordHook,
+		workspaces:  map[string]workspace{},
+		pending:     make(chan string, 100),
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		h.Close()
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+
+	err = json.Unmarshal(b, &h.jobs)
+	if err != nil {
+		h.Close()
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+	return h, nil
+}
+
+// Close releases the lock of the jobs file
+func (h *Handler) Close() error {
+	if h.lock == nil {
+		return nil
+	}
+	return h.lock.Close()
+}
+
+// AddSlackWorkspace adds the workspace of the team. The first workspace is used for requests without slack_team.
+func (h *Handler) AddSlackWorkspace(teamID, token string, hook *slack_webhook.Handler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.workspaces) == 0 {
+		h.defaultTeam = teamID
+	}
+	h.workspaces[teamID] = workspace{api: slack.New(token), token: token, hook: hook}
+}
+
+// SetProgressHandler sets the function called whenever a job makes progress
+func (h *Handler) SetProgressHandler(handler func(job Job)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.progress = handler
+}
+
+// Add validates and saves the request as a new job, which is run by Run or Process
+func (h *Handler) Add(req Request) (Job, error) {
+	switch req.Direction {
+	case DirectionSlackToDiscord, DirectionDiscordToSlack:
+	default:
+		return Job{}, errors.Errorf("UnknownDirection: %s", req.Direction)
+	}
+	if req.SlackChannel == "" || req.DiscordChannel == "" {
+		return Job{}, errors.New("NoChannel")
+	}
+	if req.To.IsZero() {
+		req.To = time.Now()
+	}
+	if !req.From.Before(req.To) {
+		return Job{}, errors.New("InvalidRange")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.workspace(req.SlackTeam); !ok {
+		return Job{}, errors.Errorf("UnknownSlackTeam: %s", req.SlackTeam)
+	}
+
+	var now = time.Now()
+	var job = &Job{
+		ID:      fmt.Sprintf("%d", now.UnixNano()),
+		Request: req,
+		Status:  StatusQueued,
+		Copied:  map[string]string{},
+		Threads: map[string]string{},
+		Created: now,
+		Updated: now,
+	}
+	h.jobs = append(h.jobs, job)
+
+	return *job, h.save()
+}
+
+// Enqueue makes the job run by Run
+func (h *Handler) Enqueue(id string) {
+	h.pending <- id
+}
+
+// Resume enqueues the jobs which are not finished
+func (h *Handler) Resume() {
+	h.mu.Lock()
+	var ids []string
+	for _, job := range h.jobs {
+		if job.Status == StatusQueued || job.Status == StatusRunning {
+			ids = append(ids, job.ID)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, id := range ids {
+		h.Enqueue(id)
+	}
+}
+
+// Run processes the enqueued jobs until stop is closed
+func (h *Handler) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case id := <-h.pending:
+			var err = h.Process(id)
+			if err != nil {
+				logger.Error("Backfill", "job", id, "error", err)
+			}
+		}
+	}
+}
+
+// Jobs returns the jobs, the newest first
+func (h *Handler) Jobs() []Job {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var jobs = []Job{}
+	for i := len(h.jobs) - 1; i >= 0; i-- {
+		var job = *h.jobs[i]
+		// the maps are large and only used inside
+		job.Copied = nil
+		job.Threads = nil
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+func (h *Handler) Get(id string) (Job, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var job = h.find(id)
+	if job == nil {
+		return Job{}, false
+	}
+
+	var copied = *job
+	copied.Copied = nil
+	copied.Threads = nil
+	return copied, true
+}
+
+// Process runs the job until it is finished. Messages copied already are skipped.
+func (h *Handler) Process(id string) error {
+	h.mu.Lock()
+	var job = h.find(id)
+	if job == nil {
+		h.mu.Unlock()
+		return errors.Errorf("NotFound: %s", id)
+	}
+	if job.Status == StatusDone {
+		h.mu.Unlock()
+		return nil
+	}
+	ws, ok := h.workspace(job.SlackTeam)
+	job.Status = StatusRunning
+	job.Error = ""
+	h.mu.Unlock()
+
+	var err error
+	switch {
+	case !ok:
+		err = errors.Errorf("UnknownSlackTeam: %s", job.SlackTeam)
+	case job.Direction == DirectionSlackToDiscord:
+		err = h.slackToDiscord(job, ws)
+	default:
+		err = h.discordToSlack(job, ws)
+	}
+
+	h.mu.Lock()
+	if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = StatusDone
+	}
+	h.mu.Unlock()
+
+	h.update(job, 0)
+	return err
+}
+
+// update saves the progress of the job, which is also given to the progress handler
+func (h *Handler) update(job *Job, done int) {
+	h.mu.Lock()
+	job.Done += done
+	job.Updated = time.Now()
+	var err = h.save()
+	var progress = h.progress
+	var snapshot = *job
+	h.mu.Unlock()
+
+	if err != nil {
+		logger.Error("SaveBackfillJobs", "error", err)
+	}
+	if progress != nil {
+		progress(snapshot)
+	}
+}
+
+// copied records the message copied, and must not be called with mu held
+func (h *Handler) copied(job *Job, source, destination string) {
+	h.mu.Lock()
+	job.Copied[source] = destination
+	h.mu.Unlock()
+
+	h.update(job, 1)
+}
+
+func (h *Handler) setThread(job *Job, source, destination string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	job.Threads[source] = destination
+}
+
+func (h *Handler) lookup(job *Job, source string) (copied string, thread string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return job.Copied[source], job.Threads[source]
+}
+
+func (h *Handler) setTotal(job *Job, total int) {
+	h.mu.Lock()
+	job.Total = total
+	h.mu.Unlock()
+
+	h.update(job, 0)
+}
+
+// find must be called with mu held
+func (h *Handler) find(id string) *Job {
+	for _, job := range h.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// workspace must be called with mu held
+func (h *Handler) workspace(teamID string) (workspace, bool) {
+	if teamID == "" {
+		teamID = h.defaultTeam
+	}
+	ws, ok := h.workspaces[teamID]
+	return ws, ok
+}
+
+// save must be called with mu held
+func (h *Handler) save() error {
+	b, err := json.Marshal(h.jobs)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+
+	var tmp = h.path + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return errors.Wrap(err, "WriteFile")
+	}
+	return os.Rename(tmp, h.path)
+}
+
+func timestampPrefix(t time.Time) string {
+	return "[" + t.Local().Format(TimestampFormat) + "] "
+}
@@ -0,0 +1,55 @@
+package backfill
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSnowflake(t *testing.T) {
+	// the ID of a message sent at 2021-01-01T00:00:00.000Z
+	var at = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	if id := snowflake(at); id != "794354201395200000" {
+		t.Errorf("snowflake: %s", id)
+	}
+
+	if id := snowflake(time.Unix(0, 0)); id != "0" {
+		t.Errorf("snowflake before the epoch: %s", id)
+	}
+}
+
+func TestSlackTime(t *testing.T) {
+	var at = slackTime("1609459200.000100")
+	if at.Unix() != 1609459200 || at.Nanosecond() != 100000 {
+		t.Errorf("slackTime: %v", at)
+	}
+
+	if ts := slackTimestamp(at); ts != "1609459200.000100" {
+		t.Errorf("slackTimestamp: %s", ts)
+	}
+}
+
+func TestLock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the jobs file is not locked on Windows")
+	}
+
+	var path = filepath.Join(t.TempDir(), "backfill.json")
+	h, err := New(path, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = New(path, nil, nil)
+	if err != ErrLocked {
+		t.Fatalf("the jobs file used by another handler: %v", err)
+	}
+
+	h.Close()
+	h, err = New(path, nil, nil)
+	if err != nil {
+		t.Fatalf("the jobs file after Close: %v", err)
+	}
+	h.Close()
+}
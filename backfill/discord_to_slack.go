@@ -0,0 +1,179 @@
+package backfill
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/delivery_queue"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/slack_webhook"
+	"github.com/pkg/errors"
+)
+
+// DiscordEpoch is the first second of 2015 in milliseconds, which Discord snowflakes count from
+const DiscordEpoch = 1420070400000
+
+func (h *Handler) discordToSlack(job *Job, ws workspace) error {
+	messages, err := h.discordHistory(job.DiscordChannel, job.From, job.To)
+	if err != nil {
+		return errors.Wrap(err, "ChannelMessages")
+	}
+	h.setTotal(job, len(messages))
+
+	// messages sent by the webhook of the bridge came from Slack
+	var webhookID string
+	if webhook := h.discordHook.Get(job.DiscordChannel); webhook != nil {
+		webhookID = webhook.ID
+	}
+
+	for _, message := range messages {
+		err = h.copyDiscordMessage(job, ws, webhookID, message)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Handler) copyDiscordMessage(job *Job, ws workspace, webhookID string, message *discordgo.Message) error {
+	if copied, _ := h.lookup(job, message.ID); copied != "" {
+		return nil
+	}
+
+	if message.Author == nil || (webhookID != "" && message.WebhookID == webhookID) {
+		h.copied(job, message.ID, "-")
+		return nil
+	}
+	switch message.Type {
+	case discordgo.MessageTypeDefault, discordgo.MessageTypeReply:
+	default:
+		h.copied(job, message.ID, "-")
+		return nil
+	}
+
+	var name = message.Author.Username
+	if message.Member != nil && message.Member.Nick != "" {
+		name = message.Member.Nick
+	}
+
+	var content = timestampPrefix(message.Timestamp) + message.Content
+
+	var blocks []slack_webhook.BlockBase
+	var links []string
+	for _, attachment := range message.Attachments {
+		// images are shown by the URL, and the other files are linked
+		if strings.HasPrefix(attachment.ContentType, "image/") {
+			var block = slack_webhook.ImageBlock(attachment.URL, attachment.Filename)
+			block.Title = slack_webhook.ImageTitle(attachment.Filename, false)
+			blocks = append(blocks, block)
+			continue
+		}
+		links = append(links, attachment.URL)
+	}
+	if len(links) > 0 {
+		content += "\n" + strings.Join(links, "\n")
+	}
+
+	var section = slack_webhook.SectionBlock()
+	section.Text = slack_webhook.MrkdwnElement(content, false)
+	blocks = append([]slack_webhook.BlockBase{section}, blocks...)
+
+	var sMessage = slack_webhook.Message{
+		IconURL:     message.Author.AvatarURL(""),
+		Username:    name,
+		Channel:     job.SlackChannel,
+		Text:        content,
+		Blocks:      blocks,
+		UnfurlLinks: true,
+		UnfurlMedia: true,
+	}
+
+	// replies and messages in threads of the channel are sent to the Slack thread of the original message
+	if reference := message.MessageReference; reference != nil && reference.MessageID != "" {
+		var root = h.threadRoot(job, reference.MessageID)
+		if ts, _ := h.lookup(job, root); ts != "" && ts != "-" {
+			sMessage.ThreadTimestamp = ts
+			h.setThread(job, message.ID, root)
+		}
+	}
+
+	ts, err := ws.hook.Send(sMessage)
+	switch {
+	case err == delivery_queue.ErrQueued:
+		h.copied(job, message.ID, "-")
+	case err != nil:
+		return errors.Wrap(err, "SendToSlack")
+	default:
+		h.copied(job, message.ID, ts)
+	}
+	return nil
+}
+
+// threadRoot returns the first message of the reply chain, as Slack threads are not nested
+func (h *Handler) threadRoot(job *Job, messageID string) string {
+	for i := 0; i < 100; i++ {
+		_, root := h.lookup(job, messageID)
+		if root == "" {
+			break
+		}
+		messageID = root
+	}
+	return messageID
+}
+
+// discordHistory returns the messages in the range, the oldest first
+func (h *Handler) discordHistory(channelID string, from, to time.Time) ([]*discordgo.Message, error) {
+	var messages []*discordgo.Message
+	var after = snowflake(from)
+
+	for {
+		batch, err := h.discord.ChannelMessages(channelID, 100, "", after, "")
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		var last = after
+		var done bool
+		for _, message := range batch {
+			if snowflakeAfter(message.ID, last) {
+				last = message.ID
+			}
+			if message.Timestamp.After(to) {
+				done = true
+				continue
+			}
+			messages = append(messages, message)
+		}
+		if done || len(batch) < 100 || last == after {
+			break
+		}
+		after = last
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return snowflakeAfter(messages[j].ID, messages[i].ID)
+	})
+	return messages, nil
+}
+
+// snowflake returns the smallest ID of the messages sent at the time
+func snowflake(t time.Time) string {
+	var ms = t.UnixNano()/int64(time.Millisecond) - DiscordEpoch
+	if ms < 0 {
+		ms = 0
+	}
+	return strconv.FormatUint(uint64(ms)<<22, 10)
+}
+
+// snowflakeAfter reports whether the decimal ID a is larger than b
+func snowflakeAfter(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a > b
+}
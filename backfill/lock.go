@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package backfill
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// lockFile takes the lock of the jobs file, which is released when the returned file is closed
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "OpenLockFile")
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		file.Close()
+		return nil, ErrLocked
+	}
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "Flock")
+	}
+	return file, nil
+}
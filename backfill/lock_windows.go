@@ -0,0 +1,8 @@
+package backfill
+
+import "os"
+
+// lockFile does not lock the jobs file on Windows, where flock is not available
+func lockFile(path string) (*os.File, error) {
+	return nil, nil
+}
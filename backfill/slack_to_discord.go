@@ -0,0 +1,270 @@
+package backfill
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/delivery_queue"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/discord_webhook"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/slack_webhook"
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+)
+
+// ThreadArchiveDuration is the auto archive duration in minutes of threads created on Discord
+const ThreadArchiveDuration = 1440
+
+func (h *Handler) slackToDiscord(job *Job, ws workspace) error {
+	messages, err := h.slackHistory(ws.api, job.SlackChannel, job.From, job.To)
+	if err != nil {
+		return errors.Wrap(err, "GetConversationHistory")
+	}
+
+	var total = len(messages)
+	var replies = map[string][]slack.Message{}
+	for _, message := range messages {
+		if message.ReplyCount == 0 {
+			continue
+		}
+		thread, err := h.slackReplies(ws.api, job.SlackChannel, message.Timestamp)
+		if err != nil {
+			return errors.Wrap(err, "GetConversationReplies")
+		}
+		replies[message.Timestamp] = thread
+		total += len(thread)
+	}
+	h.setTotal(job, total)
+
+	var names = map[string]*slack_webhook.UserProfile{}
+	for _, message := range messages {
+		err = h.copySlackMessage(job, ws, names, message, "")
+		if err != nil {
+			return err
+		}
+
+		for _, reply := range replies[message.Timestamp] {
+			err = h.copySlackMessage(job, ws, names, reply, message.Timestamp)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (h *Handler) copySlackMessage(job *Job, ws workspace, names map[string]*slack_webhook.UserProfile, message slack.Message, root string) error {
+	if copied, _ := h.lookup(job, message.Timestamp); copied != "" {
+		return nil
+	}
+
+	// messages bridged from Discord and the system messages are not copied
+	if message.User == ws.hook.Identity.UserID || message.BotID != "" {
+		h.copied(job, message.Timestamp, "-")
+		return nil
+	}
+	switch message.SubType {
+	case "", "file_share", "thread_broadcast":
+	default:
+		h.copied(job, message.Timestamp, "-")
+		return nil
+	}
+
+	profile, ok := names[message.User]
+	if !ok {
+		var err error
+		profile, err = ws.hook.GetUserProfile(message.User, false)
+		if err != nil {
+			return errors.Wrapf(err, "GetUserProfile: %s", message.User)
+		}
+		names[message.User] = profile
+	}
+
+	var name = profile.DisplayName
+	if name == "" {
+		name = profile.RealName
+	}
+
+	var content = timestampPrefix(slackTime(message.Timestamp)) + message.Text
+
+	var files []discord_webhook.File
+	for _, f := range message.Files {
+		// images are uploaded to Discord, and the other files are linked
+		if f.Filetype != "png" && f.Filetype != "jpg" && f.Filetype != "gif" {
+			content += "\n" + f.Permalink
+			continue
+		}
+
+		req, err := http.NewRequest("GET", f.URLPrivate, nil)
+		if err != nil {
+			return errors.Wrap(err, "NewRequest")
+		}
+		req.Header.Set("Authorization", "Bearer "+ws.token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return errors.Wrapf(err, "DownloadFile: %s", f.ID)
+		}
+		defer resp.Body.Close()
+
+		// a deleted or hidden file is linked, so that the error page is not uploaded as the image
+		if resp.StatusCode != http.StatusOK {
+			logger.Warn("DownloadFile", "file", f.ID, "status", resp.StatusCode)
+			content += "\n" + f.Permalink
+			continue
+		}
+
+		files = append(files, discord_webhook.File{
+			FileName:    f.Name,
+			Reader:      resp.Body,
+			ContentType: "image/" + f.Filetype,
+		})
+	}
+
+	var dMessage = discord_webhook.Message{
+		AvaterURL: profile.GetUserImageURI(),
+		UserName:  name,
+		ChannelID: job.DiscordChannel,
+		Content:   content,
+	}
+
+	var threadID string
+	if root != "" {
+		var parent string
+		parent, threadID = h.lookup(job, root)
+		if threadID == "" && parent != "" && parent != "-" {
+			thread, err := h.discord.MessageThreadStart(job.DiscordChannel, parent, threadName(root), ThreadArchiveDuration)
+			if err != nil {
+				return errors.Wrap(err, "MessageThreadStart")
+			}
+			threadID = thread.ID
+			h.setThread(job, root, threadID)
+		}
+	}
+
+	var sent *discord_webhook.Message
+	var err error
+	if threadID != "" {
+		sent, err = h.discordHook.SendInThread(job.DiscordChannel, threadID, dMessage, true, files)
+	} else {
+		sent, err = h.discordHook.Send(job.DiscordChannel, dMessage, true, files)
+	}
+
+	switch {
+	case err == delivery_queue.ErrQueued:
+		// the message is delivered later, but replies to it are sent to the channel
+		h.copied(job, message.Timestamp, "-")
+	case err != nil:
+		return errors.Wrap(err, "SendToDiscord")
+	default:
+		h.copied(job, message.Timestamp, sent.ID)
+	}
+	return nil
+}
+
+// slackHistory returns the messages in the range, the oldest first
+func (h *Handler) slackHistory(api *slack.Client, channelID string, from, to time.Time) ([]slack.Message, error) {
+	var messages []slack.Message
+	var params = &slack.GetConversationHistoryParameters{
+		ChannelID: channelID,
+		Oldest:    slackTimestamp(from),
+		Latest:    slackTimestamp(to),
+		Limit:     200,
+	}
+
+	for {
+		var resp *slack.GetConversationHistoryResponse
+		var err = retryRateLimited(func() (err error) {
+			resp, err = api.GetConversationHistory(params)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, resp.Messages...)
+		if !resp.HasMore || resp.ResponseMetaData.NextCursor == "" {
+			break
+		}
+		params.Cursor = resp.ResponseMetaData.NextCursor
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return slackTime(messages[i].Timestamp).Before(slackTime(messages[j].Timestamp))
+	})
+	return messages, nil
+}
+
+// slackReplies returns the replies in the thread without its root, the oldest first
+func (h *Handler) slackReplies(api *slack.Client, channelID, ts string) ([]slack.Message, error) {
+	var replies []slack.Message
+	var params = &slack.GetConversationRepliesParameters{
+		ChannelID: channelID,
+		Timestamp: ts,
+		Limit:     200,
+	}
+
+	for {
+		var messages []slack.Message
+		var hasMore bool
+		var cursor string
+		var err = retryRateLimited(func() (err error) {
+			messages, hasMore, cursor, err = api.GetConversationReplies(params)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, message := range messages {
+			if message.Timestamp != ts {
+				replies = append(replies, message)
+			}
+		}
+		if !hasMore || cursor == "" {
+			break
+		}
+		params.Cursor = cursor
+	}
+
+	sort.Slice(replies, func(i, j int) bool {
+		return slackTime(replies[i].Timestamp).Before(slackTime(replies[j].Timestamp))
+	})
+	return replies, nil
+}
+
+// retryRateLimited calls the function again after the wait requested by Slack
+func retryRateLimited(call func() error) error {
+	for {
+		var err = call()
+
+		var limited *slack.RateLimitedError
+		if err != nil && errors.As(err, &limited) {
+			time.Sleep(limited.RetryAfter)
+			continue
+		}
+		return err
+	}
+}
+
+func threadName(root string) string {
+	return "Slack thread " + slackTime(root).Local().Format(TimestampFormat)
+}
+
+func slackTimestamp(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', 6, 64)
+}
+
+func slackTime(ts string) time.Time {
+	var parts = strings.SplitN(ts, ".", 2)
+	sec, _ := strconv.ParseInt(parts[0], 10, 64)
+
+	var nsec int64
+	if len(parts) == 2 {
+		var frac = (parts[1] + "000000000")[:9]
+		nsec, _ = strconv.ParseInt(frac, 10, 64)
+	}
+	return time.Unix(sec, nsec)
+}
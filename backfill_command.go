@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/backfill"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/discord_webhook"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/slack_webhook"
+	"github.com/pkg/errors"
+)
+
+// BackfillFile is the file of backfill jobs in the state directory
+const BackfillFile = "backfill.json"
+
+// backfillTimeFormats are the formats accepted by -from and -to, in the local time zone
+var backfillTimeFormats = []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02"}
+
+// runBackfillCommand copies the history of a channel pair, or resumes the job given by -resume.
+// It is run as "DiscordSlackSync backfill -direction slack2discord -slack C0123 -discord 0123 -from 2021-04-01".
+func runBackfillCommand(args []string) error {
+	var flags = flag.NewFlagSet(ProgramName+" backfill", flag.ContinueOnError)
+	var direction = flags.String("direction", backfill.DirectionSlackToDiscord, "slack2discord or discord2slack")
+	var team = flags.String("team", "", "Slack team ID, the default workspace if empty")
+	var slackChannel = flags.String("slack", "", "Slack channel ID")
+	var discordChannel = flags.String("discord", "", "Discord channel ID")
+	var from = flags.String("from", "", "start of the range, such as 2021-04-01")
+	var to = flags.String("to", "", "end of the range, now if empty")
+	var resume = flags.String("resume", "", "ID of the job to resume")
+
+	var err = flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	session, err := discordgo.New("Bot " + Tokens.Discord.API)
+	if err != nil {
+		return errors.Wrap(err, "NewDiscordSession")
+	}
+
+	handler, err := newBackfillHandler(session, discord_webhook.New(Tokens.Discord.API))
+	if errors.Cause(err) == backfill.ErrLocked {
+		// the running bot saves its jobs to the same file, so the job is added from the WebConfigurator instead
+		return errors.Wrap(err, "BotRunning: use the WebConfigurator while the bot is running")
+	}
+	if err != nil {
+		return err
+	}
+	defer handler.Close()
+	for _, tokens := range Tokens.SlackWorkspaces {
+		var hook = slack_webhook.New(tokens.API)
+		if hook.Identity.TeamID == "" {
+			return errors.Errorf("NoTeamID: %s", tokens.Name)
+		}
+		handler.AddSlackWorkspace(hook.Identity.TeamID, tokens.API, hook)
+	}
+
+	handler.SetProgressHandler(func(job backfill.Job) {
+		fmt.Printf("\r%s: %s %d/%d", job.ID, job.Status, job.Done, job.Total)
+	})
+
+	var id = *resume
+	if id == "" {
+		var req = backfill.Request{
+			Direction:      *direction,
+			SlackTeam:      *team,
+			SlackChannel:   *slackChannel,
+			DiscordChannel: *discordChannel,
+		}
+
+		req.From, err = parseBackfillTime(*from)
+		if err != nil {
+			return errors.Wrap(err, "ParseFrom")
+		}
+		if *to != "" {
+			req.To, err = parseBackfillTime(*to)
+			if err != nil {
+				return errors.Wrap(err, "ParseTo")
+			}
+		}
+
+		job, err := handler.Add(req)
+		if err != nil {
+			return err
+		}
+		id = job.ID
+		fmt.Println("Backfill job:", id)
+	}
+
+	err = handler.Process(id)
+	fmt.Println()
+	return err
+}
+
+func newBackfillHandler(session *discordgo.Session, discordHook *discord_webhook.Handler) (*backfill.Handler, error) {
+	handler, err := backfill.New(filepath.Join(StateDirectory, BackfillFile), session, discordHook)
+	if err != nil {
+		return nil, errors.Wrap(err, "LoadBackfillJobs")
+	}
+	return handler, nil
+}
+
+func parseBackfillTime(value string) (time.Time, error) {
+	var err error
+	for _, format := range backfillTimeFormats {
+		var t time.Time
+		t, err = time.ParseInLocation(format, value, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
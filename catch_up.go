@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/alert"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/discord_webhook"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/slack_webhook"
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack/slackevents"
+)
+
+const (
+	// CatchUpLimit is the number of messages replayed for a channel at most on catching up
+	CatchUpLimit = 200
+	// catchUpPageSize is the number of Slack messages fetched at once
+	catchUpPageSize = 100
+)
+
+// CatchUpHandler replays the messages sent while the bridge was down or disconnected.
+// The last bridged message of every channel is kept as the high-water mark, and channels without it are not caught up.
+type CatchUpHandler struct {
+	slackHook   *slack_webhook.Handler
+	discordHook *discord_webhook.Handler
+	settings    *settings.Handler
+
+	slack   *SlackHandler
+	discord *DiscordHandler
+
+	marks  *highWaterMarks
+	logger *logging.Logger
+}
+
+func NewCatchUpHandler(path string, slackHook *slack_webhook.Handler, discordHook *discord_webhook.Handler, settings *settings.Handler) (*CatchUpHandler, error) {
+	marks, err := loadHighWaterMarks(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "LoadHighWaterMarks")
+	}
+
+	return &CatchUpHandler{
+		slackHook:   slackHook,
+		discordHook: discordHook,
+		settings:    settings,
+		marks:       marks,
+		logger:      logging.New("catch_up"),
+	}, nil
+}
+
+func (c *CatchUpHandler) SetSlackHandler(handler *SlackHandler) {
+	c.slack = handler
+}
+
+func (c *CatchUpHandler) SetDiscordHandler(handler *DiscordHandler) {
+	c.discord = handler
+}
+
+// MarkSlack records the Slack message bridged to Discord
+func (c *CatchUpHandler) MarkSlack(channelID, ts string) {
+	if c == nil {
+		return
+	}
+
+	var err = c.marks.SetSlack(channelID, ts)
+	if err != nil {
+		c.logger.Error("SaveHighWaterMarks", "error", err)
+	}
+}
+
+// MarkDiscord records the Discord message bridged to Slack
+func (c *CatchUpHandler) MarkDiscord(guildID, channelID, messageID string) {
+	if c == nil {
+		return
+	}
+
+	var err = c.marks.SetDiscord(guildID, channelID, messageID)
+	if err != nil {
+		c.logger.Error("SaveHighWaterMarks", "error", err)
+	}
+}
+
+// CatchUpSlack replays the Slack messages newer than the marks, the oldest first
+func (c *CatchUpHandler) CatchUpSlack() {
+	if c == nil || c.slack == nil {
+		return
+	}
+
+	for channelID, mark := range c.marks.SlackMarks() {
+		var cs, _ = c.settings.LookupDiscordChannel(channelID)
+		if !cs.Setting.SlackToDiscord {
+			continue
+		}
+
+		// the messages are fetched forward from the mark, so that the oldest ones of a long gap are not skipped
+		var after = mark
+		var hasMore = true
+		var count int
+		for hasMore && count < CatchUpLimit {
+			messages, more, err := c.slackHook.GetMessagesAfter(channelID, after, catchUpPageSize)
+			if err != nil {
+				c.logger.Error("GetSlackMessages", "error", err)
+				break
+			}
+			hasMore = more
+
+			var newer int
+			for _, message := range messages {
+				if count >= CatchUpLimit {
+					// the rest of the page is left
+					hasMore = true
+					break
+				}
+				if !slackTimestampAfter(message.TS, after) {
+					continue
+				}
+				after = message.TS
+				newer++
+				if message.Raw == nil {
+					continue
+				}
+
+				var ev slackevents.MessageEvent
+				err = json.Unmarshal(message.Raw, &ev)
+				if err != nil {
+					c.logger.Error("UnmarshalSlackMessage", "error", err)
+					continue
+				}
+				ev.Channel = channelID
+
+				c.slack.replayMessage(&ev)
+				count++
+			}
+			if newer == 0 {
+				break
+			}
+		}
+
+		if hasMore && count >= CatchUpLimit {
+			c.reportLimit(channelID)
+		}
+	}
+}
+
+// CatchUpDiscord replays the Discord messages newer than the marks, the oldest first
+func (c *CatchUpHandler) CatchUpDiscord() {
+	if c == nil || c.discord == nil {
+		return
+	}
+
+	for channelID, mark := range c.marks.DiscordMarks() {
+		var cs = c.settings.LookupSlackChannel(channelID, mark.GuildID)
+		if !cs.Setting.DiscordToSlack {
+			continue
+		}
+
+		var after = mark.MessageID
+		var count int
+		for count < CatchUpLimit {
+			// messages around the mark are returned, and the newer ones are replayed
+			messages, err := c.discordHook.GetMessages(channelID, after)
+			if err != nil {
+				c.logger.Error("GetDiscordMessages", "error", err)
+				break
+			}
+
+			var newer []discordgo.Message
+			for _, message := range messages {
+				if snowflakeAfter(message.ID, after) {
+					newer = append(newer, message)
+				}
+			}
+			if len(newer) == 0 {
+				break
+			}
+			sort.Slice(newer, func(i, j int) bool {
+				return snowflakeAfter(newer[j].ID, newer[i].ID)
+			})
+
+			for i := range newer {
+				var message = newer[i]
+				message.GuildID = mark.GuildID
+				c.discord.replayMessage(&discordgo.MessageCreate{Message: &message})
+			}
+
+			after = newer[len(newer)-1].ID
+			count += len(newer)
+		}
+
+		if count >= CatchUpLimit {
+			c.reportLimit(channelID)
+		}
+	}
+}
+
+// reportLimit notifies that the messages of the channel after CatchUpLimit are not replayed
+func (c *CatchUpHandler) reportLimit(channelID string) {
+	c.logger.Warn("CatchUpLimitReached", "channel", channelID, "limit", CatchUpLimit)
+	alert.Notify(alert.KindDelivery, "チャンネル%sの取りこぼしが%d件を超えたため、それ以降のメッセージは転送されていません", channelID, CatchUpLimit)
+}
+
+// slackTimestampAfter reports whether the Slack timestamp a is newer than b
+func slackTimestampAfter(a, b string) bool {
+	var as = strings.SplitN(a, ".", 2)
+	var bs = strings.SplitN(b, ".", 2)
+	if as[0] != bs[0] {
+		return snowflakeAfter(as[0], bs[0])
+	}
+	if len(as) < 2 || len(bs) < 2 {
+		return len(as) > len(bs)
+	}
+	return as[1] > bs[1]
+}
+
+// snowflakeAfter reports whether the decimal ID a is larger than b
+func snowflakeAfter(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a > b
+}
+
+// highWaterMarks is persisted so that the gap since the last bridged message is known after restarts
+type highWaterMarks struct {
+	// Slack holds the timestamp of the last bridged message of each Slack channel
+	Slack map[string]string `json:"slack"`
+	// Discord holds the last bridged message of each Discord channel
+	Discord map[string]discordMark `json:"discord"`
+
+	path string
+	mu   sync.Mutex
+}
+
+type discordMark struct {
+	GuildID   string `json:"guild_id"`
+	MessageID string `json:"message_id"`
+}
+
+func loadHighWaterMarks(path string) (*highWaterMarks, error) {
+	var marks = &highWaterMarks{
+		Slack:   map[string]string{},
+		Discord: map[string]discordMark{},
+		path:    path,
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return marks, nil
+	}
+	if err != nil {
+		return marks, errors.Wrap(err, "ReadFile")
+	}
+
+	err = json.Unmarshal(b, marks)
+	if err != nil {
+		return marks, errors.Wrap(err, "Unmarshal")
+	}
+	return marks, nil
+}
+
+// save must be called with mu held
+func (m *highWaterMarks) save() error {
+	b, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+	return ioutil.WriteFile(m.path, b, 0644)
+}
+
+// SetSlack moves the mark forward, and older timestamps are ignored
+func (m *highWaterMarks) SetSlack(channelID, ts string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if mark, ok := m.Slack[channelID]; ok && !slackTimestampAfter(ts, mark) {
+		return nil
+	}
+	m.Slack[channelID] = ts
+	return m.save()
+}
+
+// SetDiscord moves the mark forward, and older messages are ignored
+func (m *highWaterMarks) SetDiscord(guildID, channelID, messageID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if mark, ok := m.Discord[channelID]; ok && !snowflakeAfter(messageID, mark.MessageID) {
+		return nil
+	}
+	m.Discord[channelID] = discordMark{GuildID: guildID, MessageID: messageID}
+	return m.save()
+}
+
+func (m *highWaterMarks) SlackMarks() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var marks = map[string]string{}
+	for channelID, ts := range m.Slack {
+		marks[channelID] = ts
+	}
+	return marks
+}
+
+func (m *highWaterMarks) DiscordMarks() map[string]discordMark {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var marks = map[string]discordMark{}
+	for channelID, mark := range m.Discord {
+		marks[channelID] = mark
+	}
+	return marks
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestSlackTimestampAfter(t *testing.T) {
+	var cases = []struct {
+		a, b     string
+		expected bool
+	}{
+		{"1700000000.000200", "1700000000.000100", true},
+		{"1700000000.000100", "1700000000.000100", false},
+		{"999999999.999999", "1000000000.000000", false},
+		{"1700000001.000000", "1700000000.999999", true},
+	}
+
+	for _, c := range cases {
+		if got := slackTimestampAfter(c.a, c.b); got != c.expected {
+			t.Errorf("slackTimestampAfter(%s, %s): expected %v, but got %v", c.a, c.b, c.expected, got)
+		}
+	}
+}
+
+func TestHighWaterMarks(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "high_water_marks.json")
+
+	marks, err := loadHighWaterMarks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marks.SetSlack("C1", "1700000000.000200")
+	marks.SetSlack("C1", "1700000000.000100")
+	marks.SetDiscord("G1", "D1", "1000000000000000002")
+	marks.SetDiscord("G1", "D1", "999999999999999999")
+
+	marks, err = loadHighWaterMarks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts := marks.SlackMarks()["C1"]; ts != "1700000000.000200" {
+		t.Errorf("Expected the mark not to move back, but got %s", ts)
+	}
+	if mark := marks.DiscordMarks()["D1"]; mark.MessageID != "1000000000000000002" || mark.GuildID != "G1" {
+		t.Errorf("Expected the mark not to move back, but got %+v", mark)
+	}
+}
+
+func TestAuthorName(t *testing.T) {
+	// messages replayed on catching up are fetched by REST, which have no member
+	var replayed = &discordgo.Message{Author: &discordgo.User{Username: "alice"}}
+	if name := authorName(replayed); name != "alice" {
+		t.Errorf("Expected the user name of a message without member, but got %s", name)
+	}
+
+	replayed.Member = &discordgo.Member{Nick: "Alice"}
+	if name := authorName(replayed); name != "Alice" {
+		t.Errorf("Expected the nickname, but got %s", name)
+	}
+
+	replayed.Member.Nick = ""
+	if name := authorName(replayed); name != "alice" {
+		t.Errorf("Expected the user name of a member without nickname, but got %s", name)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"html"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+	"github.com/slack-go/slack"
+)
+
+// ChannelSyncHandler propagates channel names, topics and archives between paired channels.
+// Every change is compared with the other side first, so that the echoed events stop the propagation.
+type ChannelSyncHandler struct {
+	slack    *slack.Client
+	discord  *discordgo.Session
+	settings *settings.Handler
+	logger   *logging.Logger
+}
+
+func NewChannelSyncHandler(slackToken string, discord *discordgo.Session, settings *settings.Handler) *ChannelSyncHandler {
+	return &ChannelSyncHandler{
+		slack:    slack.New(slackToken),
+		discord:  discord,
+		settings: settings,
+		logger:   logging.New("channel_sync"),
+	}
+}
+
+// DiscordChannelUpdated must be called before the channel map follows the update,
+// since the pair is looked up by the old channel name.
+func (h *ChannelSyncHandler) DiscordChannelUpdated(channel *discordgo.Channel) {
+	if channel.Type != discordgo.ChannelTypeGuildText {
+		h.settings.UpdateDiscordChannel(channel)
+		return
+	}
+
+	var cs, paired = pairedSlackChannel(h.settings, channel.GuildID, channel.ID)
+	var oldName = h.settings.ChannelMap().DiscordChannelName(channel.GuildID, channel.ID)
+
+	h.settings.UpdateDiscordChannel(channel)
+
+	if !paired || (!cs.Setting.SyncChannelName && !cs.Setting.SyncChannelTopic) {
+		return
+	}
+
+	info, err := h.slack.GetConversationInfo(cs.SlackChannel, false)
+	if err != nil {
+		h.logger.Error("GetConversationInfo", "error", err)
+		return
+	}
+
+	if cs.Setting.SyncChannelName && oldName != "" && oldName != channel.Name {
+		name, ok := h.settings.ChannelMap().SlackChannelNameFor(channel.GuildID, channel.Name)
+		if ok && name != info.Name {
+			_, err = h.slack.RenameConversation(cs.SlackChannel, name)
+			if err != nil {
+				h.logger.Error("RenameConversation", "error", err)
+			} else {
+				h.settings.UpdateSlackChannel(cs.SlackChannel, name)
+			}
+		}
+	}
+
+	if cs.Setting.SyncChannelTopic && channel.Topic != slackChannelTopic(info) {
+		_, err = h.slack.SetTopicOfConversation(cs.SlackChannel, channel.Topic)
+		if err != nil {
+			h.logger.Error("SetTopicOfConversation", "error", err)
+		}
+	}
+}
+
+// DiscordChannelDeleted archives the paired Slack channel if SyncChannelArchive is enabled
+func (h *ChannelSyncHandler) DiscordChannelDeleted(channel *discordgo.Channel) {
+	var cs, paired = pairedSlackChannel(h.settings, channel.GuildID, channel.ID)
+
+	h.settings.RemoveDiscordChannel(channel.GuildID, channel.ID)
+
+	if !paired || !cs.Setting.SyncChannelArchive {
+		return
+	}
+
+	var err = h.slack.ArchiveConversation(cs.SlackChannel)
+	if err != nil {
+		h.logger.Error("ArchiveConversation", "error", err)
+		return
+	}
+	h.settings.RemoveSlackChannel(cs.SlackChannel)
+}
+
+// SlackChannelRenamed must be called before the channel map follows the rename
+func (h *ChannelSyncHandler) SlackChannelRenamed(channelID, name string) {
+	var oldName = h.settings.ChannelMap().SlackChannelName(channelID)
+	var cs, guildID = h.settings.LookupDiscordChannel(channelID)
+
+	h.settings.UpdateSlackChannel(channelID, name)
+
+	if cs.DiscordChannel == "" || !cs.Setting.SyncChannelName || oldName == name {
+		return
+	}
+
+	discordName, ok := h.settings.ChannelMap().DiscordChannelNameFor(guildID, name)
+	if !ok || discordName == h.settings.ChannelMap().DiscordChannelName(guildID, cs.DiscordChannel) {
+		return
+	}
+
+	// the channel map follows the ChannelUpdate event
+	var err = h.editDiscordChannel(cs.DiscordChannel, map[string]string{"name": discordName})
+	if err != nil {
+		h.logger.Error("EditDiscordChannelName", "error", err)
+	}
+}
+
+// SlackTopicChanged copies the Slack topic, or the purpose if no topic is set, to Discord
+func (h *ChannelSyncHandler) SlackTopicChanged(channelID string) {
+	var cs, _ = h.settings.LookupDiscordChannel(channelID)
+	if cs.DiscordChannel == "" || !cs.Setting.SyncChannelTopic {
+		return
+	}
+
+	info, err := h.slack.GetConversationInfo(channelID, false)
+	if err != nil {
+		h.logger.Error("GetConversationInfo", "error", err)
+		return
+	}
+
+	channel, err := h.discord.State.Channel(cs.DiscordChannel)
+	if err != nil {
+		channel, err = h.discord.Channel(cs.DiscordChannel)
+		if err != nil {
+			h.logger.Error("GetDiscordChannel", "error", err)
+			return
+		}
+	}
+
+	var topic = slackChannelTopic(info)
+	if channel.Topic == topic {
+		return
+	}
+
+	err = h.editDiscordChannel(cs.DiscordChannel, map[string]string{"topic": topic})
+	if err != nil {
+		h.logger.Error("EditDiscordChannelTopic", "error", err)
+	}
+}
+
+// SlackChannelArchived stops the transfer to the archived Slack channel
+func (h *ChannelSyncHandler) SlackChannelArchived(channelID string) {
+	h.settings.RemoveSlackChannel(channelID)
+}
+
+// SlackChannelUnarchived restores the pair of the unarchived Slack channel
+func (h *ChannelSyncHandler) SlackChannelUnarchived(channelID string) {
+	info, err := h.slack.GetConversationInfo(channelID, false)
+	if err != nil {
+		h.logger.Error("GetConversationInfo", "error", err)
+		return
+	}
+	h.settings.UpdateSlackChannel(channelID, info.Name)
+}
+
+// pairedSlackChannel returns the setting of the Discord channel, and whether its Slack channel is sent back to it.
+// Slack channels shared by several Discord channels are never renamed, archived or given members.
+func pairedSlackChannel(settings *settings.Handler, guildID, discordID string) (settings.ChannelSetting, bool) {
+	var cs = settings.LookupSlackChannel(discordID, guildID)
+	if cs.SlackChannel == "" {
+		return cs, false
+	}
+
+	var back, _ = settings.LookupDiscordChannel(cs.SlackChannel)
+	return cs, back.DiscordChannel == discordID
+}
+
+// editDiscordChannel sends only the given fields, since discordgo.ChannelEdit always resets the position
+func (h *ChannelSyncHandler) editDiscordChannel(channelID string, data map[string]string) error {
+	var endpoint = discordgo.EndpointChannel(channelID)
+	_, err := h.discord.RequestWithBucketID("PATCH", endpoint, data, endpoint)
+	return err
+}
+
+func slackChannelTopic(info *slack.Channel) string {
+	if info.Topic.Value != "" {
+		return html.UnescapeString(info.Topic.Value)
+	}
+	return html.UnescapeString(info.Purpose.Value)
+}
@@ -0,0 +1,68 @@
+package configurator
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+)
+
+// apiError is an error with the status to respond with, and is the JSON error body of the REST API
+type apiError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"error"`
+	Message string `json:"message,omitempty"`
+	// Problems are the errors of the settings found by the validation
+	Problems []settings.Problem `json:"problems,omitempty"`
+}
+
+func newAPIError(status int, code, message string) *apiError {
+	return &apiError{Status: status, Code: code, Message: message}
+}
+
+func (e *apiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// text returns the error in the plain text of the action API, such as "BadRequest: InvalidSettings"
+func (e *apiError) text() string {
+	var text = strings.Replace(http.StatusText(e.Status), " ", "", -1) + ": " + e.Code
+	if e.Message != "" {
+		text += "\n" + e.Message
+	}
+	return text
+}
+
+func toAPIError(err error) *apiError {
+	if e, ok := err.(*apiError); ok {
+		return e
+	}
+	return newAPIError(500, "InternalServerError", err.Error())
+}
+
+// writeJSON writes the value with the status, or only the status if the value is nil
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	if v == nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, newAPIError(500, "JsonEncodeError", err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(b, '\n'))
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var e = toAPIError(err)
+	if e.Status >= 500 {
+		logger.Error("APIError", "status", e.Status, "error", e)
+	}
+	writeJSON(w, e.Status, e)
+}
@@ -0,0 +1,557 @@
+package configurator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+)
+
+// OpenAPIFile describes the REST API under /api/v1
+const OpenAPIFile = "static/openapi.yaml"
+
+// Mapping is a channel setting with the guild it belongs to
+type Mapping struct {
+	Guild string `json:"guild"`
+	settings.ChannelSetting
+}
+
+// apiHandler returns the status and the body of the response, where the params are the wildcards of the path
+type apiHandler func(w http.ResponseWriter, r *http.Request, params []string) (int, interface{}, error)
+
+type apiRoute struct {
+	// pattern is the path under /api/v1, where "*" matches any segment
+	pattern string
+	methods map[string]apiHandler
+}
+
+func (s *SettingsHandler) apiRoutes() []apiRoute {
+	return []apiRoute{
+		{"settings", map[string]apiHandler{"GET": s.apiListGuilds, "PUT": s.apiPutSettings}},
+		{"settings/validate", map[string]apiHandler{"POST": s.apiValidateSettings}},
+		{"guilds", map[string]apiHandler{"GET": s.apiListGuilds, "POST": s.apiCreateGuild}},
+		{"guilds/*", map[string]apiHandler{"GET": s.apiGetGuild, "PUT": s.apiPutGuild, "DELETE": s.apiDeleteGuild}},
+		{"guilds/*/mappings", map[string]apiHandler{"GET": s.apiListMappings, "POST": s.apiCreateMapping}},
+		{"mappings/*", map[string]apiHandler{
+			"GET": s.apiGetMapping, "PUT": s.apiPutMapping, "PATCH": s.apiPatchMapping, "DELETE": s.apiDeleteMapping,
+		}},
+		{"versions", map[string]apiHandler{"GET": s.apiListVersions}},
+		{"versions/*", map[string]apiHandler{"GET": s.apiGetVersion}},
+		{"versions/*/rollback", map[string]apiHandler{"POST": s.apiRollback}},
+	}
+}
+
+// ServeAPIv1 serves the REST API, whose path prefix is stripped
+func (s *SettingsHandler) ServeAPIv1(w http.ResponseWriter, r *http.Request) {
+	var path = strings.Trim(r.URL.Path, "/")
+
+	// the description is public, as it is the same as in the repository
+	if path == "openapi.yaml" {
+		b, err := fs.ReadFile(s.Assets, OpenAPIFile)
+		if err != nil {
+			writeError(w, newAPIError(404, "NotFound", err.Error()))
+			return
+		}
+		w.Header().Set("Content-type", "application/yaml")
+		w.Write(b)
+		return
+	}
+
+	r, authErr := s.Auth.withSession(r)
+	if authErr != nil {
+		writeError(w, authErr)
+		return
+	}
+
+	for _, route := range s.apiRoutes() {
+		params, ok := matchAPIPath(route.pattern, path)
+		if !ok {
+			continue
+		}
+
+		handler, ok := route.methods[r.Method]
+		if !ok {
+			var allowed []string
+			for method := range route.methods {
+				allowed = append(allowed, method)
+			}
+			sort.Strings(allowed)
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeError(w, newAPIError(405, "MethodNotAllowed", r.Method))
+			return
+		}
+
+		status, body, err := handler(w, r, params)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, status, body)
+		return
+	}
+
+	writeError(w, newAPIError(404, "NotFound", r.URL.Path))
+}
+
+func matchAPIPath(pattern, path string) ([]string, bool) {
+	var patterns, segments = strings.Split(pattern, "/"), strings.Split(path, "/")
+	if len(patterns) != len(segments) {
+		return nil, false
+	}
+
+	var params []string
+	for i := range patterns {
+		switch {
+		case patterns[i] == "*" && segments[i] != "":
+			params = append(params, segments[i])
+		case patterns[i] != segments[i]:
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// decodeJSON decodes the body, where unknown fields are rejected to find typos in scripts
+func decodeJSON(r *http.Request, v interface{}) error {
+	var decoder = json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var err = decoder.Decode(v)
+	if err != nil {
+		return newAPIError(400, "InvalidJSON", err.Error())
+	}
+	return nil
+}
+
+func (s *SettingsHandler) currentSettings() ([]settings.SlackDiscordTable, error) {
+	table, err := s.settings.GetChannelMap()
+	if err != nil {
+		return nil, newAPIError(500, "GetChannelMap", err.Error())
+	}
+	settings.AssignMappingIDs(table)
+	return table, nil
+}
+
+// applyChange changes the current settings and writes them, or validates them without writing for dry_run=yes.
+// The status and the body are responded if the settings are written.
+func (s *SettingsHandler) applyChange(
+	w http.ResponseWriter, r *http.Request,
+	change func(table []settings.SlackDiscordTable) ([]settings.SlackDiscordTable, int, interface{}, error),
+) (int, interface{}, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	current, err := s.currentSettings()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	table, status, body, err := change(current)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if r.FormValue("dry_run") == "yes" {
+		return 200, s.validate(table).For(sessionFromRequest(r)), nil
+	}
+
+	var comment = r.FormValue("comment")
+	if comment == "" {
+		comment = fmt.Sprintf("%s /api/v1%s", r.Method, r.URL.Path)
+	}
+
+	version, err := s.commitSettings(sessionFromRequest(r), table, comment, r.FormValue("force") == "yes")
+	if err != nil {
+		return 0, nil, err
+	}
+	w.Header().Set("X-Settings-Version", strconv.Itoa(version.ID))
+
+	return status, body, nil
+}
+
+func requireGuild(r *http.Request, guildID string) error {
+	if !sessionFromRequest(r).CanManage(guildID) {
+		return newAPIError(403, "ManageGuildRequired", guildID)
+	}
+	return nil
+}
+
+func findGuild(table []settings.SlackDiscordTable, guildID string) int {
+	for i, guild := range table {
+		if guild.Discord == guildID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *SettingsHandler) apiPutSettings(w http.ResponseWriter, r *http.Request, params []string) (int, interface{}, error) {
+	var requested []settings.SlackDiscordTable
+	var err = decodeJSON(r, &requested)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return s.applyChange(w, r, func(table []settings.SlackDiscordTable) ([]settings.SlackDiscordTable, int, interface{}, error) {
+		settings.AssignMappingIDs(requested)
+		return requested, 200, filterSettings(sessionFromRequest(r), requested), nil
+	})
+}
+
+func (s *SettingsHandler) apiValidateSettings(w http.ResponseWriter, r *http.Request, params []string) (int, interface{}, error) {
+	var table []settings.SlackDiscordTable
+	var err = decodeJSON(r, &table)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var session = sessionFromRequest(r)
+	if !session.AllGuilds {
+		current, err := s.currentSettings()
+		if err != nil {
+			return 0, nil, err
+		}
+		table, err = mergeSettings(session, current, table)
+		if err != nil {
+			return 0, nil, newAPIError(403, "ManageGuildRequired", err.Error())
+		}
+	}
+	return 200, s.validate(table).For(session), nil
+}
+
+func (s *SettingsHandler) apiListGuilds(w http.ResponseWriter, r *http.Request, params []string) (int, interface{}, error) {
+	table, err := s.currentSettings()
+	if err != nil {
+		return 0, nil, err
+	}
+	return 200, filterSettings(sessionFromRequest(r), table), nil
+}
+
+func (s *SettingsHandler) apiCreateGuild(w http.ResponseWriter, r *http.Request, params []string) (int, interface{}, error) {
+	var guild settings.SlackDiscordTable
+	var err = decodeJSON(r, &guild)
+	if err != nil {
+		return 0, nil, err
+	}
+	if guild.Discord == "" {
+		return 0, nil, newAPIError(400, "DiscordServerRequired", "discord_server")
+	}
+	err = requireGuild(r, guild.Discord)
+	if err != nil {
+		return 0, nil, err
+	}
+	if guild.Channel == nil {
+		guild.Channel = []settings.ChannelSetting{}
+	}
+
+	return s.applyChange(w, r, func(table []settings.SlackDiscordTable) ([]settings.SlackDiscordTable, int, interface{}, error) {
+		if findGuild(table, guild.Discord) >= 0 {
+			return nil, 0, nil, newAPIError(409, "GuildExists", guild.Discord)
+		}
+		table = append(table, guild)
+		settings.AssignMappingIDs(table)
+
+		w.Header().Set("Location", "guilds/"+guild.Discord)
+		return table, 201, table[len(table)-1], nil
+	})
+}
+
+func (s *SettingsHandler) apiGetGuild(w http.ResponseWriter, r *http.Request, params []string) (int, interface{}, error) {
+	var err = requireGuild(r, params[0])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	table, err := s.currentSettings()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var i = findGuild(table, params[0])
+	if i < 0 {
+		return 0, nil, newAPIError(404, "GuildNotFound", params[0])
+	}
+	return 200, table[i], nil
+}
+
+// apiPutGuild replaces the settings of the guild, where the mappings are kept if "channel" is omitted
+func (s *SettingsHandler) apiPutGuild(w http.ResponseWriter, r *http.Request, params []string) (int, interface{}, error) {
+	var guild settings.SlackDiscordTable
+	var err = decodeJSON(r, &guild)
+	if err != nil {
+		return 0, nil, err
+	}
+	if guild.Discord != "" && guild.Discord != params[0] {
+		return 0, nil, newAPIError(400, "GuildMismatch", guild.Discord)
+	}
+	guild.Discord = params[0]
+	err = requireGuild(r, guild.Discord)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return s.applyChange(w, r, func(table []settings.SlackDiscordTable) ([]settings.SlackDiscordTable, int, interface{}, error) {
+		var i = findGuild(table, guild.Discord)
+		if i < 0 {
+			if guild.Channel == nil {
+				guild.Channel = []settings.ChannelSetting{}
+			}
+			table = append(table, guild)
+			settings.AssignMappingIDs(table)
+			return table, 201, table[len(table)-1], nil
+		}
+
+		if guild.Channel == nil {
+			guild.Channel = table[i].Channel
+		}
+		table[i] = guild
+		settings.AssignMappingIDs(table)
+		return table, 200, table[i], nil
+	})
+}
+
+func (s *SettingsHandler) apiDeleteGuild(w http.ResponseWriter, r *http.Request, params []string) (int, interface{}, error) {
+	var err = requireGuild(r, params[0])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return s.applyChange(w, r, func(table []settings.SlackDiscordTable) ([]settings.SlackDiscordTable, int, interface{}, error) {
+		var kept []settings.SlackDiscordTable
+		for _, guild := range table {
+			if guild.Discord != params[0] {
+				kept = append(kept, guild)
+			}
+		}
+		if len(kept) == len(table) {
+			return nil, 0, nil, newAPIError(404, "GuildNotFound", params[0])
+		}
+		if kept == nil {
+			kept = []settings.SlackDiscordTable{}
+		}
+		return kept, 204, nil, nil
+	})
+}
+
+func (s *SettingsHandler) apiListMappings(w http.ResponseWriter, r *http.Request, params []string) (int, interface{}, error) {
+	var err = requireGuild(r, params[0])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	table, err := s.currentSettings()
+	if err != nil {
+		return 0, nil, err
+	}
+	if findGuild(table, params[0]) < 0 {
+		return 0, nil, newAPIError(404, "GuildNotFound", params[0])
+	}
+
+	var mappings = []Mapping{}
+	for _, guild := range table {
+		if guild.Discord != params[0] {
+			continue
+		}
+		for _, c := range guild.Channel {
+			mappings = append(mappings, Mapping{Guild: guild.Discord, ChannelSetting: c})
+		}
+	}
+	return 200, mappings, nil
+}
+
+// apiCreateMapping adds the mapping at the end of the guild, or before the mapping at the position,
+// as the mappings are tried in order
+func (s *SettingsHandler) apiCreateMapping(w http.ResponseWriter, r *http.Request, params []string) (int, interface{}, error) {
+	var mapping Mapping
+	var err = decodeJSON(r, &mapping)
+	if err != nil {
+		return 0, nil, err
+	}
+	if mapping.Guild != "" && mapping.Guild != params[0] {
+		return 0, nil, newAPIError(400, "GuildMismatch", mapping.Guild)
+	}
+	mapping.Guild = params[0]
+	err = requireGuild(r, mapping.Guild)
+	if err != nil {
+		return 0, nil, err
+	}
+	if mapping.ID == "" {
+		mapping.ID = randomToken()[:12]
+	}
+
+	return s.applyChange(w, r, func(table []settings.SlackDiscordTable) ([]settings.SlackDiscordTable, int, interface{}, error) {
+		var i = findGuild(table, mapping.Guild)
+		if i < 0 {
+			return nil, 0, nil, newAPIError(404, "GuildNotFound", mapping.Guild)
+		}
+		if _, _, ok := settings.FindMapping(table, mapping.ID); ok {
+			return nil, 0, nil, newAPIError(409, "MappingExists", mapping.ID)
+		}
+
+		var channels = table[i].Channel
+		var position = len(channels)
+		if r.FormValue("position") != "" {
+			var err error
+			position, err = strconv.Atoi(r.FormValue("position"))
+			if err != nil || position < 0 || position > len(channels) {
+				return nil, 0, nil, newAPIError(400, "InvalidPosition", r.FormValue("position"))
+			}
+		}
+
+		var inserted = append([]settings.ChannelSetting{}, channels[:position]...)
+		inserted = append(inserted, mapping.ChannelSetting)
+		table[i].Channel = append(inserted, channels[position:]...)
+
+		w.Header().Set("Location", "../../mappings/"+mapping.ID)
+		return table, 201, mapping, nil
+	})
+}
+
+func (s *SettingsHandler) apiGetMapping(w http.ResponseWriter, r *http.Request, params []string) (int, interface{}, error) {
+	table, err := s.currentSettings()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// the mappings of the other guilds are not found, so that their IDs tell nothing
+	i, j, ok := settings.FindMapping(table, params[0])
+	if !ok || !sessionFromRequest(r).CanManage(table[i].Discord) {
+		return 0, nil, newAPIError(404, "MappingNotFound", params[0])
+	}
+	return 200, Mapping{Guild: table[i].Discord, ChannelSetting: table[i].Channel[j]}, nil
+}
+
+// changeMapping applies the change to the mapping in its place, as the guild of a mapping can not be changed
+func (s *SettingsHandler) changeMapping(
+	w http.ResponseWriter, r *http.Request, id string, change func(mapping *Mapping) error,
+) (int, interface{}, error) {
+	return s.applyChange(w, r, func(table []settings.SlackDiscordTable) ([]settings.SlackDiscordTable, int, interface{}, error) {
+		i, j, ok := settings.FindMapping(table, id)
+		if !ok {
+			return nil, 0, nil, newAPIError(404, "MappingNotFound", id)
+		}
+		var guild = table[i].Discord
+		var err = requireGuild(r, guild)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+
+		var mapping = Mapping{Guild: guild, ChannelSetting: table[i].Channel[j]}
+		err = change(&mapping)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		if mapping.Guild != guild {
+			return nil, 0, nil, newAPIError(400, "GuildMismatch", mapping.Guild)
+		}
+		if mapping.ID != id {
+			return nil, 0, nil, newAPIError(400, "MappingIDMismatch", mapping.ID)
+		}
+
+		table[i].Channel[j] = mapping.ChannelSetting
+		return table, 200, mapping, nil
+	})
+}
+
+func (s *SettingsHandler) apiPutMapping(w http.ResponseWriter, r *http.Request, params []string) (int, interface{}, error) {
+	var requested Mapping
+	var err = decodeJSON(r, &requested)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return s.changeMapping(w, r, params[0], func(mapping *Mapping) error {
+		if requested.Guild == "" {
+			requested.Guild = mapping.Guild
+		}
+		if requested.ID == "" {
+			requested.ID = mapping.ID
+		}
+		*mapping = requested
+		return nil
+	})
+}
+
+// apiPatchMapping changes only the fields in the body, including the fields of "setting"
+func (s *SettingsHandler) apiPatchMapping(w http.ResponseWriter, r *http.Request, params []string) (int, interface{}, error) {
+	var body json.RawMessage
+	var err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return 0, nil, newAPIError(400, "InvalidJSON", err.Error())
+	}
+
+	return s.changeMapping(w, r, params[0], func(mapping *Mapping) error {
+		var decoder = json.NewDecoder(strings.NewReader(string(body)))
+		decoder.DisallowUnknownFields()
+		var err = decoder.Decode(mapping)
+		if err != nil {
+			return newAPIError(400, "InvalidJSON", err.Error())
+		}
+		return nil
+	})
+}
+
+func (s *SettingsHandler) apiDeleteMapping(w http.ResponseWriter, r *http.Request, params []string) (int, interface{}, error) {
+	return s.applyChange(w, r, func(table []settings.SlackDiscordTable) ([]settings.SlackDiscordTable, int, interface{}, error) {
+		i, j, ok := settings.FindMapping(table, params[0])
+		if !ok {
+			return nil, 0, nil, newAPIError(404, "MappingNotFound", params[0])
+		}
+		var err = requireGuild(r, table[i].Discord)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+
+		var channels = table[i].Channel
+		table[i].Channel = append(append([]settings.ChannelSetting{}, channels[:j]...), channels[j+1:]...)
+		return table, 204, nil, nil
+	})
+}
+
+func (s *SettingsHandler) apiListVersions(w http.ResponseWriter, r *http.Request, params []string) (int, interface{}, error) {
+	versions, err := s.versions(sessionFromRequest(r))
+	if err != nil {
+		return 0, nil, newAPIError(500, "GetSettingsVersionsError", err.Error())
+	}
+	return 200, versions, nil
+}
+
+func (s *SettingsHandler) apiGetVersion(w http.ResponseWriter, r *http.Request, params []string) (int, interface{}, error) {
+	id, err := strconv.Atoi(params[0])
+	if err != nil {
+		return 0, nil, newAPIError(400, "InvalidVersion", params[0])
+	}
+
+	version, diff, err := s.versionDiff(sessionFromRequest(r), id)
+	if err != nil {
+		return 0, nil, newAPIError(404, "VersionNotFound", params[0])
+	}
+	return 200, struct {
+		Version settings.Version `json:"version"`
+		Diff    string           `json:"diff"`
+	}{version, diff}, nil
+}
+
+func (s *SettingsHandler) apiRollback(w http.ResponseWriter, r *http.Request, params []string) (int, interface{}, error) {
+	id, err := strconv.Atoi(params[0])
+	if err != nil {
+		return 0, nil, newAPIError(400, "InvalidVersion", params[0])
+	}
+
+	requested, err := s.settings.VersionSettings(id)
+	if err != nil {
+		return 0, nil, newAPIError(404, "VersionNotFound", params[0])
+	}
+
+	if r.FormValue("comment") == "" {
+		r.Form.Set("comment", fmt.Sprintf("バージョン%dに戻す", id))
+	}
+	return s.applyChange(w, r, func(table []settings.SlackDiscordTable) ([]settings.SlackDiscordTable, int, interface{}, error) {
+		return requested, 200, filterSettings(sessionFromRequest(r), requested), nil
+	})
+}
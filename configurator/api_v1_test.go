@@ -0,0 +1,133 @@
+package configurator
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+)
+
+func TestAPIv1Mappings(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "settings.json")
+	var err = ioutil.WriteFile(path, []byte(`[{"discord_server": "G1", "channel": [{"slack": "S1", "discord": "D1"}]}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s = NewSettingsHandler(settings.New("", "", path), nil, nil)
+	s.controller = make(chan int, 10)
+
+	var request = func(method, target, body string) (int, string) {
+		var w = httptest.NewRecorder()
+		s.ServeAPIv1(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w.Code, w.Body.String()
+	}
+
+	code, body := request("GET", "/guilds/G1/mappings", "")
+	var mappings []Mapping
+	if code != 200 || json.Unmarshal([]byte(body), &mappings) != nil || len(mappings) != 1 || mappings[0].ID == "" {
+		t.Fatalf("list: %d %s", code, body)
+	}
+	var first = mappings[0].ID
+
+	code, body = request("POST", "/guilds/G1/mappings?force=yes&position=0", `{"slack": "S2", "discord": "D2"}`)
+	var created Mapping
+	if code != 201 || json.Unmarshal([]byte(body), &created) != nil || created.ID == "" {
+		t.Fatalf("create: %d %s", code, body)
+	}
+
+	code, body = request("PATCH", "/mappings/"+first+"?force=yes", `{"comment": "patched", "setting": {"slack2discord": true}}`)
+	if code != 200 || !strings.Contains(body, `"patched"`) || !strings.Contains(body, `"discord":"D1"`) {
+		t.Fatalf("patch: %d %s", code, body)
+	}
+
+	code, body = request("DELETE", "/mappings/"+created.ID+"?force=yes", "")
+	if code != 204 {
+		t.Fatalf("delete: %d %s", code, body)
+	}
+
+	// the IDs are kept after the settings are written
+	code, body = request("GET", "/mappings/"+first, "")
+	if code != 200 || !strings.Contains(body, `"slack2discord":true`) {
+		t.Errorf("get: %d %s", code, body)
+	}
+
+	code, body = request("GET", "/mappings/"+created.ID, "")
+	if code != 404 || !strings.Contains(body, `"error":"MappingNotFound"`) {
+		t.Errorf("deleted: %d %s", code, body)
+	}
+
+	code, body = request("POST", "/mappings/"+first, "")
+	if code != 405 {
+		t.Errorf("method: %d %s", code, body)
+	}
+
+	code, body = request("PATCH", "/mappings/"+first+"?force=yes", `{"slak": "S3"}`)
+	if code != 400 {
+		t.Errorf("unknown field: %d %s", code, body)
+	}
+
+	versions, err := s.settings.Versions()
+	if err != nil || len(versions) != 4 {
+		t.Errorf("versions: %d %v", len(versions), err)
+	}
+}
+
+func TestAPIv1Guilds(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "settings.json")
+	var err = ioutil.WriteFile(path, []byte(`[
+		{"discord_server": "G1", "channel": [{"id": "M1", "slack": "S1", "discord": "D1"}]},
+		{"discord_server": "G2", "channel": [{"id": "M2", "slack": "S2", "discord": "D2"}]}
+	]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s = NewSettingsHandler(settings.New("", "", path), nil, nil)
+	s.Auth = NewAuthenticator(AuthConfig{}, nil, nil)
+	s.Auth.sessions["id"] = &Session{UserName: "user", Guilds: map[string]bool{"G1": true}, id: "id", expires: time.Now().Add(time.Hour)}
+
+	// the first version is the file written before, and the second changes only G2
+	table, _ := s.settings.GetChannelMap()
+	table[1].SlackSuffix = "-g2"
+	_, err = s.settings.Commit(table, "admin", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var request = func(target string) (int, string) {
+		var r = httptest.NewRequest("GET", target, nil)
+		r.Header.Set("Cookie", sessionCookie+"=id")
+		var w = httptest.NewRecorder()
+		s.ServeAPIv1(w, r)
+		return w.Code, w.Body.String()
+	}
+
+	var cases = []struct {
+		target string
+		want   int
+		// shown must be in the body, and hidden must not
+		shown, hidden string
+	}{
+		{"/guilds", 200, `"G1"`, `"G2"`},
+		{"/guilds/G1", 200, `"M1"`, ""},
+		{"/guilds/G2", 403, "ManageGuildRequired", ""},
+		{"/guilds/G2/mappings", 403, "ManageGuildRequired", ""},
+		{"/mappings/M1", 200, `"D1"`, ""},
+		{"/mappings/M2", 404, "MappingNotFound", ""},
+		{"/versions", 200, `"id":1`, `"id":2`},
+		{"/versions/1", 200, "G1", "G2"},
+		{"/versions/2", 404, "VersionNotFound", ""},
+	}
+	for _, c := range cases {
+		code, body := request(c.target)
+		if code != c.want || !strings.Contains(body, c.shown) || (c.hidden != "" && strings.Contains(body, c.hidden)) {
+			t.Errorf("%s: %d %s", c.target, code, body)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package configurator
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+)
+
+const (
+	// SessionTTL is how long a login lasts
+	SessionTTL = 24 * time.Hour
+	// LoginStateTTL is how long the login can take at the provider
+	LoginStateTTL = 10 * time.Minute
+
+	sessionCookie = "configurator_session"
+	stateCookie   = "configurator_login_state"
+	csrfHeader    = "X-CSRF-Token"
+)
+
+// AuthConfig enables the login to the configurator. Without any provider or token,
+// the configurator trusts the X-Forwarded-User header of the reverse proxy, and anyone reaching it can edit all guilds.
+type AuthConfig struct {
+	// BaseURL is the public URL of the configurator including HTTP_PATH_PREFIX, to which the providers redirect
+	BaseURL string
+	Discord OAuthClient
+	Slack   OAuthClient
+	// APITokens are static bearer tokens, which can edit all guilds. Empty tokens are ignored.
+	APITokens []string
+
+	// slackTeams are the teams of the workspaces, whose users can log in with Slack
+	slackTeams []string
+}
+
+type OAuthClient struct {
+	ID     string
+	Secret string
+}
+
+func (c OAuthClient) enabled() bool {
+	return c.ID != "" && c.Secret != ""
+}
+
+func (c AuthConfig) enabled() bool {
+	for _, token := range c.APITokens {
+		if strings.TrimSpace(token) != "" {
+			return true
+		}
+	}
+	return c.Discord.enabled() || c.Slack.enabled()
+}
+
+// Session is a logged in user
+type Session struct {
+	UserName  string
+	CSRFToken string
+	// DiscordID is the user logged in with Discord, who can confirm the user links naming them
+	DiscordID string
+	// Guilds are the guilds where the user has Manage Server, and AllGuilds is set for API tokens
+	Guilds    map[string]bool
+	AllGuilds bool
+
+	id      string
+	expires time.Time
+}
+
+// CanManage reports whether the user can edit the settings of the guild
+func (s *Session) CanManage(guildID string) bool {
+	return s.AllGuilds || s.Guilds[guildID]
+}
+
+// ManageableGuilds returns the guilds the user can edit, which is nil for all guilds
+func (s *Session) ManageableGuilds() []string {
+	if s.AllGuilds {
+		return nil
+	}
+	var guilds = []string{}
+	for guildID := range s.Guilds {
+		guilds = append(guilds, guildID)
+	}
+	return guilds
+}
+
+type Authenticator struct {
+	config   AuthConfig
+	prefix   string
+	discord  *discordgo.Session
+	settings *settings.Handler
+	// permissions returns the permissions of the member in the guild, which is replaced in tests
+	permissions func(guildID, userID string) (int64, error)
+
+	sessions map[string]*Session
+	mu       sync.Mutex
+}
+
+func NewAuthenticator(config AuthConfig, discord *discordgo.Session, settings *settings.Handler) *Authenticator {
+	return &Authenticator{
+		config:   config,
+		discord:  discord,
+		settings: settings,
+		permissions: func(guildID, userID string) (int64, error) {
+			return guildPermissions(discord, guildID, userID)
+		},
+		sessions: map[string]*Session{},
+	}
+}
+
+type sessionKey struct{}
+
+// sessionFromRequest returns the session set by authenticate
+func sessionFromRequest(r *http.Request) *Session {
+	session, _ := r.Context().Value(sessionKey{}).(*Session)
+	if session == nil {
+		return &Session{}
+	}
+	return session
+}
+
+// authenticate returns the request with the session of the user, or writes the error and returns nil
+func (a *Authenticator) authenticate(w http.ResponseWriter, r *http.Request) *http.Request {
+	r, err := a.withSession(r)
+	if err != nil {
+		w.WriteHeader(err.Status)
+		w.Write([]byte(err.text()))
+		return nil
+	}
+	return r
+}
+
+// withSession returns the request with the session of the user.
+// Without the authenticator, the user named by the reverse proxy can edit all guilds.
+func (a *Authenticator) withSession(r *http.Request) (*http.Request, *apiError) {
+	var session *Session
+	switch {
+	case a == nil:
+		session = &Session{UserName: r.Header.Get("X-Forwarded-User"), AllGuilds: true}
+	case strings.HasPrefix(r.Header.Get("Authorization"), "Bearer "):
+		// requests with a token carry no cookie, and need no CSRF token
+		if !a.validToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+			return nil, newAPIError(401, "InvalidToken", "")
+		}
+		session = &Session{UserName: "api", AllGuilds: true}
+	default:
+		session = a.session(r)
+		if session == nil {
+			return nil, newAPIError(401, "LoginRequired", "")
+		}
+		if r.Method != "GET" && r.Method != "HEAD" &&
+			subtle.ConstantTimeCompare([]byte(r.Header.Get(csrfHeader)), []byte(session.CSRFToken)) != 1 {
+			return nil, newAPIError(403, "InvalidCSRFToken", "")
+		}
+		// the sessions without guilds are refused at login, and are checked again here
+		if !session.AllGuilds && len(session.Guilds) == 0 {
+			return nil, newAPIError(403, "ManageGuildRequired", "")
+		}
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), sessionKey{}, session)), nil
+}
+
+func (a *Authenticator) validToken(token string) bool {
+	var valid bool
+	for _, t := range a.config.APITokens {
+		t = strings.TrimSpace(t)
+		if t != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// LoggedIn reports whether the browser has a session, and is true without the authenticator
+func (a *Authenticator) LoggedIn(r *http.Request) bool {
+	return a == nil || a.session(r) != nil
+}
+
+func (a *Authenticator) session(r *http.Request) *Session {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	session, ok := a.sessions[cookie.Value]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(session.expires) {
+		delete(a.sessions, cookie.Value)
+		return nil
+	}
+	return session
+}
+
+// login starts the session of the user and returns to the configurator
+func (a *Authenticator) login(w http.ResponseWriter, r *http.Request, session *Session) {
+	// the users who can manage no guilds can not see anything
+	if !session.AllGuilds && len(session.Guilds) == 0 {
+		logger.Warn("LoginRejected", "user", session.UserName)
+		w.WriteHeader(403)
+		w.Write([]byte("Forbidden: ManageGuildRequired"))
+		return
+	}
+
+	session.id = randomToken()
+	session.CSRFToken = randomToken()
+	session.expires = time.Now().Add(SessionTTL)
+
+	a.mu.Lock()
+	for id, s := range a.sessions {
+		if time.Now().After(s.expires) {
+			delete(a.sessions, id)
+		}
+	}
+	a.sessions[session.id] = session
+	a.mu.Unlock()
+
+	logger.Info("Login", "user", session.UserName, "guilds", len(session.Guilds))
+
+	a.setCookie(w, sessionCookie, session.id, SessionTTL)
+	http.Redirect(w, r, a.prefix+"/", http.StatusFound)
+}
+
+func (a *Authenticator) logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		a.mu.Lock()
+		delete(a.sessions, cookie.Value)
+		a.mu.Unlock()
+	}
+	a.setCookie(w, sessionCookie, "", -1)
+	http.Redirect(w, r, a.prefix+"/auth/login", http.StatusFound)
+}
+
+func (a *Authenticator) setCookie(w http.ResponseWriter, name, value string, ttl time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     a.prefix + "/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(a.config.BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// newState returns the state passed to the provider, which is also kept in the browser against login CSRF
+func (a *Authenticator) newState(w http.ResponseWriter) string {
+	var state = randomToken()
+	a.setCookie(w, stateCookie, state, LoginStateTTL)
+	return state
+}
+
+func (a *Authenticator) checkState(w http.ResponseWriter, r *http.Request) bool {
+	cookie, err := r.Cookie(stateCookie)
+	a.setCookie(w, stateCookie, "", -1)
+	return err == nil && cookie.Value != "" &&
+		subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.FormValue("state"))) == 1
+}
+
+func (a *Authenticator) redirectURI(provider string) string {
+	return strings.TrimSuffix(a.config.BaseURL, "/") + "/auth/" + provider + "/callback"
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head><meta charset="utf-8"><title>DiscordSlackSynchronizer</title></head>
+<body>
+<h1>ログイン</h1>
+<ul>
+{{if .Discord}}<li><a href="{{.Prefix}}/auth/discord/login">Discordでログイン</a></li>{{end}}
+{{if .Slack}}<li><a href="{{.Prefix}}/auth/slack/login">Slackでログイン</a></li>{{end}}
+</ul>
+</body>
+</html>
+`))
+
+// ServeHTTP serves the login and logout under /auth/
+func (a *Authenticator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimPrefix(r.URL.Path, a.prefix+"/auth/") {
+	case "login":
+		var err = loginPage.Execute(w, struct {
+			Prefix         string
+			Discord, Slack bool
+		}{a.prefix, a.config.Discord.enabled(), a.config.Slack.enabled()})
+		if err != nil {
+			logger.Error("LoginPage", "error", err)
+		}
+	case "logout":
+		a.logout(w, r)
+	case "discord/login":
+		a.discordLogin(w, r)
+	case "discord/callback":
+		a.discordCallback(w, r)
+	case "slack/login":
+		a.slackLogin(w, r)
+	case "slack/callback":
+		a.slackCallback(w, r)
+	default:
+		w.WriteHeader(404)
+		w.Write([]byte("NotFound"))
+	}
+}
+
+func randomToken() string {
+	var b = make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,115 @@
+package configurator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+)
+
+const (
+	DiscordAuthorizeEndpoint = "https://discord.com/api/oauth2/authorize"
+	DiscordTokenEndpoint     = "https://discord.com/api/oauth2/token"
+)
+
+func (a *Authenticator) discordLogin(w http.ResponseWriter, r *http.Request) {
+	if !a.config.Discord.enabled() {
+		w.WriteHeader(404)
+		w.Write([]byte("NotFound: DiscordLoginDisabled"))
+		return
+	}
+
+	var query = url.Values{
+		"client_id":     {a.config.Discord.ID},
+		"redirect_uri":  {a.redirectURI("discord")},
+		"response_type": {"code"},
+		"scope":         {"identify guilds"},
+		"state":         {a.newState(w)},
+	}
+	http.Redirect(w, r, DiscordAuthorizeEndpoint+"?"+query.Encode(), http.StatusFound)
+}
+
+func (a *Authenticator) discordCallback(w http.ResponseWriter, r *http.Request) {
+	if !a.config.Discord.enabled() || !a.checkState(w, r) {
+		w.WriteHeader(400)
+		w.Write([]byte("BadRequest: InvalidLoginState"))
+		return
+	}
+
+	token, err := a.discordToken(r.FormValue("code"))
+	if err != nil {
+		logger.Warn("DiscordToken", "error", err)
+		w.WriteHeader(401)
+		w.Write([]byte("Unauthorized: DiscordLoginFailed"))
+		return
+	}
+
+	// the session acts as the user, and sees the guilds with the permissions of the user
+	user, err := discordgo.New("Bearer " + token)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: DiscordSessionError\n" + err.Error()))
+		return
+	}
+
+	me, err := user.User("@me")
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: GetDiscordUserError\n" + err.Error()))
+		return
+	}
+
+	guilds, err := user.UserGuilds(100, "", "")
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: GetDiscordGuildsError\n" + err.Error()))
+		return
+	}
+
+	var session = &Session{UserName: me.Username, DiscordID: me.ID, Guilds: map[string]bool{}}
+	for _, guild := range guilds {
+		if guild.Owner || canManageGuild(guild.Permissions) {
+			session.Guilds[guild.ID] = true
+		}
+	}
+
+	a.login(w, r, session)
+}
+
+// discordToken exchanges the code given to the callback for the access token of the user
+func (a *Authenticator) discordToken(code string) (string, error) {
+	var form = url.Values{
+		"client_id":     {a.config.Discord.ID},
+		"client_secret": {a.config.Discord.Secret},
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {a.redirectURI("discord")},
+	}
+
+	resp, err := http.Post(DiscordTokenEndpoint, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errors.Wrap(err, "PostToken")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("TokenStatus: %d", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", errors.Wrap(err, "DecodeToken")
+	}
+	return token.AccessToken, nil
+}
+
+// canManageGuild reports whether the permissions include Manage Server
+func canManageGuild(permissions int64) bool {
+	return permissions&discordgo.PermissionAdministrator != 0 || permissions&discordgo.PermissionManageServer != 0
+}
@@ -0,0 +1,125 @@
+package configurator
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+)
+
+const SlackAuthorizeEndpoint = "https://slack.com/oauth/v2/authorize"
+
+func (a *Authenticator) slackLogin(w http.ResponseWriter, r *http.Request) {
+	if !a.config.Slack.enabled() {
+		w.WriteHeader(404)
+		w.Write([]byte("NotFound: SlackLoginDisabled"))
+		return
+	}
+
+	var query = url.Values{
+		"client_id":    {a.config.Slack.ID},
+		"redirect_uri": {a.redirectURI("slack")},
+		"user_scope":   {"identity.basic"},
+		"state":        {a.newState(w)},
+	}
+	http.Redirect(w, r, SlackAuthorizeEndpoint+"?"+query.Encode(), http.StatusFound)
+}
+
+func (a *Authenticator) slackCallback(w http.ResponseWriter, r *http.Request) {
+	if !a.config.Slack.enabled() || !a.checkState(w, r) {
+		w.WriteHeader(400)
+		w.Write([]byte("BadRequest: InvalidLoginState"))
+		return
+	}
+
+	resp, err := slack.GetOAuthV2Response(
+		http.DefaultClient, a.config.Slack.ID, a.config.Slack.Secret, r.FormValue("code"), a.redirectURI("slack"),
+	)
+	if err != nil {
+		logger.Warn("SlackToken", "error", err)
+		w.WriteHeader(401)
+		w.Write([]byte("Unauthorized: SlackLoginFailed"))
+		return
+	}
+
+	identity, err := slack.New(resp.AuthedUser.AccessToken).GetUserIdentity()
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: GetSlackUserError\n" + err.Error()))
+		return
+	}
+
+	// the user IDs of other teams may collide with the links, which name the users of the workspaces
+	if !a.slackTeam(identity.Team.ID) {
+		logger.Warn("LoginRejected", "user", identity.User.Name, "team", identity.Team.ID, "reason", "UnknownSlackTeam")
+		w.WriteHeader(403)
+		w.Write([]byte("Forbidden: UnknownSlackTeam"))
+		return
+	}
+
+	guilds, err := a.slackUserGuilds(identity.User.ID)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: GetDiscordGuildsError\n" + err.Error()))
+		return
+	}
+
+	a.login(w, r, &Session{UserName: identity.User.Name, Guilds: guilds})
+}
+
+func (a *Authenticator) slackTeam(teamID string) bool {
+	for _, team := range a.config.slackTeams {
+		if team == teamID && teamID != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// slackUserGuilds returns the guilds where the Discord user linked with the Slack user has Manage Server.
+// A link counts only in the guild declaring it and after the Discord user confirmed it,
+// as the manager of another guild can write any link into the settings.
+func (a *Authenticator) slackUserGuilds(slackID string) (map[string]bool, error) {
+	var guilds = map[string]bool{}
+
+	dict, err := a.settings.GetChannelMap()
+	if err != nil {
+		return nil, errors.Wrap(err, "GetChannelMap")
+	}
+
+	for _, guild := range dict {
+		for _, link := range guild.UserLinks {
+			if link.Slack != slackID || link.Discord == "" || guilds[guild.Discord] {
+				continue
+			}
+			if !a.settings.UserLinkConfirmed(guild.Discord, link) {
+				logger.Info("UserLinkNotConfirmed", "guild", guild.Discord, "slack", link.Slack, "discord", link.Discord)
+				continue
+			}
+			permissions, err := a.permissions(guild.Discord, link.Discord)
+			if err != nil {
+				logger.Warn("GuildPermissions", "guild", guild.Discord, "user", link.Discord, "error", err)
+				continue
+			}
+			if canManageGuild(permissions) {
+				guilds[guild.Discord] = true
+			}
+		}
+	}
+	return guilds, nil
+}
+
+// guildPermissions returns the permissions of the member given by the roles, as seen by the bot
+func guildPermissions(session *discordgo.Session, guildID, userID string) (int64, error) {
+	guild, err := session.Guild(guildID)
+	if err != nil {
+		return 0, errors.Wrap(err, "Guild")
+	}
+	member, err := session.GuildMember(guildID, userID)
+	if err != nil {
+		return 0, errors.Wrap(err, "GuildMember")
+	}
+	return memberPermissions(guild, member, nil), nil
+}
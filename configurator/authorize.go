@@ -0,0 +1,137 @@
+package configurator
+
+import (
+	"github.com/kmc-jp/DiscordSlackSynchronizer/dead_letter"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+	"github.com/pkg/errors"
+)
+
+// mergeSettings returns the settings to write, where the guilds the user cannot manage are kept as they are
+// and the requested changes to them are ignored
+func mergeSettings(session *Session, current, requested []settings.SlackDiscordTable) ([]settings.SlackDiscordTable, error) {
+	var kept = map[string][]settings.SlackDiscordTable{}
+	var order []string
+	for _, guild := range current {
+		if session.CanManage(guild.Discord) {
+			continue
+		}
+		if _, ok := kept[guild.Discord]; !ok {
+			order = append(order, guild.Discord)
+		}
+		kept[guild.Discord] = append(kept[guild.Discord], guild)
+	}
+
+	var merged []settings.SlackDiscordTable
+	var placed = map[string]bool{}
+	for _, guild := range requested {
+		if session.CanManage(guild.Discord) {
+			merged = append(merged, guild)
+			continue
+		}
+		if _, ok := kept[guild.Discord]; !ok {
+			return nil, errors.Errorf("ManageGuildRequired: %s", guild.Discord)
+		}
+		// the guild stays at the place where it is requested
+		if !placed[guild.Discord] {
+			merged = append(merged, kept[guild.Discord]...)
+			placed[guild.Discord] = true
+		}
+	}
+
+	// the guilds which are not requested can not be removed either
+	for _, guildID := range order {
+		if !placed[guildID] {
+			merged = append(merged, kept[guildID]...)
+		}
+	}
+	return merged, nil
+}
+
+// foreignDiscordChannels returns the Discord channels and categories in the settings of the guilds the user manages
+// which are not in the guild. They are never written, even if forced, as the bridge would send to the other guilds.
+func foreignDiscordChannels(session *Session, table []settings.SlackDiscordTable, channelGuild func(string) (string, error)) []settings.Problem {
+	var problems []settings.Problem
+	var check = func(guild string, rule int, channelID string) {
+		if channelID == "" || channelID == "all" {
+			return
+		}
+		guildID, err := channelGuild(channelID)
+		if err != nil {
+			problems = append(problems, settings.NewProblem(settings.ProblemError, guild, rule, "Discordのチャンネル%sを確認できません: %s", channelID, err))
+			return
+		}
+		if guildID != guild {
+			problems = append(problems, settings.NewProblem(settings.ProblemError, guild, rule, "Discordのチャンネル%sはサーバにありません", channelID))
+		}
+	}
+
+	for _, guild := range table {
+		if session.AllGuilds || !session.CanManage(guild.Discord) {
+			continue
+		}
+		check(guild.Discord, -1, guild.AdminDiscordChannel)
+		for i, rule := range guild.Channel {
+			check(guild.Discord, i, rule.DiscordChannel)
+			check(guild.Discord, i, rule.DiscordCategory)
+			check(guild.Discord, i, rule.Setting.DiscordChannelCategory)
+		}
+	}
+	return problems
+}
+
+// filterSettings returns the guilds of the settings which the user can manage
+func filterSettings(session *Session, table []settings.SlackDiscordTable) []settings.SlackDiscordTable {
+	if session.AllGuilds && table != nil {
+		return table
+	}
+	var filtered = []settings.SlackDiscordTable{}
+	for _, guild := range table {
+		if session.CanManage(guild.Discord) {
+			filtered = append(filtered, guild)
+		}
+	}
+	return filtered
+}
+
+// canManageChannel reports whether the user can manage the guild of the Discord channel
+func (s *SettingsHandler) canManageChannel(session *Session, discordChannel string) bool {
+	if session.AllGuilds {
+		return true
+	}
+	guildID, err := s.channelGuild(discordChannel)
+	if err != nil {
+		logger.Warn("GetDiscordChannel", "channel", discordChannel, "error", err)
+		return false
+	}
+	return session.CanManage(guildID)
+}
+
+// channelGuild returns the guild of the Discord channel, from the state of the session if it is cached there.
+// The settings are not trusted for it, as a user could write the channels of other guilds in them.
+func (s *SettingsHandler) channelGuild(discordChannel string) (string, error) {
+	if s.Discord == nil || s.Discord.Session == nil {
+		return "", errors.New("DiscordDisabled")
+	}
+	if s.Discord.Session.State != nil {
+		if channel, err := s.Discord.Session.State.Channel(discordChannel); err == nil {
+			return channel.GuildID, nil
+		}
+	}
+	channel, err := s.Discord.Session.Channel(discordChannel)
+	if err != nil {
+		return "", err
+	}
+	return channel.GuildID, nil
+}
+
+// canManageLetter reports whether the user can manage the guild of the channel the failed delivery was sent to
+func (s *SettingsHandler) canManageLetter(session *Session, letter dead_letter.Letter) bool {
+	if session.AllGuilds {
+		return true
+	}
+	if letter.Queue == "discord" {
+		return s.canManageChannel(session, letter.Target)
+	}
+	_, guildID := s.settings.LookupDiscordChannel(letter.Target)
+	return guildID != "" && session.CanManage(guildID)
+}
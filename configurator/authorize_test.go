@@ -0,0 +1,273 @@
+package configurator
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/archive"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/backfill"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/dead_letter"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+	"github.com/pkg/errors"
+)
+
+func TestMergeSettings(t *testing.T) {
+	var session = &Session{Guilds: map[string]bool{"G1": true}}
+	var current = []settings.SlackDiscordTable{
+		{Discord: "G1", SlackSuffix: "old"},
+		{Discord: "G2", SlackSuffix: "kept"},
+		{Discord: "G3", SlackSuffix: "kept"},
+	}
+
+	merged, err := mergeSettings(session, current, []settings.SlackDiscordTable{
+		{Discord: "G2", SlackSuffix: "changed"},
+		{Discord: "G1", SlackSuffix: "new"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want = []string{"G2:kept", "G1:new", "G3:kept"}
+	if len(merged) != len(want) {
+		t.Fatalf("merged: %+v", merged)
+	}
+	for i, guild := range merged {
+		if guild.Discord+":"+guild.SlackSuffix != want[i] {
+			t.Errorf("merged[%d] = %s:%s, want %s", i, guild.Discord, guild.SlackSuffix, want[i])
+		}
+	}
+
+	_, err = mergeSettings(session, current, []settings.SlackDiscordTable{{Discord: "G4"}})
+	if err == nil {
+		t.Error("a guild the user can not manage is added")
+	}
+}
+
+func TestForeignDiscordChannels(t *testing.T) {
+	var guilds = map[string]string{"C1": "G1", "K1": "G1", "C2": "G2"}
+	var channelGuild = func(channelID string) (string, error) {
+		guildID, ok := guilds[channelID]
+		if !ok {
+			return "", errors.New("Unknown Channel")
+		}
+		return guildID, nil
+	}
+
+	var table = []settings.SlackDiscordTable{
+		{Discord: "G1", AdminDiscordChannel: "C2", Channel: []settings.ChannelSetting{
+			{DiscordChannel: "C1"},
+			{DiscordChannel: "all", DiscordCategory: "K1"},
+			{DiscordChannel: "C3"},
+			{DiscordChannel: "all", Setting: settings.SendSetting{DiscordChannelCategory: "C2"}},
+		}},
+		{Discord: "G2", Channel: []settings.ChannelSetting{{DiscordChannel: "C1"}}},
+	}
+
+	var problems = foreignDiscordChannels(&Session{Guilds: map[string]bool{"G1": true}}, table, channelGuild)
+	var rules []int
+	for _, problem := range problems {
+		if problem.Guild != "G1" {
+			t.Errorf("a problem of the guild the user can not manage: %+v", problem)
+		}
+		rules = append(rules, problem.Rule)
+	}
+	if len(rules) != 3 || rules[0] != -1 || rules[1] != 2 || rules[2] != 3 {
+		t.Errorf("rules = %v, want [-1 2 3]", rules)
+	}
+
+	if problems := foreignDiscordChannels(&Session{AllGuilds: true}, table, channelGuild); len(problems) != 0 {
+		t.Errorf("the settings of the user of all guilds are checked: %+v", problems)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	var a = NewAuthenticator(AuthConfig{APITokens: []string{"secret"}}, nil, nil)
+	a.sessions["id"] = &Session{UserName: "user", CSRFToken: "csrf", Guilds: map[string]bool{"G1": true}, id: "id", expires: time.Now().Add(time.Hour)}
+	a.sessions["none"] = &Session{UserName: "guest", CSRFToken: "csrf", id: "none", expires: time.Now().Add(time.Hour)}
+
+	var cases = []struct {
+		name   string
+		method string
+		header map[string]string
+		want   int
+	}{
+		{"no login", "GET", nil, 401},
+		{"token", "POST", map[string]string{"Authorization": "Bearer secret"}, 200},
+		{"invalid token", "GET", map[string]string{"Authorization": "Bearer wrong"}, 401},
+		{"cookie get", "GET", map[string]string{"Cookie": sessionCookie + "=id"}, 200},
+		{"cookie post without csrf", "POST", map[string]string{"Cookie": sessionCookie + "=id"}, 403},
+		{"cookie post", "POST", map[string]string{"Cookie": sessionCookie + "=id", csrfHeader: "csrf"}, 200},
+		{"no guilds", "GET", map[string]string{"Cookie": sessionCookie + "=none"}, 403},
+	}
+	for _, c := range cases {
+		var r = httptest.NewRequest(c.method, "/api/", nil)
+		for key, value := range c.header {
+			r.Header.Set(key, value)
+		}
+		var w = httptest.NewRecorder()
+		if a.authenticate(w, r) != nil {
+			w.WriteHeader(http.StatusOK)
+		}
+		if w.Code != c.want {
+			t.Errorf("%s: %d, want %d", c.name, w.Code, c.want)
+		}
+	}
+}
+
+func TestSlackUserGuilds(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "settings.json")
+	// the manager of G1 links their Slack user with the owner of G2
+	var err = ioutil.WriteFile(path, []byte(`[
+		{"discord_server": "G1", "channel": [], "user_links": [{"slack": "S_attacker", "discord": "D_owner"}]},
+		{"discord_server": "G2", "channel": [], "user_links": [{"slack": "S_owner", "discord": "D_owner"}]}
+	]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s = NewSettingsHandler(settings.New("", "", path), nil, nil)
+	s.Auth = NewAuthenticator(AuthConfig{slackTeams: []string{"T1"}}, nil, s.settings)
+	s.Auth.permissions = func(guildID, userID string) (int64, error) {
+		if guildID == "G2" && userID == "D_owner" {
+			return discordgo.PermissionAdministrator, nil
+		}
+		return 0, nil
+	}
+	s.Auth.sessions["owner"] = &Session{UserName: "owner", CSRFToken: "csrf", DiscordID: "D_owner", Guilds: map[string]bool{"G2": true}, id: "owner", expires: time.Now().Add(time.Hour)}
+	s.Auth.sessions["slack"] = &Session{UserName: "slack", CSRFToken: "csrf", Guilds: map[string]bool{"G2": true}, id: "slack", expires: time.Now().Add(time.Hour)}
+
+	if s.Auth.slackTeam("T2") || !s.Auth.slackTeam("T1") {
+		t.Error("the teams of the workspaces are not checked")
+	}
+
+	var check = func(slackID string, want ...string) {
+		t.Helper()
+		guilds, err := s.Auth.slackUserGuilds(slackID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(guilds) != len(want) {
+			t.Fatalf("%s: %v, want %v", slackID, guilds, want)
+		}
+		for _, guild := range want {
+			if !guilds[guild] {
+				t.Errorf("%s: %v, want %v", slackID, guilds, want)
+			}
+		}
+	}
+
+	var confirm = func(session, guild, slack string) int {
+		var r = httptest.NewRequest("POST", "/api/?action=confirmUserLink&guild="+guild+"&slack="+slack, nil)
+		r.Header.Set("Cookie", sessionCookie+"="+session)
+		r.Header.Set(csrfHeader, "csrf")
+		var w = httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	check("S_attacker")
+	check("S_owner")
+
+	if code := confirm("slack", "G2", "S_owner"); code != 403 {
+		t.Errorf("confirmed without Discord login: %d", code)
+	}
+	if code := confirm("owner", "G2", "S_attacker"); code != 404 {
+		t.Errorf("confirmed a link of another guild: %d", code)
+	}
+	if code := confirm("owner", "G2", "S_owner"); code != 200 {
+		t.Fatalf("confirm: %d", code)
+	}
+
+	check("S_attacker")
+	check("S_owner", "G2")
+}
+
+func TestReadActions(t *testing.T) {
+	var dir = t.TempDir()
+	var write = func(name, content string) string {
+		var path = filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	var settingsPath = write("settings.json", `[
+		{"discord_server": "G1", "channel": [{"slack": "S1", "discord": "D1"}]},
+		{"discord_server": "G2", "channel": [{"slack": "S2", "discord": "D2"}]}
+	]`)
+
+	// the channels are found in the state without Discord
+	var state = discordgo.NewState()
+	state.GuildAdd(&discordgo.Guild{ID: "G1", Channels: []*discordgo.Channel{{ID: "D1", GuildID: "G1"}}})
+	state.GuildAdd(&discordgo.Guild{ID: "G2", Channels: []*discordgo.Channel{{ID: "D2", GuildID: "G2"}}})
+
+	var s = NewSettingsHandler(settings.New("", "", settingsPath), &DiscordHandler{Session: &discordgo.Session{State: state}}, nil)
+	s.Auth = NewAuthenticator(AuthConfig{}, nil, nil)
+	s.Auth.sessions["id"] = &Session{UserName: "user", Guilds: map[string]bool{"G1": true}, id: "id", expires: time.Now().Add(time.Hour)}
+
+	var err error
+	s.DeadLetters, err = dead_letter.Open(write("dead_letters.json", `[
+		{"id": "L1", "queue": "discord", "target": "D1"},
+		{"id": "L2", "queue": "discord", "target": "D2"},
+		{"id": "L3", "queue": "slack", "target": "S2"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Backfill, err = backfill.New(write("backfill.json", `[
+		{"id": "J1", "discord_channel": "D1", "slack_channel": "S1"},
+		{"id": "J2", "discord_channel": "D2", "slack_channel": "S2"}
+	]`), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Archive, err = archive.Open(filepath.Join(dir, "archive"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var now = time.Now()
+	s.Archive.Write(archive.Record{Time: now, Platform: archive.PlatformDiscord, Kind: archive.KindMessage, TeamID: "G1", ChannelID: "D1", MessageID: "M1", Text: "visible"})
+	s.Archive.Write(archive.Record{Time: now, Platform: archive.PlatformDiscord, Kind: archive.KindMessage, ChannelID: "D2", MessageID: "M2", Text: "hidden"})
+	s.Archive.Write(archive.Record{Time: now, Platform: archive.PlatformSlack, Kind: archive.KindMessage, ChannelID: "S2", MessageID: "M3", Text: "hidden", BridgedChannelID: "D2"})
+	s.Archive.Close()
+
+	var request = func(query string) (int, string) {
+		var r = httptest.NewRequest("GET", "/api/?"+query, nil)
+		r.Header.Set("Cookie", sessionCookie+"=id")
+		var w = httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w.Code, w.Body.String()
+	}
+
+	var cases = []struct {
+		query string
+		want  int
+		// shown must be in the body, and hidden must not
+		shown, hidden string
+	}{
+		{"action=getCurrentSettings", 200, `"G1"`, `"G2"`},
+		{"action=getDeadLetters", 200, `"L1"`, `"L2"`},
+		{"action=getDeadLetters", 200, `"L1"`, `"L3"`},
+		{"action=getDeadLetter&id=L1", 200, `"L1"`, ""},
+		{"action=getDeadLetter&id=L2", 403, "ManageGuildRequired", ""},
+		{"action=getBackfillJobs", 200, `"J1"`, `"J2"`},
+		{"action=search", 200, "visible", "hidden"},
+		{"action=getDiscordChannels&guild_id=G2", 403, "ManageGuildRequired", ""},
+		{"action=getDiscordGuildIdentity&guild_id=G2", 403, "ManageGuildRequired", ""},
+	}
+	for _, c := range cases {
+		code, body := request(c.query)
+		if code != c.want || !strings.Contains(body, c.shown) || (c.hidden != "" && strings.Contains(body, c.hidden)) {
+			t.Errorf("%s: %d %s", c.query, code, body)
+		}
+	}
+}
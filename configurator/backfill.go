@@ -0,0 +1,93 @@
+package configurator
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/backfill"
+)
+
+func (s *SettingsHandler) GetBackfillJobs(w http.ResponseWriter, r *http.Request) {
+	var session = sessionFromRequest(r)
+	var jobs = []backfill.Job{}
+	if s.Backfill != nil {
+		for _, job := range s.Backfill.Jobs() {
+			if s.canManageChannel(session, job.DiscordChannel) {
+				jobs = append(jobs, job)
+			}
+		}
+	}
+
+	w.Header().Add("Content-type", "application/json")
+
+	var err = json.NewEncoder(w).Encode(jobs)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: JsonEncodeError\n" + err.Error()))
+		return
+	}
+}
+
+func (s *SettingsHandler) StartBackfill(w http.ResponseWriter, r *http.Request) {
+	if s.Backfill == nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: BackfillDisabled"))
+		return
+	}
+
+	var req backfill.Request
+	var err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte("BadRequest: ParseRequestedBackfillError\n" + err.Error()))
+		return
+	}
+
+	if !s.canManageChannel(sessionFromRequest(r), req.DiscordChannel) {
+		w.WriteHeader(403)
+		w.Write([]byte("Forbidden: ManageGuildRequired"))
+		return
+	}
+
+	job, err := s.Backfill.Add(req)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte("BadRequest: InvalidBackfill\n" + err.Error()))
+		return
+	}
+	s.Backfill.Enqueue(job.ID)
+
+	w.Header().Add("Content-type", "application/json")
+
+	err = json.NewEncoder(w).Encode(job)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: JsonEncodeError\n" + err.Error()))
+		return
+	}
+}
+
+// ResumeBackfill runs the job again, such as after it failed, and the messages copied already are skipped
+func (s *SettingsHandler) ResumeBackfill(w http.ResponseWriter, r *http.Request) {
+	if s.Backfill == nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: BackfillDisabled"))
+		return
+	}
+
+	job, ok := s.Backfill.Get(r.FormValue("id"))
+	if !ok {
+		w.WriteHeader(404)
+		w.Write([]byte("NotFound: BackfillJob"))
+		return
+	}
+
+	if !s.canManageChannel(sessionFromRequest(r), job.DiscordChannel) {
+		w.WriteHeader(403)
+		w.Write([]byte("Forbidden: ManageGuildRequired"))
+		return
+	}
+	s.Backfill.Enqueue(job.ID)
+
+	w.Write([]byte("OK"))
+}
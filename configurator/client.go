@@ -7,9 +7,15 @@ import (
 
 func (s *SettingsHandler) GetClientInfo(w http.ResponseWriter, r *http.Request) {
 	type user struct {
-		UserName string
+		UserName  string
+		CSRFToken string
+		// ManageableGuilds is null if the user can edit all guilds
+		ManageableGuilds []string
 	}
-	var resp = user{r.Header.Get("X-Forwarded-User")}
+	var session = sessionFromRequest(r)
+	var resp = user{session.UserName, session.CSRFToken, session.ManageableGuilds()}
+
+	w.Header().Add("Content-type", "application/json")
 
 	err := json.NewEncoder(w).Encode(resp)
 	if err != nil {
@@ -17,7 +23,4 @@ func (s *SettingsHandler) GetClientInfo(w http.ResponseWriter, r *http.Request)
 		w.Write([]byte("InternalServerError: JsonEncodeError"))
 		return
 	}
-
-	w.Header().Add("Content-type", "application/json")
-
 }
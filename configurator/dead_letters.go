@@ -0,0 +1,129 @@
+package configurator
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// DeadLetterSummary is a failed delivery listed without its event and payload
+type DeadLetterSummary struct {
+	ID       string    `json:"id"`
+	Queue    string    `json:"queue"`
+	Target   string    `json:"target"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	Created  time.Time `json:"created"`
+	Failed   time.Time `json:"failed"`
+}
+
+func (s *SettingsHandler) GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	var session = sessionFromRequest(r)
+	var summaries = []DeadLetterSummary{}
+	if s.DeadLetters != nil {
+		for _, letter := range s.DeadLetters.List() {
+			if !s.canManageLetter(session, letter) {
+				continue
+			}
+			summaries = append(summaries, DeadLetterSummary{
+				ID:       letter.ID,
+				Queue:    letter.Queue,
+				Target:   letter.Target,
+				Error:    letter.Error,
+				Attempts: letter.Attempts,
+				Created:  letter.Created,
+				Failed:   letter.Failed,
+			})
+		}
+	}
+
+	w.Header().Add("Content-type", "application/json")
+
+	var err = json.NewEncoder(w).Encode(summaries)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: JsonEncodeError\n" + err.Error()))
+		return
+	}
+}
+
+func (s *SettingsHandler) GetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if s.DeadLetters == nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: DeadLetterDisabled"))
+		return
+	}
+
+	if !s.authorizeLetter(w, r) {
+		return
+	}
+	letter, _ := s.DeadLetters.Get(r.FormValue("id"))
+
+	w.Header().Add("Content-type", "application/json")
+
+	var err = json.NewEncoder(w).Encode(letter)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: JsonEncodeError\n" + err.Error()))
+		return
+	}
+}
+
+func (s *SettingsHandler) RetryDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if s.DeadLetters == nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: DeadLetterDisabled"))
+		return
+	}
+
+	if !s.authorizeLetter(w, r) {
+		return
+	}
+
+	var err = s.DeadLetters.Retry(r.FormValue("id"))
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: RetryDeadLetterError\n" + err.Error()))
+		return
+	}
+
+	w.Write([]byte("OK"))
+}
+
+func (s *SettingsHandler) DiscardDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if s.DeadLetters == nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: DeadLetterDisabled"))
+		return
+	}
+
+	if !s.authorizeLetter(w, r) {
+		return
+	}
+
+	var err = s.DeadLetters.Discard(r.FormValue("id"))
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: DiscardDeadLetterError\n" + err.Error()))
+		return
+	}
+
+	w.Write([]byte("OK"))
+}
+
+// authorizeLetter writes the error unless the user can manage the guild of the failed delivery
+func (s *SettingsHandler) authorizeLetter(w http.ResponseWriter, r *http.Request) bool {
+	letter, ok := s.DeadLetters.Get(r.FormValue("id"))
+	if !ok {
+		w.WriteHeader(404)
+		w.Write([]byte("NotFound: DeadLetter"))
+		return false
+	}
+
+	if !s.canManageLetter(sessionFromRequest(r), letter) {
+		w.WriteHeader(403)
+		w.Write([]byte("Forbidden: ManageGuildRequired"))
+		return false
+	}
+	return true
+}
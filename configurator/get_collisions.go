@@ -0,0 +1,60 @@
+package configurator
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+)
+
+func (s *SettingsHandler) GetChannelMapCollisions(w http.ResponseWriter, r *http.Request) {
+	// the channel map of each workspace has the collisions with its own Slack channels
+	var collisions = []settings.Collision{}
+	for _, workspace := range s.workspaces() {
+		var handler = s.settings
+		if workspace.settings != nil {
+			handler = workspace.settings
+		}
+
+		found, err := handler.ChannelMapCollisions()
+		if err != nil {
+			w.WriteHeader(500)
+			w.Write([]byte("InternalServerError: GetCollisionsError\n" + err.Error()))
+			return
+		}
+		collisions = append(collisions, found...)
+	}
+
+	collisions = filterCollisions(sessionFromRequest(r), collisions)
+
+	w.Header().Add("Content-type", "application/json")
+
+	var err = json.NewEncoder(w).Encode(collisions)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: JsonEncodeError\n" + err.Error()))
+		return
+	}
+}
+
+// filterCollisions returns the collisions with the channels of the guilds the user can manage
+func filterCollisions(session *Session, collisions []settings.Collision) []settings.Collision {
+	if session.AllGuilds {
+		return collisions
+	}
+	var filtered = []settings.Collision{}
+	for _, collision := range collisions {
+		var channels []settings.CollisionChannel
+		for _, channel := range collision.DiscordChannels {
+			if session.CanManage(channel.GuildID) {
+				channels = append(channels, channel)
+			}
+		}
+		if len(channels) == 0 {
+			continue
+		}
+		collision.DiscordChannels = channels
+		filtered = append(filtered, collision)
+	}
+	return filtered
+}
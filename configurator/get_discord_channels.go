@@ -5,8 +5,14 @@ import (
 	"net/http"
 )
 
-func (s SettingsHandler) GetDiscordChannels(w http.ResponseWriter, r *http.Request) {
+func (s *SettingsHandler) GetDiscordChannels(w http.ResponseWriter, r *http.Request) {
 	guildID := r.FormValue("guild_id")
+	if !sessionFromRequest(r).CanManage(guildID) {
+		w.WriteHeader(403)
+		w.Write([]byte("Forbidden: ManageGuildRequired"))
+		return
+	}
+
 	channels, err := s.Discord.Session.GuildChannels(guildID)
 	if err != nil {
 		w.WriteHeader(500)
@@ -14,12 +20,12 @@ func (s SettingsHandler) GetDiscordChannels(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	w.Header().Add("Content-type", "application/json")
+
 	err = json.NewEncoder(w).Encode(channels)
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("InternalServerError: JsonEncodeError\n" + err.Error()))
 		return
 	}
-
-	w.Header().Add("Content-type", "application/json")
 }
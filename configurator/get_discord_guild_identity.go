@@ -5,7 +5,7 @@ import (
 	"net/http"
 )
 
-func (s SettingsHandler) GetDiscordGuildIdentity(w http.ResponseWriter, r *http.Request) {
+func (s *SettingsHandler) GetDiscordGuildIdentity(w http.ResponseWriter, r *http.Request) {
 	var guildID = r.FormValue("guild_id")
 
 	if guildID == "" {
@@ -14,6 +14,12 @@ func (s SettingsHandler) GetDiscordGuildIdentity(w http.ResponseWriter, r *http.
 		return
 	}
 
+	if !sessionFromRequest(r).CanManage(guildID) {
+		w.WriteHeader(403)
+		w.Write([]byte("Forbidden: ManageGuildRequired"))
+		return
+	}
+
 	identity, err := s.Discord.Session.Guild(guildID)
 	if err != nil {
 		w.WriteHeader(500)
@@ -21,12 +27,12 @@ func (s SettingsHandler) GetDiscordGuildIdentity(w http.ResponseWriter, r *http.
 		return
 	}
 
+	w.Header().Add("Content-type", "application/json")
+
 	err = json.NewEncoder(w).Encode(identity)
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("InternalServerError: JsonMarshalError\n" + err.Error()))
 		return
 	}
-
-	w.Header().Add("Content-type", "application/json")
 }
@@ -2,9 +2,9 @@ package configurator
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
 	"github.com/pkg/errors"
 )
 
@@ -14,16 +14,19 @@ func (s *SettingsHandler) GetCurrentSettings(w http.ResponseWriter, r *http.Requ
 		var text = errors.Wrap(err, "GetCurrentSettings: GetChannelMap").Error()
 		w.WriteHeader(500)
 		w.Write([]byte(text))
-		log.Println(text)
+		logger.Error("GetCurrentSettings", "error", err)
 		return
 	}
 
+	settings.AssignMappingIDs(table)
+	table = filterSettings(sessionFromRequest(r), table)
+
+	w.Header().Add("Content-type", "application/json")
+
 	err = json.NewEncoder(w).Encode(table)
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("InternalServerError: JsonEncodeError\n" + err.Error()))
 		return
 	}
-
-	w.Header().Add("Content-type", "application/json")
 }
@@ -6,19 +6,28 @@ import (
 )
 
 func (s *SettingsHandler) GetSlackChannels(w http.ResponseWriter, r *http.Request) {
-	channels, err := s.Slack.GetChannels()
-	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte("InternalServerError: GetChannelsError\n" + err.Error()))
-		return
+	var handlers = s.SlackWorkspaces
+	if len(handlers) < 2 {
+		handlers = []*SlackHandler{s.Slack}
+	}
+
+	var channels []SlackChannel
+	for _, handler := range handlers {
+		workspaceChannels, err := handler.GetChannels()
+		if err != nil {
+			w.WriteHeader(500)
+			w.Write([]byte("InternalServerError: GetChannelsError\n" + err.Error()))
+			return
+		}
+		channels = append(channels, workspaceChannels...)
 	}
 
-	err = json.NewEncoder(w).Encode(channels)
+	w.Header().Add("Content-type", "application/json")
+
+	var err = json.NewEncoder(w).Encode(channels)
 	if err != nil {
 		w.WriteHeader(500)
 		w.Write([]byte("InternalServerError: JsonMarshalError\n" + err.Error()))
 		return
 	}
-
-	w.Header().Add("Content-type", "application/json")
 }
@@ -0,0 +1,104 @@
+package configurator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+	"github.com/pkg/errors"
+)
+
+// versions returns the versions the user can see.
+// The users who can manage some guilds see only the changes of the guilds.
+func (s *SettingsHandler) versions(session *Session) ([]settings.Version, error) {
+	if session.AllGuilds {
+		return s.settings.Versions()
+	}
+	versions, _, err := s.settings.VersionsOf(session.CanManage)
+	return versions, err
+}
+
+// versionDiff returns the version with the diff the user can see, in the same way as versions
+func (s *SettingsHandler) versionDiff(session *Session, id int) (settings.Version, string, error) {
+	if session.AllGuilds {
+		return s.settings.VersionDiff(id)
+	}
+	versions, diffs, err := s.settings.VersionsOf(session.CanManage)
+	if err != nil {
+		return settings.Version{}, "", err
+	}
+	for i, version := range versions {
+		if version.ID == id {
+			return version, diffs[i], nil
+		}
+	}
+	return settings.Version{}, "", errors.Errorf("VersionNotFound: %d", id)
+}
+
+func (s *SettingsHandler) GetSettingsVersions(w http.ResponseWriter, r *http.Request) {
+	versions, err := s.versions(sessionFromRequest(r))
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: GetSettingsVersionsError\n" + err.Error()))
+		return
+	}
+
+	w.Header().Add("Content-type", "application/json")
+
+	err = json.NewEncoder(w).Encode(versions)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: JsonEncodeError\n" + err.Error()))
+		return
+	}
+}
+
+// GetSettingsDiff returns the version with the unified diff from the previous version
+func (s *SettingsHandler) GetSettingsDiff(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte("BadRequest: InvalidVersion\n" + err.Error()))
+		return
+	}
+
+	version, diff, err := s.versionDiff(sessionFromRequest(r), id)
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte("NotFound: SettingsVersion\n" + err.Error()))
+		return
+	}
+
+	w.Header().Add("Content-type", "application/json")
+
+	err = json.NewEncoder(w).Encode(struct {
+		Version settings.Version `json:"version"`
+		Diff    string           `json:"diff"`
+	}{version, diff})
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: JsonEncodeError\n" + err.Error()))
+		return
+	}
+}
+
+// RollbackSettings writes the settings of the version as a new version, so that the rollback can be undone
+func (s *SettingsHandler) RollbackSettings(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte("BadRequest: InvalidVersion\n" + err.Error()))
+		return
+	}
+
+	table, err := s.settings.VersionSettings(id)
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte("NotFound: SettingsVersion\n" + err.Error()))
+		return
+	}
+
+	s.writeSettings(w, r, table, fmt.Sprintf("バージョン%dに戻す", id))
+}
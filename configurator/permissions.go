@@ -0,0 +1,51 @@
+package configurator
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// memberPermissions returns the permissions of the member in the channel, or in the guild if channel is nil
+func memberPermissions(guild *discordgo.Guild, member *discordgo.Member, channel *discordgo.Channel) int64 {
+	if member.User != nil && guild.OwnerID == member.User.ID {
+		return discordgo.PermissionAll
+	}
+
+	var roles = map[string]bool{guild.ID: true}
+	for _, roleID := range member.Roles {
+		roles[roleID] = true
+	}
+
+	var permissions int64
+	for _, role := range guild.Roles {
+		if roles[role.ID] {
+			permissions |= role.Permissions
+		}
+	}
+	if permissions&discordgo.PermissionAdministrator != 0 {
+		return discordgo.PermissionAll
+	}
+	if channel == nil {
+		return permissions
+	}
+
+	// the overwrites apply in the order of @everyone, the roles, and the member
+	for _, overwrite := range channel.PermissionOverwrites {
+		if overwrite.Type == discordgo.PermissionOverwriteTypeRole && overwrite.ID == guild.ID {
+			permissions = permissions&^overwrite.Deny | overwrite.Allow
+		}
+	}
+	var allow, deny int64
+	for _, overwrite := range channel.PermissionOverwrites {
+		if overwrite.Type == discordgo.PermissionOverwriteTypeRole && overwrite.ID != guild.ID && roles[overwrite.ID] {
+			allow |= overwrite.Allow
+			deny |= overwrite.Deny
+		}
+	}
+	permissions = permissions&^deny | allow
+	for _, overwrite := range channel.PermissionOverwrites {
+		if overwrite.Type == discordgo.PermissionOverwriteTypeMember && member.User != nil && overwrite.ID == member.User.ID {
+			permissions = permissions&^overwrite.Deny | overwrite.Allow
+		}
+	}
+	return permissions
+}
@@ -0,0 +1,50 @@
+package configurator
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestMemberPermissions(t *testing.T) {
+	var guild = &discordgo.Guild{
+		ID:      "G",
+		OwnerID: "owner",
+		Roles: []*discordgo.Role{
+			{ID: "G", Permissions: discordgo.PermissionViewChannel},
+			{ID: "bot", Permissions: discordgo.PermissionManageWebhooks},
+		},
+	}
+	var member = &discordgo.Member{User: &discordgo.User{ID: "B"}, Roles: []string{"bot"}}
+	var both = int64(discordgo.PermissionViewChannel | discordgo.PermissionManageWebhooks)
+
+	var cases = []struct {
+		name       string
+		overwrites []*discordgo.PermissionOverwrite
+		want       int64
+	}{
+		{"guild", nil, both},
+		{"everyone denied", []*discordgo.PermissionOverwrite{
+			{ID: "G", Type: discordgo.PermissionOverwriteTypeRole, Deny: discordgo.PermissionViewChannel},
+		}, discordgo.PermissionManageWebhooks},
+		{"role allowed over everyone", []*discordgo.PermissionOverwrite{
+			{ID: "G", Type: discordgo.PermissionOverwriteTypeRole, Deny: discordgo.PermissionViewChannel},
+			{ID: "bot", Type: discordgo.PermissionOverwriteTypeRole, Allow: discordgo.PermissionViewChannel},
+		}, both},
+		{"member denied over role", []*discordgo.PermissionOverwrite{
+			{ID: "bot", Type: discordgo.PermissionOverwriteTypeRole, Allow: discordgo.PermissionReadMessageHistory},
+			{ID: "B", Type: discordgo.PermissionOverwriteTypeMember, Deny: discordgo.PermissionManageWebhooks},
+		}, discordgo.PermissionViewChannel | discordgo.PermissionReadMessageHistory},
+	}
+	for _, c := range cases {
+		var channel = &discordgo.Channel{PermissionOverwrites: c.overwrites}
+		if got := memberPermissions(guild, member, channel); got != c.want {
+			t.Errorf("%s: %x, want %x", c.name, got, c.want)
+		}
+	}
+
+	var owner = &discordgo.Member{User: &discordgo.User{ID: "owner"}}
+	if got := memberPermissions(guild, owner, nil); got != discordgo.PermissionAll {
+		t.Errorf("owner: %x", got)
+	}
+}
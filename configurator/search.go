@@ -0,0 +1,126 @@
+package configurator
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/archive"
+)
+
+// SearchResult is an archived message with the links of both sides of the bridge
+type SearchResult struct {
+	Time       time.Time `json:"time"`
+	Platform   string    `json:"platform"`
+	ChannelID  string    `json:"channel_id"`
+	UserName   string    `json:"user_name"`
+	Text       string    `json:"text"`
+	Edited     bool      `json:"edited"`
+	SlackURL   string    `json:"slack_url,omitempty"`
+	DiscordURL string    `json:"discord_url,omitempty"`
+}
+
+// Search finds archived messages by q, author, slack_channel, discord_channel, and the dates from and to such as 2021-04-01
+func (s *SettingsHandler) Search(w http.ResponseWriter, r *http.Request) {
+	if s.Archive == nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: ArchiveDisabled"))
+		return
+	}
+
+	var query = archive.Query{
+		Text:           r.FormValue("q"),
+		Author:         r.FormValue("author"),
+		SlackChannel:   r.FormValue("slack_channel"),
+		DiscordChannel: r.FormValue("discord_channel"),
+	}
+
+	var err error
+	if from := r.FormValue("from"); from != "" {
+		query.From, err = time.ParseInLocation(archive.DayFormat, from, time.Local)
+		if err != nil {
+			w.WriteHeader(400)
+			w.Write([]byte("BadRequest: InvalidFrom\n" + err.Error()))
+			return
+		}
+	}
+	if to := r.FormValue("to"); to != "" {
+		query.To, err = time.ParseInLocation(archive.DayFormat, to, time.Local)
+		if err != nil {
+			w.WriteHeader(400)
+			w.Write([]byte("BadRequest: InvalidTo\n" + err.Error()))
+			return
+		}
+		// the day given as the end is included
+		query.To = query.To.AddDate(0, 0, 1)
+	}
+
+	messages, err := s.Archive.Search(query)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: SearchError\n" + err.Error()))
+		return
+	}
+
+	var allowed = s.messageFilter(sessionFromRequest(r))
+	var results = []SearchResult{}
+	for _, message := range messages {
+		if !allowed(message.Record) {
+			continue
+		}
+		var name = message.UserName
+		if name == "" {
+			name = message.UserID
+		}
+		results = append(results, SearchResult{
+			Time:       message.Time,
+			Platform:   message.Platform,
+			ChannelID:  message.ChannelID,
+			UserName:   name,
+			Text:       message.Text,
+			Edited:     message.Edited,
+			SlackURL:   message.SlackURL,
+			DiscordURL: message.DiscordURL,
+		})
+	}
+
+	w.Header().Add("Content-type", "application/json")
+
+	err = json.NewEncoder(w).Encode(results)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: JsonEncodeError\n" + err.Error()))
+		return
+	}
+}
+
+// messageFilter returns the function reporting whether the user can manage the guild of the archived message.
+// The guilds of the channels are kept while the results are filtered, as a channel has many messages.
+func (s *SettingsHandler) messageFilter(session *Session) func(record archive.Record) bool {
+	if session.AllGuilds {
+		return func(record archive.Record) bool { return true }
+	}
+
+	var channels = map[string]bool{}
+	return func(record archive.Record) bool {
+		var key = record.Platform + "/" + record.ChannelID + "/" + record.BridgedChannelID
+		if allowed, ok := channels[key]; ok {
+			return allowed
+		}
+
+		var allowed bool
+		switch {
+		case record.Platform == archive.PlatformDiscord && record.TeamID != "":
+			allowed = session.CanManage(record.TeamID)
+		case record.Platform == archive.PlatformDiscord:
+			allowed = s.canManageChannel(session, record.ChannelID)
+		case record.BridgedChannelID != "":
+			allowed = s.canManageChannel(session, record.BridgedChannelID)
+		default:
+			_, guildID := s.settings.LookupDiscordChannel(record.ChannelID)
+			allowed = guildID != "" && session.CanManage(guildID)
+		}
+		channels[key] = allowed
+		return allowed
+	}
+}
@@ -2,8 +2,11 @@ package configurator
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/kmc-jp/DiscordSlackSynchronizer/alert"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
 )
 
@@ -13,19 +16,99 @@ func (s *SettingsHandler) SetSettings(w http.ResponseWriter, r *http.Request) {
 	var err error
 	err = json.NewDecoder(r.Body).Decode(&table)
 	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte("InternalServerError: ParseRequestedSettingsError\n" + err.Error()))
+		w.WriteHeader(400)
+		w.Write([]byte("BadRequest: ParseRequestedSettingsError\n" + err.Error()))
 		return
 	}
 
-	err = s.settings.WriteChannelMap(table)
+	s.writeSettings(w, r, table, r.FormValue("comment"))
+}
+
+// writeSettings writes the settings as a new version by the user, and restarts the bridge
+func (s *SettingsHandler) writeSettings(w http.ResponseWriter, r *http.Request, table []settings.SlackDiscordTable, comment string) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_, err := s.commitSettings(sessionFromRequest(r), table, comment, r.FormValue("force") == "yes")
 	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte("InternalServerError: WriteRequestedSettingsError\n" + err.Error()))
+		var e = toAPIError(err)
+		w.WriteHeader(e.Status)
+		w.Write([]byte(e.text()))
 		return
 	}
 
+	w.Write([]byte("OK"))
+}
+
+// commitSettings writes the settings by the user and restarts the bridge.
+// The settings with errors would stop the bridge of the channels, and are written only when forced.
+// s.writeMu must be held.
+func (s *SettingsHandler) commitSettings(session *Session, table []settings.SlackDiscordTable, comment string, force bool) (settings.Version, error) {
+	var err error
+
+	// the settings of all guilds are replaced only by users who can edit all of them, even if settings.json is broken
+	if !session.AllGuilds {
+		current, err := s.settings.GetChannelMap()
+		if err != nil {
+			return settings.Version{}, newAPIError(500, "GetChannelMap", err.Error())
+		}
+
+		table, err = mergeSettings(session, current, table)
+		if err != nil {
+			return settings.Version{}, newAPIError(403, "ManageGuildRequired", err.Error())
+		}
+
+		if problems := foreignDiscordChannels(session, table, s.channelGuild); len(problems) > 0 {
+			var e = newAPIError(403, "ForeignDiscordChannel", problemLines(problems))
+			e.Problems = problems
+			return settings.Version{}, e
+		}
+	}
+
+	for _, guild := range table {
+		err = settings.CompileNameRules(guild.NameRules)
+		if err != nil {
+			return settings.Version{}, newAPIError(400, "InvalidNameRule", err.Error())
+		}
+	}
+
+	// force skips only the checks against Slack and Discord, which may be unavailable for the while
+	if !force {
+		var problems = s.validate(table).Errors(session)
+		if len(problems) > 0 {
+			var e = newAPIError(400, "InvalidSettings", problemLines(problems))
+			e.Problems = problems
+			return settings.Version{}, e
+		}
+	}
+
+	// the mappings keep the IDs they are shown with
+	settings.AssignMappingIDs(table)
+
+	version, err := s.settings.Commit(table, session.UserName, comment)
+	if err != nil {
+		return settings.Version{}, newAPIError(500, "WriteRequestedSettingsError", err.Error())
+	}
+	logger.Info("SettingsChanged", "version", version.ID, "author", version.Author, "added", version.Added, "removed", version.Removed)
+
+	if s.AnnounceChanges {
+		var text = fmt.Sprintf("%sが設定を変更しました（バージョン%d、+%d -%d行）",
+			version.Author, version.ID, version.Added, version.Removed)
+		if comment != "" {
+			text += ": " + comment
+		}
+		alert.Notify(alert.KindSettings, "%s", text)
+	}
+
 	s.controller <- CommandRestart
 
-	w.Write([]byte("OK"))
+	return version, nil
+}
+
+func problemLines(problems []settings.Problem) string {
+	var lines []string
+	for _, problem := range problems {
+		lines = append(lines, fmt.Sprintf("%s rule %d: %s", problem.Guild, problem.Rule, problem.Message))
+	}
+	return strings.Join(lines, "\n")
 }
@@ -1,20 +1,41 @@
 package configurator
 
 import (
-	"io/ioutil"
-	"log"
+	"io/fs"
 	"net"
 	"net/http"
 	"os"
+	"sync"
 
+	"github.com/kmc-jp/DiscordSlackSynchronizer/archive"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/backfill"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/dead_letter"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
 )
 
 type SettingsHandler struct {
 	Discord *DiscordHandler
 	Slack   *SlackHandler
+	// SlackWorkspaces are listed instead of Slack if several workspaces are configured
+	SlackWorkspaces []*SlackHandler
+	// Handlers are served on the same listener, such as the Slack Events API endpoint
+	Handlers map[string]http.Handler
+	// DeadLetters is nil if failed deliveries are not kept
+	DeadLetters *dead_letter.Store
+	// Backfill is nil if history can not be copied from the configurator
+	Backfill *backfill.Handler
+	// Archive is nil if bridged messages are not archived
+	Archive *archive.Archive
+	// Auth is nil if the login is not configured, and the reverse proxy is trusted
+	Auth *Authenticator
+	// AnnounceChanges posts the changes of the settings to the admin channels
+	AnnounceChanges bool
+	// Assets has index.html and static/, which are read from the working directory if nil
+	Assets fs.FS
 
 	controller chan int
+	// writeMu serializes the changes of the settings, which read the current settings first
+	writeMu sync.Mutex
 
 	settings *settings.Handler
 
@@ -31,6 +52,14 @@ func NewSettingsHandler(settings *settings.Handler, discord *DiscordHandler, sla
 }
 
 func (s *SettingsHandler) Start(prefix, sock, addr string) (chan int, error) {
+	if s.Assets == nil {
+		s.Assets = os.DirFS(".")
+	}
+	staticAssets, err := fs.Sub(s.Assets, "static")
+	if err != nil {
+		return nil, err
+	}
+
 	l, err := net.Listen(sock, addr)
 	if err != nil {
 		return nil, err
@@ -48,7 +77,11 @@ func (s *SettingsHandler) Start(prefix, sock, addr string) (chan int, error) {
 	var mux = http.NewServeMux()
 
 	mux.Handle(prefix+"/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		b, err := ioutil.ReadFile("index.html")
+		if !s.Auth.LoggedIn(r) {
+			http.Redirect(w, r, prefix+"/auth/login", http.StatusFound)
+			return
+		}
+		b, err := fs.ReadFile(s.Assets, "index.html")
 		if err != nil {
 			w.Write([]byte("Error: index.html not found"))
 			return
@@ -56,11 +89,20 @@ func (s *SettingsHandler) Start(prefix, sock, addr string) (chan int, error) {
 		w.Write(b)
 	}))
 	mux.Handle(prefix+"/api/", s)
-	mux.Handle(prefix+"/static/", http.StripPrefix(prefix+"/static/", http.FileServer(http.Dir("static"))))
+	mux.Handle(prefix+"/api/v1/", http.StripPrefix(prefix+"/api/v1", http.HandlerFunc(s.ServeAPIv1)))
+	mux.Handle(prefix+"/static/", http.StripPrefix(prefix+"/static/", http.FileServer(http.FS(staticAssets))))
+	if s.Auth != nil {
+		s.Auth.prefix = prefix
+		mux.Handle(prefix+"/auth/", s.Auth)
+	}
+	// the handlers authenticate requests by themselves, such as Slack events by the signing secret
+	for pattern, handler := range s.Handlers {
+		mux.Handle(prefix+pattern, handler)
+	}
 
 	go func() {
 		err := http.Serve(l, mux)
-		log.Printf("Error: Start http server: %s\n", err.Error())
+		logger.Error("StartHTTPServer", "error", err)
 	}()
 
 	s.controller = make(chan int)
@@ -75,7 +117,29 @@ func (s *SettingsHandler) Close() (err error) {
 	return
 }
 
+// updateActions change the settings or the deliveries, and are accepted only by POST
+var updateActions = map[string]bool{
+	"setSettings":       true,
+	"retryDeadLetter":   true,
+	"discardDeadLetter": true,
+	"startBackfill":     true,
+	"resumeBackfill":    true,
+	"rollbackSettings":  true,
+	"confirmUserLink":   true,
+}
+
 func (s *SettingsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = s.Auth.authenticate(w, r)
+	if r == nil {
+		return
+	}
+
+	if updateActions[r.FormValue("action")] && r.Method != "POST" {
+		w.WriteHeader(405)
+		w.Write([]byte("MethodNotAllowed"))
+		return
+	}
+
 	switch r.FormValue("action") {
 	case "getDiscordChannels":
 		s.GetDiscordChannels(w, r)
@@ -89,8 +153,38 @@ func (s *SettingsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		s.GetSlackChannels(w, r)
 	case "getDiscordGuildIdentity":
 		s.GetDiscordGuildIdentity(w, r)
+	case "getChannelMapCollisions":
+		s.GetChannelMapCollisions(w, r)
+	case "getDeadLetters":
+		s.GetDeadLetters(w, r)
+	case "getDeadLetter":
+		s.GetDeadLetter(w, r)
+	case "retryDeadLetter":
+		s.RetryDeadLetter(w, r)
+	case "discardDeadLetter":
+		s.DiscardDeadLetter(w, r)
+	case "getBackfillJobs":
+		s.GetBackfillJobs(w, r)
+	case "startBackfill":
+		s.StartBackfill(w, r)
+	case "resumeBackfill":
+		s.ResumeBackfill(w, r)
+	case "search":
+		s.Search(w, r)
+	case "getSettingsVersions":
+		s.GetSettingsVersions(w, r)
+	case "getSettingsDiff":
+		s.GetSettingsDiff(w, r)
+	case "rollbackSettings":
+		s.RollbackSettings(w, r)
+	case "validateSettings":
+		s.ValidateSettings(w, r)
+	case "getUserLinks":
+		s.GetUserLinks(w, r)
+	case "confirmUserLink":
+		s.ConfirmUserLink(w, r)
 	default:
-		w.Write([]byte("Bad Request"))
-		w.WriteHeader(500)
+		w.WriteHeader(400)
+		w.Write([]byte("BadRequest: UnknownAction"))
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/url"
 
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
 	"github.com/pkg/errors"
 )
 
@@ -31,6 +32,9 @@ type SlackChannel struct {
 	Topic              SlackChannelAbout `json:"topic"`
 	Purpose            SlackChannelAbout `json:"purpose"`
 	NumMembers         int               `json:"num_members"`
+	// TeamID and TeamName are set when several workspaces are configured
+	TeamID   string `json:"team_id,omitempty"`
+	TeamName string `json:"team_name,omitempty"`
 }
 
 type SlackChannelAbout struct {
@@ -43,6 +47,11 @@ const SlackAPIEndpoint = "https://slack.com/api/"
 
 type SlackHandler struct {
 	token string
+	// settings is the handler of the workspace, which is nil for the default handler without workspaces
+	settings *settings.Handler
+
+	TeamID   string
+	TeamName string
 }
 
 func NewSlackHandler(token string) *SlackHandler {
@@ -67,6 +76,7 @@ func (s *SlackHandler) GetChannels() ([]SlackChannel, error) {
 	for {
 		var body = make(url.Values)
 		body.Add("exclude_archived", "true")
+		body.Add("types", "public_channel,private_channel,mpim")
 		body.Add("limit", "1000")
 
 		if cursor != "" {
@@ -93,7 +103,11 @@ func (s *SlackHandler) GetChannels() ([]SlackChannel, error) {
 			break
 		}
 
-		channels = append(channels, apiRes.Channels...)
+		for _, channel := range apiRes.Channels {
+			channel.TeamID = s.TeamID
+			channel.TeamName = s.TeamName
+			channels = append(channels, channel)
+		}
 
 		if apiRes.Meta.NextCursor == "" {
 			break
@@ -1,11 +1,22 @@
 package configurator
 
-import "github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+import (
+	"io/fs"
+	"net/http"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/archive"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/backfill"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/dead_letter"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+)
 
 const (
 	CommandRestart = 1 + iota
 )
 
+var logger = logging.New("configurator")
+
 type Handler struct {
 	discord struct {
 		API string
@@ -13,10 +24,25 @@ type Handler struct {
 	slack struct {
 		API string
 	}
+	slackWorkspaces []slackWorkspace
+	handlers        map[string]http.Handler
+	deadLetters     *dead_letter.Store
+	backfill        *backfill.Handler
+	archive         *archive.Archive
+	auth            AuthConfig
+	announceChanges bool
+	assets          fs.FS
 
 	settings *SettingsHandler
 }
 
+type slackWorkspace struct {
+	teamID   string
+	name     string
+	api      string
+	settings *settings.Handler
+}
+
 func New(discord, slack string) *Handler {
 	var handler Handler
 	handler.discord.API = discord
@@ -25,6 +51,50 @@ func New(discord, slack string) *Handler {
 	return &handler
 }
 
+// AddSlackWorkspace adds a workspace whose channels are listed, with the settings handler running its channel map.
+// Without workspaces, the channels of the slack token are listed.
+func (h *Handler) AddSlackWorkspace(teamID, name, token string, setting *settings.Handler) {
+	h.slackWorkspaces = append(h.slackWorkspaces, slackWorkspace{teamID: teamID, name: name, api: token, settings: setting})
+}
+
+// Handle serves the handler for the pattern under the path prefix of the configurator
+func (h *Handler) Handle(pattern string, handler http.Handler) {
+	if h.handlers == nil {
+		h.handlers = map[string]http.Handler{}
+	}
+	h.handlers[pattern] = handler
+}
+
+// SetDeadLetters sets the store of failed deliveries, which can be retried or discarded in the configurator
+func (h *Handler) SetDeadLetters(store *dead_letter.Store) {
+	h.deadLetters = store
+}
+
+// SetBackfill sets the handler of backfill jobs, which can be started in the configurator
+func (h *Handler) SetBackfill(handler *backfill.Handler) {
+	h.backfill = handler
+}
+
+// SetArchive sets the archive of bridged messages, which can be searched in the configurator
+func (h *Handler) SetArchive(archive *archive.Archive) {
+	h.archive = archive
+}
+
+// SetAuth sets the login to the configurator
+func (h *Handler) SetAuth(config AuthConfig) {
+	h.auth = config
+}
+
+// SetAssets sets the files of the web pages, which have index.html and static/
+func (h *Handler) SetAssets(assets fs.FS) {
+	h.assets = assets
+}
+
+// SetAnnounceSettingsChanges sets whether the changes of the settings are posted to the admin channels
+func (h *Handler) SetAnnounceSettingsChanges(enabled bool) {
+	h.announceChanges = enabled
+}
+
 func (h Handler) Start(prefix, sock, addr string, setting *settings.Handler) (chan int, error) {
 	Discord, err := NewDiscordHandler(h.discord.API)
 	if err != nil {
@@ -39,6 +109,30 @@ func (h Handler) Start(prefix, sock, addr string, setting *settings.Handler) (ch
 		Slack,
 	)
 
+	for _, workspace := range h.slackWorkspaces {
+		var handler = NewSlackHandler(workspace.api)
+		handler.TeamID = workspace.teamID
+		handler.TeamName = workspace.name
+		handler.settings = workspace.settings
+		h.settings.SlackWorkspaces = append(h.settings.SlackWorkspaces, handler)
+	}
+
+	h.settings.Handlers = h.handlers
+	h.settings.DeadLetters = h.deadLetters
+	h.settings.Backfill = h.backfill
+	h.settings.Archive = h.archive
+	h.settings.AnnounceChanges = h.announceChanges
+	h.settings.Assets = h.assets
+	if h.auth.enabled() {
+		var config = h.auth
+		for _, workspace := range h.slackWorkspaces {
+			config.slackTeams = append(config.slackTeams, workspace.teamID)
+		}
+		h.settings.Auth = NewAuthenticator(config, Discord.Session, setting)
+	} else {
+		logger.Warn("AuthDisabled", "reason", "no login provider or API token is configured")
+	}
+
 	return h.settings.Start(prefix, sock, addr)
 }
 
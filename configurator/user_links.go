@@ -0,0 +1,93 @@
+package configurator
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+)
+
+// userLink is a link naming the Discord user logged in, which lets the Slack user log in as them once confirmed
+type userLink struct {
+	Guild     string `json:"guild"`
+	GuildName string `json:"guild_name,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Slack     string `json:"slack"`
+	Confirmed bool   `json:"confirmed"`
+}
+
+func (s *SettingsHandler) GetUserLinks(w http.ResponseWriter, r *http.Request) {
+	var links = []userLink{}
+
+	var discordID = sessionFromRequest(r).DiscordID
+	if discordID != "" {
+		dict, err := s.settings.GetChannelMap()
+		if err != nil {
+			w.WriteHeader(500)
+			w.Write([]byte("InternalServerError: GetSettingsError\n" + err.Error()))
+			return
+		}
+
+		for _, guild := range dict {
+			for _, link := range guild.UserLinks {
+				if link.Discord != discordID || link.Slack == "" {
+					continue
+				}
+				links = append(links, userLink{
+					Guild:     guild.Discord,
+					GuildName: s.guildName(guild.Discord),
+					Name:      link.Name,
+					Slack:     link.Slack,
+					Confirmed: s.settings.UserLinkConfirmed(guild.Discord, link),
+				})
+			}
+		}
+	}
+
+	w.Header().Add("Content-type", "application/json")
+
+	var err = json.NewEncoder(w).Encode(links)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: JsonEncodeError\n" + err.Error()))
+		return
+	}
+}
+
+// ConfirmUserLink records that the Discord user logged in accepts the link in the guild, or withdraws it with confirmed=no
+func (s *SettingsHandler) ConfirmUserLink(w http.ResponseWriter, r *http.Request) {
+	var discordID = sessionFromRequest(r).DiscordID
+	if discordID == "" {
+		w.WriteHeader(403)
+		w.Write([]byte("Forbidden: DiscordLoginRequired"))
+		return
+	}
+
+	var link = settings.UserLink{Slack: r.FormValue("slack"), Discord: discordID}
+	var guildID = r.FormValue("guild")
+	if link.Slack == "" || s.settings.FindUserLinks(guildID).DiscordUser(link.Slack) != discordID {
+		w.WriteHeader(404)
+		w.Write([]byte("NotFound: UserLink"))
+		return
+	}
+
+	var err = s.settings.SetUserLinkConfirmed(guildID, link, r.FormValue("confirmed") != "no")
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: ConfirmUserLinkError\n" + err.Error()))
+		return
+	}
+
+	w.Write([]byte("OK"))
+}
+
+func (s *SettingsHandler) guildName(guildID string) string {
+	if s.Discord == nil || s.Discord.Session == nil || s.Discord.Session.State == nil {
+		return ""
+	}
+	guild, err := s.Discord.Session.State.Guild(guildID)
+	if err != nil {
+		return ""
+	}
+	return guild.Name
+}
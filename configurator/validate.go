@@ -0,0 +1,318 @@
+package configurator
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+)
+
+// Validation is the result of checking settings before they are written
+type Validation struct {
+	Problems   []settings.Problem   `json:"problems"`
+	Routes     []settings.Route     `json:"routes"`
+	Collisions []settings.Collision `json:"collisions"`
+}
+
+// Errors returns the error level problems in the guilds the user can manage
+func (v Validation) Errors(session *Session) []settings.Problem {
+	var problems []settings.Problem
+	for _, problem := range v.Problems {
+		if problem.Level == settings.ProblemError && (problem.Guild == "" || session.CanManage(problem.Guild)) {
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+// For returns the result in the guilds the user can manage
+func (v Validation) For(session *Session) Validation {
+	if session.AllGuilds {
+		return v
+	}
+	var result = Validation{
+		Problems:   []settings.Problem{},
+		Routes:     []settings.Route{},
+		Collisions: filterCollisions(session, v.Collisions),
+	}
+	for _, problem := range v.Problems {
+		if problem.Guild == "" || session.CanManage(problem.Guild) {
+			result.Problems = append(result.Problems, problem)
+		}
+	}
+	for _, route := range v.Routes {
+		if session.CanManage(route.Guild) {
+			result.Routes = append(result.Routes, route)
+		}
+	}
+	return result
+}
+
+// ValidateSettings checks the requested settings against Slack and Discord, and returns the routes they make without writing them
+func (s *SettingsHandler) ValidateSettings(w http.ResponseWriter, r *http.Request) {
+	var table []settings.SlackDiscordTable
+
+	var err = json.NewDecoder(r.Body).Decode(&table)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte("BadRequest: ParseRequestedSettingsError\n" + err.Error()))
+		return
+	}
+
+	var session = sessionFromRequest(r)
+	if !session.AllGuilds {
+		current, err := s.settings.GetChannelMap()
+		if err != nil {
+			w.WriteHeader(500)
+			w.Write([]byte("InternalServerError: GetChannelMap\n" + err.Error()))
+			return
+		}
+
+		table, err = mergeSettings(session, current, table)
+		if err != nil {
+			w.WriteHeader(403)
+			w.Write([]byte("Forbidden: " + err.Error()))
+			return
+		}
+	}
+
+	w.Header().Add("Content-type", "application/json")
+
+	err = json.NewEncoder(w).Encode(s.validate(table).For(session))
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("InternalServerError: JsonEncodeError\n" + err.Error()))
+		return
+	}
+}
+
+func (s *SettingsHandler) workspaces() []*SlackHandler {
+	if len(s.SlackWorkspaces) < 2 {
+		return []*SlackHandler{s.Slack}
+	}
+	return s.SlackWorkspaces
+}
+
+// validate runs the checks of the settings package, then resolves the channels and the permissions of the bots
+func (s *SettingsHandler) validate(table []settings.SlackDiscordTable) Validation {
+	var result = Validation{
+		Problems:   settings.Check(table),
+		Routes:     []settings.Route{},
+		Collisions: []settings.Collision{},
+	}
+	var add = func(level, guild string, rule int, format string, args ...interface{}) {
+		result.Problems = append(result.Problems, settings.NewProblem(level, guild, rule, format, args...))
+	}
+
+	// the Slack channels of each workspace, where the first workspace is the default
+	var workspaces = s.workspaces()
+	var slackChannels = map[string]map[string]SlackChannel{}
+	for i, workspace := range workspaces {
+		var channels = map[string]SlackChannel{}
+		list, err := workspace.GetChannels()
+		if err != nil {
+			add(settings.ProblemWarning, "", -1, "Slackのチャンネル一覧を取得できません: %s", err)
+			channels = nil
+		}
+		for _, channel := range list {
+			channels[channel.ID] = channel
+		}
+		slackChannels[workspace.TeamID] = channels
+		if i == 0 {
+			slackChannels[""] = channels
+		}
+	}
+
+	var bot *discordgo.User
+	if s.Discord != nil {
+		var err error
+		bot, err = s.Discord.Session.User("@me")
+		if err != nil {
+			add(settings.ProblemWarning, "", -1, "Discordのボットの情報を取得できません: %s", err)
+		}
+	}
+
+	// the guilds of the bot, with the channels by ID
+	var guilds = map[string]*discordgo.Guild{}
+	var members = map[string]*discordgo.Member{}
+	var discordChannels = map[string]map[string]*discordgo.Channel{}
+	for _, guild := range table {
+		if guild.Discord == "" || bot == nil {
+			continue
+		}
+		if _, ok := guilds[guild.Discord]; ok {
+			continue
+		}
+
+		g, err := s.Discord.Session.Guild(guild.Discord)
+		if err != nil {
+			add(settings.ProblemError, guild.Discord, -1, "Discordのサーバが見つからないか、ボットが参加していません: %s", err)
+			guilds[guild.Discord] = nil
+			continue
+		}
+		guilds[guild.Discord] = g
+
+		member, err := s.Discord.Session.GuildMember(guild.Discord, bot.ID)
+		if err != nil {
+			add(settings.ProblemWarning, guild.Discord, -1, "ボットの権限を取得できません: %s", err)
+		} else {
+			members[guild.Discord] = member
+		}
+
+		list, err := s.Discord.Session.GuildChannels(guild.Discord)
+		if err != nil {
+			add(settings.ProblemWarning, guild.Discord, -1, "Discordのチャンネル一覧を取得できません: %s", err)
+			continue
+		}
+		var channels = map[string]*discordgo.Channel{}
+		for _, channel := range list {
+			channels[channel.ID] = channel
+		}
+		discordChannels[guild.Discord] = channels
+	}
+
+	for _, guild := range table {
+		var channels = discordChannels[guild.Discord]
+		for i, rule := range guild.Channel {
+			s.validateRule(add, guild.Discord, i, rule, channels, slackChannels)
+		}
+	}
+
+	// the routes are made by each workspace in the same way as the bridge
+	for i, workspace := range workspaces {
+		var handler = s.settings
+		if len(workspaces) > 1 {
+			handler = s.settings.ForSlackTeam(workspace.TeamID, workspace.token, i == 0)
+		}
+		routes, collisions, err := handler.PreviewRoutes(table)
+		if err != nil {
+			add(settings.ProblemWarning, "", -1, "チャンネルの対応を取得できません: %s", err)
+		}
+		result.Routes = append(result.Routes, routes...)
+		result.Collisions = append(result.Collisions, collisions...)
+	}
+
+	for _, route := range result.Routes {
+		var channel = discordChannels[route.Guild][route.DiscordChannel]
+		var member = members[route.Guild]
+		if channel == nil || member == nil {
+			continue
+		}
+
+		var permissions = memberPermissions(guilds[route.Guild], member, channel)
+		if permissions&discordgo.PermissionViewChannel == 0 {
+			add(settings.ProblemError, route.Guild, route.Rule, "ボットが#%sを閲覧できません", route.DiscordName)
+			continue
+		}
+		if route.SlackToDiscord && permissions&discordgo.PermissionManageWebhooks == 0 {
+			add(settings.ProblemError, route.Guild, route.Rule, "ボットに#%sのWebhookの管理権限がないため、Slackからのメッセージを送れません", route.DiscordName)
+		}
+		if route.DiscordToSlack && permissions&discordgo.PermissionReadMessageHistory == 0 {
+			add(settings.ProblemWarning, route.Guild, route.Rule, "ボットに#%sのメッセージ履歴を読む権限がありません", route.DiscordName)
+		}
+	}
+
+	s.findShadowedRules(add, table, result.Routes)
+
+	for _, collision := range result.Collisions {
+		if len(collision.DiscordChannels) == 0 {
+			continue
+		}
+		var names []string
+		for _, channel := range collision.DiscordChannels {
+			names = append(names, "#"+channel.Name)
+		}
+		add(settings.ProblemWarning, collision.DiscordChannels[0].GuildID, -1,
+			"Slackの#%sに複数のDiscordのチャンネルが対応しています: %s", collision.SlackChannelName, strings.Join(names, ", "))
+	}
+
+	return result
+}
+
+func (s *SettingsHandler) validateRule(
+	add func(level, guild string, rule int, format string, args ...interface{}),
+	guild string, i int, rule settings.ChannelSetting,
+	channels map[string]*discordgo.Channel, slackChannels map[string]map[string]SlackChannel,
+) {
+	if channels != nil {
+		if rule.DiscordChannel != "" && rule.DiscordChannel != "all" {
+			channel, ok := channels[rule.DiscordChannel]
+			if !ok {
+				add(settings.ProblemError, guild, i, "Discordのチャンネル%sがサーバにありません", rule.DiscordChannel)
+			} else if channel.Type != discordgo.ChannelTypeGuildText {
+				add(settings.ProblemError, guild, i, "Discordの#%sはテキストチャンネルではありません", channel.Name)
+			}
+		}
+		for _, category := range []string{rule.DiscordCategory, rule.Setting.DiscordChannelCategory} {
+			if category == "" {
+				continue
+			}
+			channel, ok := channels[category]
+			if !ok || channel.Type != discordgo.ChannelTypeGuildCategory {
+				add(settings.ProblemError, guild, i, "Discordのカテゴリ%sがサーバにありません", category)
+			}
+		}
+	}
+
+	// slack_team is ignored with a single workspace
+	var team = rule.SlackTeam
+	if len(s.SlackWorkspaces) < 2 {
+		team = ""
+	}
+	workspace, ok := slackChannels[team]
+	if !ok {
+		add(settings.ProblemError, guild, i, "Slackのワークスペース%sが設定されていません", rule.SlackTeam)
+		return
+	}
+	if workspace == nil || rule.SlackChannel == "" || rule.SlackChannel == "all" {
+		return
+	}
+	channel, ok := workspace[rule.SlackChannel]
+	if !ok {
+		add(settings.ProblemError, guild, i, "Slackのチャンネル%sが見つかりません。プライベートチャンネルにはボットを招待してください", rule.SlackChannel)
+		return
+	}
+	if !channel.IsMember {
+		add(settings.ProblemWarning, guild, i, "ボットがSlackの#%sに参加していません", channel.Name)
+	}
+}
+
+// findShadowedRules reports the rules of Discord channels which do not make the routes of the channels,
+// such as the rules after an all rule, which Check can not tell without the channel names
+func (s *SettingsHandler) findShadowedRules(
+	add func(level, guild string, rule int, format string, args ...interface{}),
+	table []settings.SlackDiscordTable, routes []settings.Route,
+) {
+	var defaultTeam string
+	if workspaces := s.workspaces(); len(workspaces) > 1 {
+		defaultTeam = workspaces[0].TeamID
+	}
+
+	var winners = map[string]int{}
+	for _, route := range routes {
+		winners[route.SlackTeam+"/"+route.Guild+"/"+route.DiscordChannel] = route.Rule
+	}
+
+	for _, guild := range table {
+		for i, rule := range guild.Channel {
+			if rule.DiscordChannel == "" || rule.DiscordChannel == "all" || rule.DiscordCategory != "" ||
+				rule.SlackChannel == "" || rule.SlackChannel == "all" {
+				continue
+			}
+			var team = rule.SlackTeam
+			if team == "" {
+				team = defaultTeam
+			}
+			// the rules after an all rule or of the same channel are reported by Check
+			winner, ok := winners[team+"/"+guild.Discord+"/"+rule.DiscordChannel]
+			if !ok || winner == i || winner >= len(guild.Channel) {
+				continue
+			}
+			if earlier := guild.Channel[winner]; earlier.DiscordCategory != "" || earlier.SlackChannel == "all" {
+				add(settings.ProblemWarning, guild.Discord, i, "先にあるルール%dが同じチャンネルに一致するため、Discordからのメッセージには使われません", winner)
+			}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package dead_letter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/delivery_queue"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/pkg/errors"
+)
+
+// MaxLetters is the number of letters kept, and the oldest letters are discarded over it
+const MaxLetters = 1000
+
+var logger = logging.New("dead_letter")
+
+// Letter is a delivery given up by the delivery queue
+type Letter struct {
+	ID string `json:"id"`
+	// Queue is the name of the delivery queue, such as "discord" or "slack_TEAMID"
+	Queue  string `json:"queue"`
+	Target string `json:"target"`
+	// Event is the original event, and Payload is the message transformed for the target
+	Event    json.RawMessage `json:"event,omitempty"`
+	Payload  json.RawMessage `json:"payload"`
+	Error    string          `json:"error"`
+	Attempts int             `json:"attempts"`
+	Created  time.Time       `json:"created"`
+	Failed   time.Time       `json:"failed"`
+}
+
+// Store keeps the letters in a file, which can be retried through the queue they came from
+type Store struct {
+	path    string
+	letters []Letter
+	queues  map[string]*delivery_queue.Queue
+
+	notifier func(letter Letter)
+	seq      int64
+
+	mu sync.Mutex
+}
+
+func Open(path string) (*Store, error) {
+	var s = &Store{
+		path:    path,
+		letters: []Letter{},
+		queues:  map[string]*delivery_queue.Queue{},
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+
+	err = json.Unmarshal(b, &s.letters)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+	return s, nil
+}
+
+// Register makes the jobs given up by the queue kept as letters
+func (s *Store) Register(name string, queue *delivery_queue.Queue) {
+	s.mu.Lock()
+	s.queues[name] = queue
+	s.mu.Unlock()
+
+	queue.SetFailureHandler(func(job delivery_queue.Job, err error) {
+		s.add(Letter{
+			Queue:    name,
+			Target:   job.Target,
+			Event:    job.Event,
+			Payload:  job.Payload,
+			Error:    err.Error(),
+			Attempts: job.Attempts,
+			Created:  job.Created,
+			Failed:   time.Now(),
+		})
+	})
+}
+
+// SetNotifier sets the handler called for every new letter
+func (s *Store) SetNotifier(notifier func(letter Letter)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifier = notifier
+}
+
+func (s *Store) add(letter Letter) {
+	s.mu.Lock()
+
+	s.seq++
+	letter.ID = fmt.Sprintf("%d-%d", letter.Failed.UnixNano(), s.seq)
+
+	s.letters = append(s.letters, letter)
+	if len(s.letters) > MaxLetters {
+		s.letters = s.letters[len(s.letters)-MaxLetters:]
+	}
+
+	var err = s.save()
+	var notifier = s.notifier
+	s.mu.Unlock()
+
+	if err != nil {
+		logger.Error("SaveDeadLetters", "error", err)
+	}
+	if notifier != nil {
+		notifier(letter)
+	}
+}
+
+// List returns the letters, the newest first
+func (s *Store) List() []Letter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var letters = make([]Letter, len(s.letters))
+	copy(letters, s.letters)
+	sort.SliceStable(letters, func(i, j int) bool {
+		return letters[i].Failed.After(letters[j].Failed)
+	})
+	return letters
+}
+
+func (s *Store) Get(id string) (Letter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var i = s.index(id)
+	if i < 0 {
+		return Letter{}, false
+	}
+	return s.letters[i], true
+}
+
+// Retry queues the payload of the letter again, and removes the letter
+func (s *Store) Retry(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var i = s.index(id)
+	if i < 0 {
+		return errors.Errorf("NotFound: %s", id)
+	}
+
+	var letter = s.letters[i]
+	queue, ok := s.queues[letter.Queue]
+	if !ok {
+		return errors.Errorf("QueueNotFound: %s", letter.Queue)
+	}
+	queue.Enqueue(letter.Target, letter.Payload, letter.Event)
+
+	s.letters = append(s.letters[:i], s.letters[i+1:]...)
+	return s.save()
+}
+
+func (s *Store) Discard(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var i = s.index(id)
+	if i < 0 {
+		return errors.Errorf("NotFound: %s", id)
+	}
+
+	s.letters = append(s.letters[:i], s.letters[i+1:]...)
+	return s.save()
+}
+
+// index must be called with mu held
+func (s *Store) index(id string) int {
+	for i, letter := range s.letters {
+		if letter.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// save must be called with mu held
+func (s *Store) save() error {
+	b, err := json.MarshalIndent(s.letters, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+
+	var tmp = s.path + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return errors.Wrap(err, "WriteFile")
+	}
+	return os.Rename(tmp, s.path)
+}
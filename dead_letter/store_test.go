@@ -0,0 +1,70 @@
+package dead_letter
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/delivery_queue"
+)
+
+func TestRetry(t *testing.T) {
+	var dir = t.TempDir()
+
+	store, err := Open(filepath.Join(dir, "dead_letters.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var delivered = make(chan string, 1)
+	var fail = true
+	queue, err := delivery_queue.Open(filepath.Join(dir, "queue"), func(target string, payload []byte) ([]byte, time.Duration, error) {
+		if fail {
+			return nil, 0, errors.New("invalid_blocks")
+		}
+		delivered <- string(payload)
+		return nil, 0, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Register("slack", queue)
+
+	var notified = make(chan Letter, 1)
+	store.SetNotifier(func(letter Letter) {
+		notified <- letter
+	})
+
+	_, err = queue.Push("C1", []byte(`"message"`), []byte(`{"type":"message"}`), time.Second)
+	if err == nil {
+		t.Fatal("Expected the delivery to fail")
+	}
+
+	var letter = <-notified
+	if letter.Queue != "slack" || letter.Target != "C1" || string(letter.Event) != `{"type":"message"}` || letter.Error != "invalid_blocks" {
+		t.Fatalf("Unexpected letter: %+v", letter)
+	}
+
+	// letters survive restarts
+	store, err = Open(filepath.Join(dir, "dead_letters.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Register("slack", queue)
+	if letters := store.List(); len(letters) != 1 || letters[0].ID != letter.ID {
+		t.Fatalf("Expected the letter to be loaded, but got %+v", letters)
+	}
+
+	fail = false
+	err = store.Retry(letter.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := <-delivered; got != `"message"` {
+		t.Fatalf("Expected the payload to be delivered again, but got %s", got)
+	}
+	if _, ok := store.Get(letter.ID); ok {
+		t.Fatal("Expected the retried letter to be removed")
+	}
+}
@@ -0,0 +1,341 @@
+package delivery_queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultWait is how long Push waits for the delivery before the caller gives up on the result
+	DefaultWait = 30 * time.Second
+	// LiveWait is how long the event handlers wait, which must not stall the other channels
+	LiveWait = 3 * time.Second
+
+	MaxAttempts    = 12
+	InitialBackoff = time.Second
+	MaxBackoff     = 5 * time.Minute
+)
+
+// ErrQueued is returned by Push when the job is not delivered within the wait, which is delivered later
+var ErrQueued = errors.New("DeliveryQueued")
+
+var logger = logging.New("delivery_queue")
+
+// Deliver sends the payload to the target.
+// next is the time to wait before the next delivery to the target, such as by rate limit headers.
+// Errors wrapped by Retry are retried with backoff, and other errors fail the job at once.
+type Deliver func(target string, payload []byte) (result []byte, next time.Duration, err error)
+
+// Job is a delivery persisted until it succeeds or fails
+type Job struct {
+	ID      string          `json:"id"`
+	Target  string          `json:"target"`
+	Payload json.RawMessage `json:"payload"`
+	// Event is the original event of the delivery, which is kept for the failure handler
+	Event    json.RawMessage `json:"event,omitempty"`
+	Attempts int             `json:"attempts"`
+	Created  time.Time       `json:"created"`
+}
+
+type result struct {
+	value []byte
+	err   error
+}
+
+// Queue delivers jobs in order for each target, which are stored in a file for each target so that they survive restarts
+type Queue struct {
+	dir     string
+	deliver Deliver
+
+	targets map[string]*target
+	waiters map[string]chan result
+	seq     int64
+
+	failureHandler func(job Job, err error)
+
+	mu sync.Mutex
+}
+
+type target struct {
+	jobs   []Job
+	wakeup chan struct{}
+}
+
+// Open loads the jobs left in the directory and starts delivering them
+func Open(dir string, deliver Deliver) (*Queue, error) {
+	var q = &Queue{
+		dir:     dir,
+		deliver: deliver,
+		targets: map[string]*target{},
+		waiters: map[string]chan result{},
+	}
+
+	var err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "MkdirAll")
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadDir")
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "ReadFile")
+		}
+
+		var jobs []Job
+		err = json.Unmarshal(b, &jobs)
+		if err != nil {
+			logger.Error("UnmarshalQueue", "file", file.Name(), "error", err)
+			continue
+		}
+		if len(jobs) == 0 {
+			continue
+		}
+
+		var t = q.target(jobs[0].Target)
+		t.jobs = jobs
+		t.wake()
+	}
+
+	return q, nil
+}
+
+// Push queues the payload and waits for its delivery up to wait.
+// ErrQueued is returned if it is not delivered yet, and the job is delivered later.
+// It returns at once while the target is retrying a delivery, as the job waits for the backoff.
+func (q *Queue) Push(targetID string, payload, event []byte, wait time.Duration) ([]byte, error) {
+	var job, done = q.push(targetID, payload, event, true)
+
+	q.mu.Lock()
+	var t = q.targets[targetID]
+	var retrying = len(t.jobs) > 0 && t.jobs[0].Attempts > 0
+	if retrying {
+		delete(q.waiters, job.ID)
+	}
+	q.mu.Unlock()
+	if retrying {
+		return nil, ErrQueued
+	}
+
+	var timer = time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-timer.C:
+		q.mu.Lock()
+		delete(q.waiters, job.ID)
+		q.mu.Unlock()
+		return nil, ErrQueued
+	}
+}
+
+// Enqueue queues the payload without waiting for its delivery
+func (q *Queue) Enqueue(targetID string, payload, event []byte) {
+	q.push(targetID, payload, event, false)
+}
+
+// SetFailureHandler sets the handler of jobs which are given up, such as to keep them as dead letters
+func (q *Queue) SetFailureHandler(handler func(job Job, err error)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.failureHandler = handler
+}
+
+func (q *Queue) push(targetID string, payload, event []byte, wait bool) (Job, chan result) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	var job = Job{
+		ID:      fmt.Sprintf("%d-%d", time.Now().UnixNano(), q.seq),
+		Target:  targetID,
+		Payload: payload,
+		Event:   event,
+		Created: time.Now(),
+	}
+
+	var done chan result
+	if wait {
+		done = make(chan result, 1)
+		q.waiters[job.ID] = done
+	}
+
+	var t = q.target(targetID)
+	t.jobs = append(t.jobs, job)
+
+	var err = q.save(targetID)
+	if err != nil {
+		logger.Error("SaveQueue", "target", targetID, "error", err)
+	}
+	t.wake()
+
+	return job, done
+}
+
+// Len returns the number of jobs waiting for the delivery
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var n int
+	for _, t := range q.targets {
+		n += len(t.jobs)
+	}
+	return n
+}
+
+// target returns the jobs of the target, whose worker is started at first, and must be called with mu held
+func (q *Queue) target(targetID string) *target {
+	t, ok := q.targets[targetID]
+	if ok {
+		return t
+	}
+
+	t = &target{wakeup: make(chan struct{}, 1)}
+	q.targets[targetID] = t
+	go q.work(targetID, t)
+
+	return t
+}
+
+func (t *target) wake() {
+	select {
+	case t.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) work(targetID string, t *target) {
+	for range t.wakeup {
+		for {
+			q.mu.Lock()
+			if len(t.jobs) == 0 {
+				q.mu.Unlock()
+				break
+			}
+			var job = t.jobs[0]
+			q.mu.Unlock()
+
+			var value, next, err = q.deliver(targetID, job.Payload)
+
+			var retry *RetryError
+			if err != nil && errors.As(err, &retry) && job.Attempts+1 < MaxAttempts {
+				q.mu.Lock()
+				t.jobs[0].Attempts++
+				var saveErr = q.save(targetID)
+				q.mu.Unlock()
+				if saveErr != nil {
+					logger.Error("SaveQueue", "target", targetID, "error", saveErr)
+				}
+
+				time.Sleep(backoff(job.Attempts, retry.After))
+				continue
+			}
+
+			if err != nil {
+				logger.Error("DeliveryFailed", "target", targetID, "attempts", job.Attempts+1, "error", err)
+			}
+
+			q.mu.Lock()
+			t.jobs = t.jobs[1:]
+			var saveErr = q.save(targetID)
+			var done, waiting = q.waiters[job.ID]
+			delete(q.waiters, job.ID)
+			var failureHandler = q.failureHandler
+			q.mu.Unlock()
+
+			if saveErr != nil {
+				logger.Error("SaveQueue", "target", targetID, "error", saveErr)
+			}
+			if waiting {
+				done <- result{value: value, err: err}
+			}
+			if err != nil && failureHandler != nil {
+				job.Attempts++
+				failureHandler(job, err)
+			}
+
+			if next > 0 {
+				time.Sleep(next)
+			}
+		}
+	}
+}
+
+// save writes the jobs of the target, and must be called with mu held
+func (q *Queue) save(targetID string) error {
+	var path = filepath.Join(q.dir, targetID+".json")
+
+	var jobs = q.targets[targetID].jobs
+	if len(jobs) == 0 {
+		var err = os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	b, err := json.Marshal(jobs)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+
+	var tmp = path + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return errors.Wrap(err, "WriteFile")
+	}
+	return os.Rename(tmp, path)
+}
+
+func backoff(attempts int, after time.Duration) time.Duration {
+	var d = InitialBackoff << uint(attempts)
+	if d > MaxBackoff || d <= 0 {
+		d = MaxBackoff
+	}
+	if after > d {
+		d = after
+	}
+	return d
+}
+
+// RetryError marks an error to be retried, such as network errors, rate limits and server errors
+type RetryError struct {
+	Err error
+	// After is the wait requested by the server, such as by Retry-After
+	After time.Duration
+}
+
+func Retry(err error, after time.Duration) error {
+	return &RetryError{Err: err, After: after}
+}
+
+func (e *RetryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,94 @@
+package delivery_queue
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPush(t *testing.T) {
+	var mu sync.Mutex
+	var delivered []string
+	var failed bool
+
+	queue, err := Open(t.TempDir(), func(target string, payload []byte) ([]byte, time.Duration, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// the first delivery fails once
+		if string(payload) == "1" && !failed {
+			failed = true
+			return nil, 0, Retry(errors.New("unavailable"), 0)
+		}
+		delivered = append(delivered, string(payload))
+		return append([]byte("ok:"), payload...), 0, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		result, err := queue.Push("C1", []byte("1"), nil, 10*time.Second)
+		if err != nil || string(result) != "ok:1" {
+			t.Errorf("Expected ok:1, but got %q, %v", result, err)
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// the caller is not blocked while the target is retrying, and the job is delivered after the first one
+	var start = time.Now()
+	_, err = queue.Push("C1", []byte("2"), nil, 10*time.Second)
+	if err != ErrQueued || time.Since(start) > time.Second {
+		t.Fatalf("Expected ErrQueued at once, but got %v after %s", err, time.Since(start))
+	}
+	wg.Wait()
+
+	for i := 0; queue.Len() > 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(delivered) != 2 || delivered[0] != "1" || delivered[1] != "2" {
+		t.Fatalf("Expected to be delivered in order, but got %v", delivered)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	var dir = t.TempDir()
+
+	var block = make(chan struct{})
+	queue, err := Open(dir, func(target string, payload []byte) ([]byte, time.Duration, error) {
+		<-block
+		return nil, 0, Retry(errors.New("stopped"), time.Hour)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = queue.Push("C1", []byte("1"), nil, 10*time.Millisecond)
+	if err != ErrQueued {
+		t.Fatalf("Expected ErrQueued, but got %v", err)
+	}
+
+	var delivered = make(chan string, 1)
+	_, err = Open(dir, func(target string, payload []byte) ([]byte, time.Duration, error) {
+		delivered <- target + ":" + string(payload)
+		return nil, 0, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-delivered:
+		if got != "C1:1" {
+			t.Fatalf("Expected C1:1, but got %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the job to be delivered after reopen")
+	}
+}
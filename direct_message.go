@@ -0,0 +1,537 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/slack_webhook"
+	"github.com/kyokomi/emoji"
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// DirectMessagePrefix specifies the recipient of a direct message, such as "to:name hello"
+const DirectMessagePrefix = "to:"
+
+// directMessageRelayLimit is the number of relayed messages kept for replies and reactions
+const directMessageRelayLimit = 1000
+
+const directMessageHelp = "DMの転送\n" +
+	"`to:名前 メッセージ` 相手にDMを送ります。Slackではスレッドへの返信、Discordでは返信で同じ相手に送れます。\n" +
+	"`!optin` DMの受信を許可します。\n" +
+	"`!optout` DMの受信を停止します。\n" +
+	"`!block 名前` `!unblock 名前` 相手からのDMを拒否・許可します。\n" +
+	"`!list` DMを送れる相手の一覧を表示します。"
+
+// DirectMessageHandler relays direct messages to the bot between Slack and Discord users named in user_links
+type DirectMessageHandler struct {
+	slack      *slack.Client
+	slackToken string
+	slackHook  *slack_webhook.Handler
+	discord    *discordgo.Session
+	settings   *settings.Handler
+
+	escaper MessageEscaper
+
+	state   *directMessageState
+	relayed relayedMessages
+	logger  *logging.Logger
+}
+
+type relayedMessage struct {
+	SlackChannel   string
+	SlackTS        string
+	DiscordChannel string
+	DiscordMessage string
+	SlackUser      string
+	DiscordUser    string
+}
+
+type relayedMessages struct {
+	mu       sync.Mutex
+	messages []relayedMessage
+}
+
+func NewDirectMessageHandler(slackToken string, slackHook *slack_webhook.Handler, discord *discordgo.Session, settings *settings.Handler, statePath string) (*DirectMessageHandler, error) {
+	state, err := loadDirectMessageState(statePath)
+	if err != nil {
+		err = errors.Wrap(err, "LoadDirectMessageState")
+	}
+
+	return &DirectMessageHandler{
+		slack:      slack.New(slackToken),
+		slackToken: slackToken,
+		slackHook:  slackHook,
+		discord:    discord,
+		settings:   settings,
+		state:      state,
+		logger:     logging.New("direct_message"),
+	}, err
+}
+
+func (h *DirectMessageHandler) SetMessageEscaper(escaper MessageEscaper) {
+	h.escaper = escaper
+}
+
+// SlackMessage relays a message sent to the bot in a Slack DM
+func (h *DirectMessageHandler) SlackMessage(ev *slackevents.MessageEvent) {
+	if ev.User == "" || ev.BotID != "" {
+		return
+	}
+	switch ev.SubType {
+	case "", "file_share":
+	default:
+		return
+	}
+
+	var sender = "slack:" + ev.User
+	var text = strings.TrimSpace(ev.Text)
+
+	var reply = func(message string) {
+		_, _, err := h.slack.PostMessage(ev.Channel, slack.MsgOptionText(message, false), slack.MsgOptionTS(ev.ThreadTimeStamp))
+		if err != nil {
+			h.logger.Error("PostMessage", "error", err)
+		}
+	}
+
+	if message, ok := h.command(sender, text, false); ok {
+		reply(message)
+		return
+	}
+
+	var discordUser string
+	if ev.ThreadTimeStamp != "" {
+		discordUser = h.state.ThreadCounterpart(ev.User, ev.ThreadTimeStamp)
+	}
+	if name, content, ok := parseDirectMessage(text); ok {
+		link, found := h.settings.AllUserLinks().Find(name)
+		if !found || link.Discord == "" {
+			reply(fmt.Sprintf("宛先 %s が見つかりません。", name))
+			return
+		}
+		discordUser = link.Discord
+		text = content
+	}
+	if discordUser == "" {
+		reply(directMessageHelp)
+		return
+	}
+
+	if err := h.permitted(sender, "discord:"+discordUser); err != nil {
+		reply(err.Error())
+		return
+	}
+
+	var content = text
+	if h.escaper != nil {
+		escaped, err := h.escaper.EscapeMessage(text)
+		if err == nil {
+			content = escaped
+		}
+	}
+
+	var name = ev.User
+	profile, err := h.slackHook.GetUserProfile(ev.User, false)
+	if err == nil {
+		name = profile.DisplayName
+		if name == "" {
+			name = profile.RealName
+		}
+	}
+
+	var files []*discordgo.File
+	for _, f := range ev.Files {
+		req, err := http.NewRequest("GET", f.URLPrivate, nil)
+		if err != nil {
+			continue
+		}
+		req.Header.Set("Authorization", "Bearer "+h.slackToken)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			h.logger.Error("GetSlackFile", "error", err)
+			continue
+		}
+		defer resp.Body.Close()
+
+		files = append(files, &discordgo.File{Name: f.Name, ContentType: f.Mimetype, Reader: resp.Body})
+	}
+
+	channel, err := h.discord.UserChannelCreate(discordUser)
+	if err != nil {
+		h.logger.Error("UserChannelCreate", "error", err)
+		reply("DMを送れませんでした。")
+		return
+	}
+
+	message, err := h.discord.ChannelMessageSendComplex(channel.ID, &discordgo.MessageSend{
+		Content: fmt.Sprintf("**%s** (Slack): %s", name, content),
+		Files:   files,
+	})
+	if err != nil {
+		h.logger.Error("ChannelMessageSendComplex", "error", err)
+		reply("DMを送れませんでした。")
+		return
+	}
+
+	h.relayed.add(relayedMessage{
+		SlackChannel:   ev.Channel,
+		SlackTS:        ev.TimeStamp,
+		DiscordChannel: channel.ID,
+		DiscordMessage: message.ID,
+		SlackUser:      ev.User,
+		DiscordUser:    discordUser,
+	})
+
+	err = h.state.SetLastCounterpart(discordUser, ev.User)
+	if err != nil {
+		h.logger.Error("SetLastCounterpart", "error", err)
+	}
+}
+
+// DiscordMessage relays a message sent to the bot in a Discord DM
+func (h *DirectMessageHandler) DiscordMessage(m *discordgo.MessageCreate) {
+	if m.Author == nil || m.Author.Bot {
+		return
+	}
+
+	var sender = "discord:" + m.Author.ID
+	var text = strings.TrimSpace(m.Content)
+
+	var reply = func(message string) {
+		_, err := h.discord.ChannelMessageSend(m.ChannelID, message)
+		if err != nil {
+			h.logger.Error("ChannelMessageSend", "error", err)
+		}
+	}
+
+	if message, ok := h.command(sender, text, true); ok {
+		reply(message)
+		return
+	}
+
+	var slackUser string
+	if m.MessageReference != nil {
+		if relayed, ok := h.relayed.findDiscord(m.ChannelID, m.MessageReference.MessageID); ok {
+			slackUser = relayed.SlackUser
+		}
+	}
+	if name, content, ok := parseDirectMessage(text); ok {
+		link, found := h.settings.AllUserLinks().Find(name)
+		if !found || link.Slack == "" {
+			reply(fmt.Sprintf("宛先 %s が見つかりません。", name))
+			return
+		}
+		slackUser = link.Slack
+		text = content
+	}
+	if slackUser == "" {
+		slackUser = h.state.LastCounterpart(m.Author.ID)
+	}
+	if slackUser == "" {
+		reply(directMessageHelp)
+		return
+	}
+
+	if err := h.permitted(sender, "slack:"+slackUser); err != nil {
+		reply(err.Error())
+		return
+	}
+
+	channel, _, _, err := h.slack.OpenConversation(&slack.OpenConversationParameters{Users: []string{slackUser}})
+	if err != nil {
+		h.logger.Error("OpenConversation", "error", err)
+		reply("DMを送れませんでした。")
+		return
+	}
+
+	threadTS, err := h.slackThread(channel.ID, slackUser, m.Author)
+	if err != nil {
+		h.logger.Error("SlackThread", "error", err)
+		reply("DMを送れませんでした。")
+		return
+	}
+
+	var name = m.Author.Username
+	_, ts, err := h.slack.PostMessage(
+		channel.ID,
+		slack.MsgOptionText(escapeSlackText(text), false),
+		slack.MsgOptionUsername(name),
+		slack.MsgOptionIconURL(m.Author.AvatarURL("")),
+		slack.MsgOptionTS(threadTS),
+	)
+	if err != nil {
+		h.logger.Error("PostMessage", "error", err)
+		reply("DMを送れませんでした。")
+		return
+	}
+
+	for _, attachment := range m.Attachments {
+		resp, err := http.Get(attachment.URL)
+		if err != nil {
+			h.logger.Error("GetDiscordAttachment", "error", err)
+			continue
+		}
+
+		_, err = h.slack.UploadFile(slack.FileUploadParameters{
+			Reader:          resp.Body,
+			Filename:        attachment.Filename,
+			Channels:        []string{channel.ID},
+			ThreadTimestamp: threadTS,
+		})
+		resp.Body.Close()
+		if err != nil {
+			h.logger.Error("UploadFile", "error", err)
+		}
+	}
+
+	h.relayed.add(relayedMessage{
+		SlackChannel:   channel.ID,
+		SlackTS:        ts,
+		DiscordChannel: m.ChannelID,
+		DiscordMessage: m.ID,
+		SlackUser:      slackUser,
+		DiscordUser:    m.Author.ID,
+	})
+
+	err = h.state.SetLastCounterpart(m.Author.ID, slackUser)
+	if err != nil {
+		h.logger.Error("SetLastCounterpart", "error", err)
+	}
+}
+
+// SlackReaction relays a reaction of the DM participant to the Discord message
+func (h *DirectMessageHandler) SlackReaction(channel, timestamp, user, reaction string, added bool) {
+	relayed, ok := h.relayed.findSlack(channel, timestamp)
+	if !ok || relayed.SlackUser != user {
+		return
+	}
+
+	var unicode = slackReactionToUnicode(reaction)
+	if unicode == "" {
+		// custom emoji cannot be used on Discord
+		return
+	}
+
+	var err error
+	if added {
+		err = h.discord.MessageReactionAdd(relayed.DiscordChannel, relayed.DiscordMessage, unicode)
+	} else {
+		err = h.discord.MessageReactionRemove(relayed.DiscordChannel, relayed.DiscordMessage, unicode, "@me")
+	}
+	if err != nil {
+		h.logger.Error("DiscordMessageReaction", "error", err)
+	}
+}
+
+// DiscordReaction relays a reaction of the DM participant to the Slack message
+func (h *DirectMessageHandler) DiscordReaction(channelID, messageID, userID string, reaction discordgo.Emoji, added bool) {
+	relayed, ok := h.relayed.findDiscord(channelID, messageID)
+	if !ok || relayed.DiscordUser != userID || reaction.ID != "" {
+		return
+	}
+
+	var name = unicodeToSlackReaction(reaction.Name)
+	if name == "" {
+		return
+	}
+
+	var ref = slack.NewRefToMessage(relayed.SlackChannel, relayed.SlackTS)
+	var err error
+	if added {
+		err = h.slack.AddReaction(name, ref)
+	} else {
+		err = h.slack.RemoveReaction(name, ref)
+	}
+	if err != nil {
+		h.logger.Error("SlackMessageReaction", "error", err)
+	}
+}
+
+// command handles opt-in and block commands, and returns the reply
+func (h *DirectMessageHandler) command(sender, text string, fromDiscord bool) (string, bool) {
+	var fields = strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "!") {
+		return "", false
+	}
+
+	var err error
+	switch fields[0] {
+	case "!optin":
+		err = h.state.SetOptIn(sender, true)
+		if err == nil {
+			return "DMの受信を許可しました。", true
+		}
+	case "!optout":
+		err = h.state.SetOptIn(sender, false)
+		if err == nil {
+			return "DMの受信を停止しました。", true
+		}
+	case "!block", "!unblock":
+		if len(fields) < 2 {
+			return directMessageHelp, true
+		}
+
+		var target = directMessageTarget(h.settings.AllUserLinks(), fields[1], fromDiscord)
+		if target == "" {
+			return fmt.Sprintf("%s が見つかりません。", fields[1]), true
+		}
+
+		var block = fields[0] == "!block"
+		err = h.state.SetBlock(sender, target, block)
+		if err == nil && block {
+			return fmt.Sprintf("%s からのDMを拒否しました。", fields[1]), true
+		}
+		if err == nil {
+			return fmt.Sprintf("%s からのDMを許可しました。", fields[1]), true
+		}
+	case "!list":
+		var names []string
+		for _, link := range h.settings.AllUserLinks() {
+			var target = counterpartKey(link, fromDiscord)
+			if link.Name != "" && target != "" && h.state.IsOptedIn(target) {
+				names = append(names, link.Name)
+			}
+		}
+		if len(names) == 0 {
+			return "DMを送れる相手はいません。", true
+		}
+		return strings.Join(names, "\n"), true
+	case "!help":
+		return directMessageHelp, true
+	default:
+		return "", false
+	}
+
+	h.logger.Error("DirectMessageCommand", "error", err)
+	return "設定を保存できませんでした。", true
+}
+
+// permitted returns the reason if the sender cannot send a DM to the recipient
+func (h *DirectMessageHandler) permitted(sender, recipient string) error {
+	if !h.state.IsOptedIn(sender) {
+		return errors.New("先に `!optin` でDMの受信を許可してください。")
+	}
+	if !h.state.IsOptedIn(recipient) || h.state.IsBlocked(recipient, sender) {
+		return errors.New("相手はDMを受け付けていません。")
+	}
+	return nil
+}
+
+// slackThread returns the thread of the Slack DM for the Discord user, posting its first message if needed
+func (h *DirectMessageHandler) slackThread(channelID, slackUser string, discordUser *discordgo.User) (string, error) {
+	if ts := h.state.Thread(slackUser, discordUser.ID); ts != "" {
+		return ts, nil
+	}
+
+	_, ts, err := h.slack.PostMessage(channelID, slack.MsgOptionText(
+		fmt.Sprintf("%s (Discord) からのDMです。このスレッドに返信すると転送されます。", escapeSlackText(discordUser.Username)), false,
+	))
+	if err != nil {
+		return "", errors.Wrap(err, "PostMessage")
+	}
+
+	return ts, h.state.SetThread(slackUser, discordUser.ID, ts)
+}
+
+func (r *relayedMessages) add(message relayedMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, message)
+	if len(r.messages) > directMessageRelayLimit {
+		r.messages = r.messages[len(r.messages)-directMessageRelayLimit:]
+	}
+}
+
+func (r *relayedMessages) findSlack(channel, timestamp string) (relayedMessage, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, message := range r.messages {
+		if message.SlackChannel == channel && message.SlackTS == timestamp {
+			return message, true
+		}
+	}
+	return relayedMessage{}, false
+}
+
+func (r *relayedMessages) findDiscord(channelID, messageID string) (relayedMessage, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, message := range r.messages {
+		if message.DiscordChannel == channelID && message.DiscordMessage == messageID {
+			return message, true
+		}
+	}
+	return relayedMessage{}, false
+}
+
+// parseDirectMessage splits "to:name message" into the recipient and the message
+func parseDirectMessage(text string) (name, content string, ok bool) {
+	if !strings.HasPrefix(text, DirectMessagePrefix) {
+		return "", "", false
+	}
+
+	var fields = strings.SplitN(strings.TrimPrefix(text, DirectMessagePrefix), " ", 2)
+	name = strings.TrimPrefix(strings.TrimSpace(fields[0]), "@")
+	if name == "" {
+		return "", "", false
+	}
+	if len(fields) == 2 {
+		content = strings.TrimSpace(fields[1])
+	}
+	return name, content, true
+}
+
+// directMessageTarget returns the key of the user on the other side of the sender
+func directMessageTarget(links settings.UserLinks, name string, fromDiscord bool) string {
+	link, ok := links.Find(name)
+	if !ok {
+		return ""
+	}
+	return counterpartKey(link, fromDiscord)
+}
+
+// counterpartKey returns the key of the linked user on the other side of the sender
+func counterpartKey(link settings.UserLink, fromDiscord bool) string {
+	if fromDiscord {
+		if link.Slack == "" {
+			return ""
+		}
+		return "slack:" + link.Slack
+	}
+	if link.Discord == "" {
+		return ""
+	}
+	return "discord:" + link.Discord
+}
+
+func escapeSlackText(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// slackReactionToUnicode converts the Slack reaction name such as "+1::skin-tone-2" to the unicode emoji
+func slackReactionToUnicode(name string) string {
+	name = strings.SplitN(name, "::", 2)[0]
+	return emoji.CodeMap()[":"+name+":"]
+}
+
+// unicodeToSlackReaction converts the unicode emoji to the Slack reaction name
+func unicodeToSlackReaction(unicode string) string {
+	var aliases = emoji.RevCodeMap()[unicode]
+	if len(aliases) == 0 {
+		aliases = emoji.RevCodeMap()[strings.TrimSuffix(unicode, "\ufe0f")]
+	}
+	if len(aliases) == 0 {
+		return ""
+	}
+	return strings.Trim(aliases[0], ":")
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// directMessageState is persisted so that opt-ins, blocks and relay threads survive restarts.
+type directMessageState struct {
+	// users of OptIn and Blocks are keyed as "slack:ID" or "discord:ID"
+	OptIn  map[string]bool     `json:"opt_in"`
+	Blocks map[string][]string `json:"blocks"`
+	// Threads holds the Slack thread of each Slack and Discord user pair, keyed as "SLACK_ID|DISCORD_ID"
+	Threads map[string]string `json:"threads"`
+	// Last holds the Slack user whom the Discord user talked with last
+	Last map[string]string `json:"last"`
+
+	path string
+	mu   sync.Mutex
+}
+
+func loadDirectMessageState(path string) (*directMessageState, error) {
+	var state = &directMessageState{
+		OptIn:   map[string]bool{},
+		Blocks:  map[string][]string{},
+		Threads: map[string]string{},
+		Last:    map[string]string{},
+		path:    path,
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, errors.Wrap(err, "ReadFile")
+	}
+
+	err = json.Unmarshal(b, state)
+	if err != nil {
+		return state, errors.Wrap(err, "Unmarshal")
+	}
+	return state, nil
+}
+
+// save must be called with mu held
+func (s *directMessageState) save() error {
+	b, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+	return ioutil.WriteFile(s.path, b, 0644)
+}
+
+func (s *directMessageState) SetOptIn(user string, ok bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ok {
+		s.OptIn[user] = true
+	} else {
+		delete(s.OptIn, user)
+	}
+	return s.save()
+}
+
+func (s *directMessageState) IsOptedIn(user string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.OptIn[user]
+}
+
+func (s *directMessageState) SetBlock(user, target string, block bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var blocks = []string{}
+	for _, blocked := range s.Blocks[user] {
+		if blocked != target {
+			blocks = append(blocks, blocked)
+		}
+	}
+	if block {
+		blocks = append(blocks, target)
+	}
+
+	if len(blocks) == 0 {
+		delete(s.Blocks, user)
+	} else {
+		s.Blocks[user] = blocks
+	}
+	return s.save()
+}
+
+func (s *directMessageState) IsBlocked(user, target string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, blocked := range s.Blocks[user] {
+		if blocked == target {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *directMessageState) Thread(slackUser, discordUser string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Threads[slackUser+"|"+discordUser]
+}
+
+func (s *directMessageState) SetThread(slackUser, discordUser, threadTS string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Threads[slackUser+"|"+discordUser] = threadTS
+	return s.save()
+}
+
+// ThreadCounterpart returns the Discord user of the Slack thread
+func (s *directMessageState) ThreadCounterpart(slackUser, threadTS string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, ts := range s.Threads {
+		if ts != threadTS {
+			continue
+		}
+		var pair = strings.SplitN(key, "|", 2)
+		if len(pair) == 2 && pair[0] == slackUser {
+			return pair[1]
+		}
+	}
+	return ""
+}
+
+func (s *directMessageState) LastCounterpart(discordUser string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Last[discordUser]
+}
+
+func (s *directMessageState) SetLastCounterpart(discordUser, slackUser string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Last[discordUser] == slackUser {
+		return nil
+	}
+	s.Last[discordUser] = slackUser
+	return s.save()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseDirectMessage(t *testing.T) {
+	name, content, ok := parseDirectMessage("to:@alice hello world")
+	if !ok || name != "alice" || content != "hello world" {
+		t.Fatalf("Expected alice and hello world, but got %q %q %v", name, content, ok)
+	}
+
+	if _, _, ok := parseDirectMessage("hello"); ok {
+		t.Fatal("Expected a message without the prefix not to be parsed")
+	}
+	if _, _, ok := parseDirectMessage("to: hello"); ok {
+		t.Fatal("Expected a message without the recipient not to be parsed")
+	}
+}
+
+func TestReactionConversion(t *testing.T) {
+	if unicode := slackReactionToUnicode("+1::skin-tone-2"); unicode != "👍" {
+		t.Fatalf("Expected 👍, but got %q", unicode)
+	}
+	if name := unicodeToSlackReaction("👍"); slackReactionToUnicode(name) != "👍" {
+		t.Fatalf("Expected a name of 👍, but got %q", name)
+	}
+	if unicode := slackReactionToUnicode("custom-emoji"); unicode != "" {
+		t.Fatalf("Expected no unicode for custom emoji, but got %q", unicode)
+	}
+}
+
+func TestDirectMessageState(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "direct_messages.json")
+
+	state, err := loadDirectMessageState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.SetOptIn("slack:U1", true)
+	state.SetBlock("slack:U1", "discord:D1", true)
+	state.SetThread("U1", "D2", "1234.5678")
+
+	state, err = loadDirectMessageState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.IsOptedIn("slack:U1") || !state.IsBlocked("slack:U1", "discord:D1") {
+		t.Fatal("Expected the opt-in and the block to be persisted")
+	}
+	if user := state.ThreadCounterpart("U1", "1234.5678"); user != "D2" {
+		t.Fatalf("Expected D2, but got %q", user)
+	}
+
+	state.SetBlock("slack:U1", "discord:D1", false)
+	if state.IsBlocked("slack:U1", "discord:D1") {
+		t.Fatal("Expected the block to be removed")
+	}
+}
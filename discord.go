@@ -1,17 +1,23 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"regexp"
 	"strings"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/archive"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/delivery_queue"
 	dp "github.com/kmc-jp/DiscordSlackSynchronizer/discord_plugin"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/discord_webhook"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/event_cache"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/metrics"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/slack_webhook"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/supervisor"
 	"github.com/pkg/errors"
 )
 
@@ -36,6 +42,14 @@ type DiscordHandler struct {
 	slackHook         *slack_webhook.Handler
 
 	reactionHandler *DiscordReactionHandler
+	channelSync     *ChannelSyncHandler
+	membershipSync  *MembershipSyncHandler
+	directMessage   *DirectMessageHandler
+	events          *event_cache.Scope
+	catchUp         *CatchUpHandler
+	archive         *archive.Archive
+	supervisor      *supervisor.Supervisor
+	logger          *logging.Logger
 
 	settings *settings.Handler
 	options  struct {
@@ -45,15 +59,21 @@ type DiscordHandler struct {
 
 func NewDiscordBot(apiToken string, settings *settings.Handler) *DiscordHandler {
 	// Create a new Discord session using the provided bot token.
-	dg, err := discordgo.New("Bot " + Tokens.Discord.API)
+	dg, err := discordgo.New("Bot " + apiToken)
 	if err != nil {
-		fmt.Println("Error creating Discord session: ", err)
+		logging.New("discord").Error("NewDiscordSession", "error", err)
 		return nil
 	}
 
+	return NewDiscordBotWithSession(dg, settings)
+}
+
+// NewDiscordBotWithSession adds handlers to the session shared with other Slack workspaces
+func NewDiscordBotWithSession(dg *discordgo.Session, settings *settings.Handler) *DiscordHandler {
 	var d DiscordHandler
 
 	d.Session = dg
+	d.logger = logging.New("discord")
 	d.regExp.UserID = regexp.MustCompile(`<@!(\d+)>`)
 	d.regExp.Channel = regexp.MustCompile(`<#(\d+)>`)
 	d.regExp.ImageURI = regexp.MustCompile(`\S\.png|\.jpg|\.jpeg|\.gif`)
@@ -62,9 +82,16 @@ func NewDiscordBot(apiToken string, settings *settings.Handler) *DiscordHandler
 
 	dg.AddHandler(d.voiceState)
 	dg.AddHandler(d.getMessage)
+	dg.AddHandler(d.messageUpdate)
+	dg.AddHandler(d.messageDelete)
+	dg.AddHandler(d.ready)
 	dg.AddHandler(d.ReactionAdd)
 	dg.AddHandler(d.ReactionRemove)
 	dg.AddHandler(d.ReactionRemoveAll)
+	dg.AddHandler(d.guildCreate)
+	dg.AddHandler(d.channelCreate)
+	dg.AddHandler(d.channelUpdate)
+	dg.AddHandler(d.channelDelete)
 
 	d.slackLastMessages = SlackLastMessages{}
 	d.settings = settings
@@ -84,6 +111,37 @@ func (d *DiscordHandler) SetDiscordReactionHandler(handler *DiscordReactionHandl
 	d.reactionHandler = handler
 }
 
+func (d *DiscordHandler) SetChannelSyncHandler(handler *ChannelSyncHandler) {
+	d.channelSync = handler
+}
+
+func (d *DiscordHandler) SetMembershipSyncHandler(handler *MembershipSyncHandler) {
+	d.membershipSync = handler
+}
+
+func (d *DiscordHandler) SetDirectMessageHandler(handler *DirectMessageHandler) {
+	d.directMessage = handler
+}
+
+func (d *DiscordHandler) SetCatchUpHandler(handler *CatchUpHandler) {
+	d.catchUp = handler
+}
+
+// SetEventCache sets the cache of handled events, so that events replayed on resume are ignored
+func (d *DiscordHandler) SetEventCache(events *event_cache.Scope) {
+	d.events = events
+}
+
+// SetArchive makes the bridged messages, edits, deletions and reactions recorded
+func (d *DiscordHandler) SetArchive(archive *archive.Archive) {
+	d.archive = archive
+}
+
+// SetSupervisor makes the bridged messages reported
+func (d *DiscordHandler) SetSupervisor(supervisor *supervisor.Supervisor) {
+	d.supervisor = supervisor
+}
+
 func (d *DiscordHandler) EnableModify(state bool) {
 	d.options.enableModify = state
 }
@@ -96,12 +154,52 @@ func (d *DiscordHandler) Do() error {
 	return d.Session.Open()
 }
 
+// ready is called on every new session, and resumed sessions replay events by themselves
+func (d *DiscordHandler) ready(_ *discordgo.Session, _ *discordgo.Ready) {
+	d.catchUp.CatchUpDiscord()
+}
+
+// replayMessage handles the message fetched on catching up like a new message
+func (d *DiscordHandler) replayMessage(m *discordgo.MessageCreate) {
+	// messages fetched by REST have no member, which is looked up for the nickname
+	if m.Member == nil && m.GuildID != "" && m.Author != nil {
+		member, err := d.Session.State.Member(m.GuildID, m.Author.ID)
+		if err != nil {
+			member, err = d.Session.GuildMember(m.GuildID, m.Author.ID)
+		}
+		if err == nil {
+			m.Member = member
+		}
+	}
+	d.getMessage(d.Session, m)
+}
+
+// authorName returns the nickname of the author, or the user name if the message has no member, such as replayed messages
+func authorName(m *discordgo.Message) string {
+	if m.Member != nil && m.Member.Nick != "" {
+		return m.Member.Nick
+	}
+	return m.Author.Username
+}
+
 func (d *DiscordHandler) getMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
 	// Ignore all messages created by the bot itself
 	if m.Author.ID == s.State.User.ID || m.Author.Bot {
 		return
 	}
 
+	if d.events.Seen("discord:message:" + m.ID) {
+		return
+	}
+
+	// direct messages to the bot
+	if m.GuildID == "" {
+		if d.directMessage != nil {
+			d.directMessage.DiscordMessage(m)
+		}
+		return
+	}
+
 	var sdt = d.settings.FindSlackChannel(m.ChannelID, m.GuildID)
 	if sdt.SlackChannel == "" {
 		return
@@ -112,13 +210,17 @@ func (d *DiscordHandler) getMessage(s *discordgo.Session, m *discordgo.MessageCr
 		return
 	}
 
+	var correlationID = logging.NewCorrelationID()
+	var log = d.logger.With(logging.CorrelationKey, correlationID, "guild", m.GuildID, "channel", m.ChannelID, "message", m.ID)
+	log.Debug("ReceivedMessage")
+
 	var reference *discordgo.Message
 	var err error
 
 	if m.Message != nil && m.Message.MessageReference != nil {
 		reference, err = s.ChannelMessage(m.Message.MessageReference.ChannelID, m.Message.MessageReference.MessageID)
 		if err != nil {
-			log.Println(err)
+			log.Error("GetReferencedMessage", "error", err)
 			return
 		}
 
@@ -155,7 +257,7 @@ func (d *DiscordHandler) getMessage(s *discordgo.Session, m *discordgo.MessageCr
 
 			err = d.deleteMessage(m.ChannelID, m.ID)
 			if err != nil {
-				log.Println(err)
+				log.Warn("DeleteMessage", "error", err)
 			}
 
 			var message = discord_webhook.FromDiscordgoMessage(reference)
@@ -206,9 +308,10 @@ func (d *DiscordHandler) getMessage(s *discordgo.Session, m *discordgo.MessageCr
 			}
 
 			message.Content = newContent
+			message.CorrelationID = correlationID
 			_, err = d.hook.Edit(message.ChannelID, message.ID, message, []discord_webhook.File{})
 			if err != nil {
-				log.Printf("EditError: %s\n", err)
+				log.Error("EditMessage", "error", err)
 				return
 			}
 
@@ -220,17 +323,14 @@ func (d *DiscordHandler) getMessage(s *discordgo.Session, m *discordgo.MessageCr
 		}
 	}
 
-	var name string = m.Member.Nick
-	if name == "" {
-		name = m.Author.Username
-	}
+	var name = authorName(m.Message)
 
 	var username string
 	if d.options.enableModify {
 		// if modify function is enabled, add the author primary id to the message author name
 		primaryID, err := dp.GetPrimaryID(m.Author.ID)
 		if err != nil {
-			log.Println(err)
+			log.Warn("GetPrimaryID", "error", err)
 			primaryID = m.Author.ID
 		}
 		if primaryID != "" {
@@ -264,7 +364,7 @@ func (d *DiscordHandler) getMessage(s *discordgo.Session, m *discordgo.MessageCr
 
 				resp, err := http.Get(m.Message.Attachments[i].URL)
 				if err != nil {
-					log.Printf("DownloadErr: %s\n", err.Error())
+					log.Warn("DownloadAttachment", "url", m.Message.Attachments[i].URL, "error", err)
 					continue
 				}
 				defer resp.Body.Close()
@@ -301,15 +401,19 @@ func (d *DiscordHandler) getMessage(s *discordgo.Session, m *discordgo.MessageCr
 	// Delete message on Discord
 	err = d.deleteMessage(m.ChannelID, m.ID)
 	if err != nil {
-		log.Println(err)
+		log.Warn("DeleteMessage", "error", err)
 	} else {
 		// if it successed, send message by webhook
+		dMessage.Event, _ = json.Marshal(m)
+		dMessage.CorrelationID = correlationID
 		message, err := d.hook.Send(m.ChannelID, dMessage, false, dFiles)
-		if err != nil {
-			log.Printf("MessageSendError: %s", err)
+		if err == delivery_queue.ErrQueued {
+			log.Warn("SendMessage", "error", err)
+		} else if err != nil {
+			log.Error("SendMessage", "error", err)
+		} else {
+			dMessage = *message
 		}
-
-		dMessage = *message
 	}
 
 	var imageURIs = []string{}
@@ -336,7 +440,7 @@ func (d *DiscordHandler) getMessage(s *discordgo.Session, m *discordgo.MessageCr
 					},
 				)
 				if err != nil {
-					log.Printf("SlackFileRemoteAddAPIError: %s", err.Error())
+					log.Error("FilesRemoteAdd", "error", err)
 					continue
 				}
 
@@ -388,7 +492,7 @@ func (d *DiscordHandler) getMessage(s *discordgo.Session, m *discordgo.MessageCr
 	if sdt.Setting.ShowChannelName {
 		channelData, err := s.State.GuildChannel(m.GuildID, m.ChannelID)
 		if err != nil {
-			fmt.Printf("%s\n", err.Error())
+			log.Error("GetGuildChannel", "error", err)
 			return
 		}
 		content = "`#" + channelData.Name + "` " + content
@@ -428,13 +532,23 @@ func (d *DiscordHandler) getMessage(s *discordgo.Session, m *discordgo.MessageCr
 		UnfurlMedia: true,
 		LinkNames:   true,
 	}
+	message.Event, _ = json.Marshal(m)
+	message.CorrelationID = correlationID
 
 	// Send message to Slack
-	_, err = d.slackHook.Send(message)
-	if err != nil {
-		log.Printf("ErrorInSendingMessageToSlack: %s\n", err.Error())
+	// a queued message is delivered later, whose ts is not known yet
+	ts, err := d.slackHook.Send(message)
+	if err == delivery_queue.ErrQueued {
+		log.Warn("SendMessageToSlack", "error", err)
+	} else if err != nil {
+		log.Error("SendMessageToSlack", "error", err)
 		return
 	}
+	d.catchUp.MarkDiscord(m.GuildID, m.ChannelID, m.ID)
+	metrics.MessagesBridged.Inc("discord_to_slack", m.ChannelID)
+	d.supervisor.MarkBridged("discord_to_slack")
+	d.archiveMessage(m.Message, dMessage, name, sdt.SlackChannel, ts)
+	log.Debug("BridgedMessage", "ts", ts)
 
 }
 
@@ -499,7 +613,7 @@ func (d *DiscordHandler) voiceState(s *discordgo.Session, vs *discordgo.VoiceSta
 		setting := d.settings.FindSlackChannel(vs.VoiceState.ChannelID, vs.VoiceState.GuildID)
 		mem, err := s.GuildMember(vs.GuildID, vs.UserID)
 		if err != nil {
-			fmt.Printf("Failed to get info of a member: %v\n", err)
+			d.logger.Error("GetGuildMember", "user", vs.UserID, "error", err)
 			return
 		}
 		exists := channels.Join(channel, mem)
@@ -519,22 +633,88 @@ func (d *DiscordHandler) voiceState(s *discordgo.Session, vs *discordgo.VoiceSta
 }
 
 func (d *DiscordHandler) ReactionAdd(_ *discordgo.Session, ev *discordgo.MessageReactionAdd) {
-	err := d.reactionHandler.GetReaction(ev.GuildID, ev.ChannelID, ev.MessageID)
-	if err != nil {
-		log.Println(err)
+	if d.seenReaction(true, ev.MessageReaction) {
+		return
+	}
+	if ev.GuildID == "" {
+		if d.directMessage != nil {
+			d.directMessage.DiscordReaction(ev.ChannelID, ev.MessageID, ev.UserID, ev.Emoji, true)
+		}
+		return
 	}
+	d.archiveReaction(ev.GuildID, ev.ChannelID, ev.MessageID, ev.UserID, ev.Emoji, true)
+	d.reactionHandle(ev.GuildID, ev.ChannelID, ev.MessageID)
 }
 func (d *DiscordHandler) ReactionRemove(_ *discordgo.Session, ev *discordgo.MessageReactionRemove) {
-	err := d.reactionHandler.GetReaction(ev.GuildID, ev.ChannelID, ev.MessageID)
-	if err != nil {
-		log.Println(err)
+	if d.seenReaction(false, ev.MessageReaction) {
+		return
 	}
+	if ev.GuildID == "" {
+		if d.directMessage != nil {
+			d.directMessage.DiscordReaction(ev.ChannelID, ev.MessageID, ev.UserID, ev.Emoji, false)
+		}
+		return
+	}
+	d.archiveReaction(ev.GuildID, ev.ChannelID, ev.MessageID, ev.UserID, ev.Emoji, false)
+	d.reactionHandle(ev.GuildID, ev.ChannelID, ev.MessageID)
 }
 func (d *DiscordHandler) ReactionRemoveAll(_ *discordgo.Session, ev *discordgo.MessageReactionRemoveAll) {
-	err := d.reactionHandler.GetReaction(ev.GuildID, ev.ChannelID, ev.MessageID)
+	d.reactionHandle(ev.GuildID, ev.ChannelID, ev.MessageID)
+}
+
+// seenReaction reports whether the reaction event has been handled, such as when it is replayed on resume.
+// Reaction events have no ID, so the opposite event is forgotten to let the same reaction be added again.
+func (d *DiscordHandler) seenReaction(added bool, r *discordgo.MessageReaction) bool {
+	var key = "discord:reaction:" + r.ChannelID + ":" + r.MessageID + ":" + r.UserID + ":" + r.Emoji.APIName()
+	if added {
+		d.events.Forget(key + ":remove")
+		return d.events.Seen(key + ":add")
+	}
+	d.events.Forget(key + ":add")
+	return d.events.Seen(key + ":remove")
+}
+
+// reactionHandle updates the reactions of the message bridged to Slack
+func (d *DiscordHandler) reactionHandle(guildID, channelID, messageID string) {
+	var correlationID = logging.NewCorrelationID()
+	err := d.reactionHandler.GetReaction(correlationID, guildID, channelID, messageID)
 	if err != nil {
-		log.Println(err)
+		d.logger.Error("GetReaction", logging.CorrelationKey, correlationID, "channel", channelID, "message", messageID, "error", err)
+	}
+}
+
+// guildCreate loads all channels of the guild when the session connects or joins a guild
+func (d *DiscordHandler) guildCreate(_ *discordgo.Session, ev *discordgo.GuildCreate) {
+	d.settings.SetDiscordGuildChannels(ev.ID, ev.Channels)
+}
+
+func (d *DiscordHandler) channelCreate(_ *discordgo.Session, ev *discordgo.ChannelCreate) {
+	d.settings.UpdateDiscordChannel(ev.Channel)
+	if d.membershipSync != nil {
+		d.membershipSync.SyncDiscordChannel(ev.GuildID, ev.ID)
+	}
+}
+
+// channelUpdate follows renamed channels and channels moved between categories
+func (d *DiscordHandler) channelUpdate(_ *discordgo.Session, ev *discordgo.ChannelUpdate) {
+	if d.channelSync != nil {
+		d.channelSync.DiscordChannelUpdated(ev.Channel)
+	} else {
+		d.settings.UpdateDiscordChannel(ev.Channel)
+	}
+
+	// permission overwrites may be changed
+	if d.membershipSync != nil {
+		d.membershipSync.SyncDiscordChannel(ev.GuildID, ev.ID)
+	}
+}
+
+func (d *DiscordHandler) channelDelete(_ *discordgo.Session, ev *discordgo.ChannelDelete) {
+	if d.channelSync != nil {
+		d.channelSync.DiscordChannelDeleted(ev.Channel)
+		return
 	}
+	d.settings.RemoveDiscordChannel(ev.GuildID, ev.ID)
 }
 
 func (d *DiscordHandler) sendVoiceState(setting settings.ChannelSetting, channels *VoiceChannels, event VoiceEvent) {
@@ -551,17 +731,17 @@ func (d *DiscordHandler) sendVoiceState(setting settings.ChannelSetting, channel
 	if setting.DiscordChannel == "all" {
 		blocks, err = channels.SlackBlocksMultiChannel()
 		if err != nil {
-			fmt.Printf("%v\n", errors.Wrapf(err, "Failed SlackBlocks"))
+			d.logger.Error("SlackBlocksMultiChannel", "error", err)
 			return
 		}
 	} else {
 		channel, ok := channels.Channels[setting.DiscordChannel]
 		if !ok {
-			fmt.Print("Failed to find channel")
+			d.logger.Warn("VoiceChannelNotFound", "channel", setting.DiscordChannel)
 		}
 		blocks = channel.SlackBlocksSingleChannel()
 		if err != nil {
-			fmt.Printf("%v\n", errors.Wrapf(err, "Failed SlackBlock"))
+			d.logger.Error("SlackBlocksSingleChannel", "error", err)
 		}
 	}
 
@@ -597,7 +777,7 @@ func (d *DiscordHandler) sendVoiceState(setting settings.ChannelSetting, channel
 
 		ts, err = d.slackHook.Send(message)
 		if err != nil {
-			log.Println(err)
+			d.logger.Error("SendVoiceState", "error", err)
 			return
 		}
 
@@ -625,7 +805,7 @@ func (d *DiscordHandler) sendVoiceState(setting settings.ChannelSetting, channel
 
 			ts, err = d.slackHook.Send(message)
 			if err != nil {
-				log.Println(err)
+				d.logger.Error("SendVoiceState", "error", err)
 				return
 			}
 			d.slackLastMessages[message.Channel] = ts
@@ -633,7 +813,7 @@ func (d *DiscordHandler) sendVoiceState(setting settings.ChannelSetting, channel
 		message.TS = ts
 		ts, err = d.slackHook.Update(message)
 		if err != nil {
-			log.Println(err)
+			d.logger.Error("SendVoiceState", "error", err)
 			return
 		}
 
@@ -655,7 +835,7 @@ func (d *DiscordHandler) sendVoiceState(setting settings.ChannelSetting, channel
 					}
 					ts, err = d.slackHook.Send(message)
 					if err != nil {
-						log.Println(err)
+						d.logger.Error("SendVoiceState", "error", err)
 					}
 				}()
 			}
@@ -664,7 +844,7 @@ func (d *DiscordHandler) sendVoiceState(setting settings.ChannelSetting, channel
 		message.TS = ts
 		ts, err = d.slackHook.Update(message)
 		if err != nil {
-			log.Println(err)
+			d.logger.Error("SendVoiceState", "error", err)
 			return
 		}
 
@@ -684,7 +864,7 @@ func (d *DiscordHandler) sendVoiceState(setting settings.ChannelSetting, channel
 					}
 				}
 			}
-			fmt.Println(ts)
+			d.logger.Debug("VoiceStateMessage", "ts", ts)
 
 			if ts == "" {
 				return
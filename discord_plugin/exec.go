@@ -6,6 +6,10 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"time"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/alert"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/metrics"
 )
 
 type externalPlugin struct {
@@ -27,6 +31,12 @@ func (e *externalPlugin) GetDiscordID(primaryID string) ([]string, error) {
 func (e *externalPlugin) exec(input string) (output string, err error) {
 	var cmd = exec.Command(e.path)
 
+	// the latency is observed for each command, which is the first line of the input
+	var command = strings.SplitN(input, "\n", 2)[0]
+	defer func(start time.Time) {
+		metrics.PluginExec.Observe(time.Since(start).Seconds(), command)
+	}(time.Now())
+
 	var stdin = new(bytes.Buffer)
 	var stdout = new(bytes.Buffer)
 	var stderr = new(bytes.Buffer)
@@ -49,6 +59,7 @@ func (e *externalPlugin) exec(input string) (output string, err error) {
 
 	err = cmd.Run()
 	if err != nil {
+		alert.Notify(alert.KindPlugin, "%sの実行に失敗しました (%s): %s", e.path, command, err)
 		return
 	}
 
@@ -6,24 +6,32 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
 	"net/url"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/alert"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/delivery_queue"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/metrics"
 	"github.com/pkg/errors"
 )
 
 const DiscordAPIEndpoint = "https://discord.com/api"
 
+var logger = logging.New("discord_webhook")
+
 type Handler struct {
 	webhookByChannelID map[string]*discordgo.Webhook
 	createWebhookLock  map[string]*sync.RWMutex
 	token              string
+
+	queue *delivery_queue.Queue
 }
 
 type File struct {
@@ -83,6 +91,11 @@ type Message struct {
 	Application      *discordgo.MessageApplication `json:"application"`
 	MessageReference *discordgo.MessageReference   `json:"message_reference"`
 	Flags            discordgo.MessageFlags        `json:"flags"`
+
+	// Event is the original event of the message, which is kept as a dead letter if the delivery fails
+	Event json.RawMessage `json:"-"`
+	// CorrelationID is the ID of the inbound event which causes the message, written in the logs of the delivery
+	CorrelationID string `json:"-"`
 }
 
 type Component struct {
@@ -139,13 +152,15 @@ func (h *Handler) createWebhook(channelID string) *discordgo.Webhook {
 	defer h.createWebhookLock[channelID].Unlock()
 	webhooks, err := h.getChannelWebhook(channelID)
 	if err != nil {
-		fmt.Printf("Error getting webhook: %v\n", err)
+		logger.Error("GetChannelWebhook", "channel", channelID, "error", err)
+		alert.Notify(alert.KindWebhook, "Discordのチャンネル%sのWebhookを取得できません: %s", channelID, err)
 		return nil
 	}
 	if len(webhooks) == 0 {
 		webhook, err := h.createChannelWebhook(channelID, "Slack Synchronizer")
 		if err != nil {
-			fmt.Printf("Error: CreateChannelWebhook: %v\n", err)
+			logger.Error("CreateChannelWebhook", "channel", channelID, "error", err)
+			alert.Notify(alert.KindWebhook, "Discordのチャンネル%sにWebhookを作成できません: %s", channelID, err)
 			return nil
 		}
 		return webhook
@@ -242,8 +257,119 @@ func (h *Handler) createChannelWebhook(channelID, name string) (*discordgo.Webho
 	return &webhook, nil
 }
 
-func (h *Handler) send(method, channelID, messageID string, message Message, wait bool, files []File) (newMessage *Message, err error) {
+func (h *Handler) send(method, channelID, threadID, messageID string, message Message, wait bool, files []File) (newMessage *Message, err error) {
+	if h.queue == nil {
+		newMessage, _, err = h.deliver(method, channelID, threadID, messageID, message, wait, files)
+		return
+	}
+
+	var job = queuedMessage{
+		Method:    method,
+		ChannelID: channelID,
+		ThreadID:  threadID,
+		MessageID: messageID,
+		Message:   message,
+		// the message ID is returned only if waited
+		Wait:          true,
+		CorrelationID: message.CorrelationID,
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadAll(file.Reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "ReadFile")
+		}
+		job.Files = append(job.Files, queuedFile{FileName: file.FileName, ContentType: file.ContentType, Data: data})
+	}
+
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+
+	result, err := h.queue.Push(channelID, payload, message.Event, delivery_queue.LiveWait)
+	if err != nil {
+		return nil, err
+	}
+
+	newMessage = &Message{}
+	err = json.Unmarshal(result, newMessage)
+	return newMessage, errors.Wrap(err, "Unmarshal")
+}
+
+// queuedMessage is the payload of the delivery queue
+type queuedMessage struct {
+	Method    string       `json:"method"`
+	ChannelID string       `json:"channel_id"`
+	ThreadID  string       `json:"thread_id,omitempty"`
+	MessageID string       `json:"message_id,omitempty"`
+	Message   Message      `json:"message"`
+	Wait      bool         `json:"wait"`
+	Files     []queuedFile `json:"files,omitempty"`
+
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+type queuedFile struct {
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+}
+
+// EnableQueue makes messages delivered in order for each channel through the queue stored in the directory,
+// which are retried when Discord is not available or rate limited
+func (h *Handler) EnableQueue(dir string) error {
+	queue, err := delivery_queue.Open(dir, func(_ string, payload []byte) ([]byte, time.Duration, error) {
+		var job queuedMessage
+		var err = json.Unmarshal(payload, &job)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "Unmarshal")
+		}
+
+		job.Message.CorrelationID = job.CorrelationID
+
+		var files = []File{}
+		for _, file := range job.Files {
+			files = append(files, File{FileName: file.FileName, ContentType: file.ContentType, Reader: bytes.NewReader(file.Data)})
+		}
+
+		message, next, err := h.deliver(job.Method, job.ChannelID, job.ThreadID, job.MessageID, job.Message, job.Wait, files)
+		if err != nil {
+			return nil, next, err
+		}
+
+		result, err := json.Marshal(message)
+		return result, next, errors.Wrap(err, "Marshal")
+	})
+	if err != nil {
+		return errors.Wrap(err, "OpenQueue")
+	}
+
+	h.queue = queue
+	return nil
+}
+
+// Queue returns the delivery queue, which is nil unless enabled
+func (h *Handler) Queue() *delivery_queue.Queue {
+	return h.queue
+}
+
+// deliver sends the message, and returns the wait until the rate limit of the webhook is reset
+func (h *Handler) deliver(method, channelID, threadID, messageID string, message Message, wait bool, files []File) (newMessage *Message, next time.Duration, err error) {
+	defer func() {
+		var log = logger.With(logging.CorrelationKey, message.CorrelationID, "method", method, "channel", channelID)
+		if err != nil {
+			log.Warn("DeliveryFailed", "error", err)
+		} else if newMessage != nil {
+			log.Debug("Delivered", "message", newMessage.ID)
+		}
+	}()
+
+	// Get has tried to fetch or create the webhook and alerted the failure, which is usually a missing permission,
+	// so the message is given up instead of blocking the channel with retries
 	var hook = h.Get(channelID)
+	if hook == nil {
+		return nil, 0, errors.New("NoWebhook")
+	}
 	if files == nil {
 		files = []File{}
 	}
@@ -258,7 +384,7 @@ func (h *Handler) send(method, channelID, messageID string, message Message, wai
 
 	pw, err := mw.CreatePart(mh)
 	if err != nil {
-		return nil, errors.Wrap(err, "CreatingPart")
+		return nil, 0, errors.Wrap(err, "CreatingPart")
 	}
 
 	b, err := json.MarshalIndent(message, "", "    ")
@@ -273,7 +399,7 @@ func (h *Handler) send(method, channelID, messageID string, message Message, wai
 
 		pw, err := mw.CreatePart(mh)
 		if err != nil {
-			return nil, errors.Wrap(err, "CreatingPartAtFor")
+			return nil, 0, errors.Wrap(err, "CreatingPartAtFor")
 		}
 
 		io.Copy(pw, file.Reader)
@@ -282,8 +408,10 @@ func (h *Handler) send(method, channelID, messageID string, message Message, wai
 	mw.Close()
 
 	var req *http.Request
+	var endpoint string
 	switch method {
 	case "EDIT":
+		endpoint = "discord/webhook_edit"
 		req, err = http.NewRequest(
 			"PATCH",
 			fmt.Sprintf("%s/webhooks/%s/%s/messages/%s",
@@ -292,11 +420,19 @@ func (h *Handler) send(method, channelID, messageID string, message Message, wai
 			body,
 		)
 	case "SEND":
+		endpoint = "discord/webhook_execute"
 		var reqURI = fmt.Sprintf("%s/webhooks/%s/%s",
 			DiscordAPIEndpoint, hook.ID, hook.Token,
 		)
+		var query = url.Values{}
 		if wait {
-			reqURI += "?wait=true"
+			query.Set("wait", "true")
+		}
+		if threadID != "" {
+			query.Set("thread_id", threadID)
+		}
+		if len(query) > 0 {
+			reqURI += "?" + query.Encode()
 		}
 		req, err = http.NewRequest(
 			"POST",
@@ -313,10 +449,16 @@ func (h *Handler) send(method, channelID, messageID string, message Message, wai
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, errors.Wrap(err, "Sending")
+		metrics.APIErrors.Inc(endpoint, "network")
+		return nil, 0, delivery_queue.Retry(errors.Wrap(err, "Sending"), 0)
 	}
 	defer resp.Body.Close()
 
+	next = rateLimitResetAfter(resp.Header)
+	if next > 0 {
+		metrics.RateLimitWaits.Observe(next.Seconds(), "discord")
+	}
+
 	var responseAttr Message
 
 	buf, err := ioutil.ReadAll(resp.Body)
@@ -325,13 +467,46 @@ func (h *Handler) send(method, channelID, messageID string, message Message, wai
 		return
 	}
 
+	if resp.StatusCode >= 400 {
+		metrics.APIErrors.Inc(endpoint, strconv.Itoa(resp.StatusCode))
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		metrics.RateLimitWaits.Observe(retryAfter(resp.Header).Seconds(), "discord")
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return nil, next, delivery_queue.Retry(errors.Errorf("API: %s", buf), retryAfter(resp.Header))
+	}
+	if resp.StatusCode >= 400 {
+		return nil, next, errors.Errorf("API: %s", buf)
+	}
+
 	if len(buf) < 1 {
-		return &responseAttr, nil
+		return &responseAttr, next, nil
 	}
 
 	err = json.Unmarshal(buf, &responseAttr)
 
-	return &responseAttr, errors.Wrapf(err, "JsonParsing: %s", buf)
+	return &responseAttr, next, errors.Wrapf(err, "JsonParsing: %s", buf)
+}
+
+// rateLimitResetAfter returns the wait until the bucket of the webhook is reset, if no requests remain
+func rateLimitResetAfter(header http.Header) time.Duration {
+	if header.Get("X-RateLimit-Remaining") != "0" {
+		return 0
+	}
+	return parseSeconds(header.Get("X-RateLimit-Reset-After"))
+}
+
+func retryAfter(header http.Header) time.Duration {
+	return parseSeconds(header.Get("Retry-After"))
+}
+
+func parseSeconds(value string) time.Duration {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
 }
 
 func (h *Handler) Get(channelID string) *discordgo.Webhook {
@@ -353,11 +528,16 @@ func (h *Handler) Get(channelID string) *discordgo.Webhook {
 }
 
 func (h *Handler) Edit(channelID, messageID string, message Message, files []File) (*Message, error) {
-	return h.send("EDIT", channelID, messageID, message, false, files)
+	return h.send("EDIT", channelID, "", messageID, message, false, files)
 }
 
 func (h *Handler) Send(channelID string, message Message, wait bool, files []File) (*Message, error) {
-	return h.send("SEND", channelID, "", message, wait, files)
+	return h.send("SEND", channelID, "", "", message, wait, files)
+}
+
+// SendInThread sends the message to the thread of the channel, which is delivered in order with the messages of the channel
+func (h *Handler) SendInThread(channelID, threadID string, message Message, wait bool, files []File) (*Message, error) {
+	return h.send("SEND", channelID, threadID, "", message, wait, files)
 }
 
 func (h *Handler) GetGuildChannels(guildID string) (channels []discordgo.Channel, err error) {
@@ -418,7 +598,7 @@ func (h *Handler) GetMessage(channelID, messageID string) (message discordgo.Mes
 		defer func() {
 			err := recover()
 			if err != nil {
-				log.Println(err)
+				logger.Error("PanicInDecodingJSON", "error", err)
 			}
 		}()
 		return json.NewDecoder(resp.Body).Decode(&responseAttr)
@@ -0,0 +1,261 @@
+package event_cache
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultTTL  = 24 * time.Hour
+	DefaultSize = 10000
+)
+
+var logger = logging.New("event_cache")
+
+// Cache remembers the IDs of handled events for a while, so that redelivered events are handled only once.
+// Keys are appended to a file as they are added, which is compacted when it grows, and loaded again on restart.
+type Cache struct {
+	path string
+	ttl  time.Duration
+	size int
+
+	expiries map[string]time.Time
+	// order holds the keys in the order they were added, which is also the order of expiry
+	order []string
+
+	file  *os.File
+	lines int
+
+	mu sync.Mutex
+}
+
+// Open loads the cache file, which is created if it does not exist
+func Open(path string, ttl time.Duration, size int) (*Cache, error) {
+	var c = &Cache{
+		path:     path,
+		ttl:      ttl,
+		size:     size,
+		expiries: map[string]time.Time{},
+	}
+
+	var err = c.load()
+	if err != nil {
+		return nil, errors.Wrap(err, "Load")
+	}
+
+	err = c.compact()
+	if err != nil {
+		return nil, errors.Wrap(err, "Compact")
+	}
+	return c, nil
+}
+
+// NewMemory returns the cache which is not kept in a file, such as when the file can not be opened
+func NewMemory(ttl time.Duration, size int) *Cache {
+	return &Cache{
+		ttl:      ttl,
+		size:     size,
+		expiries: map[string]time.Time{},
+	}
+}
+
+// Seen records the key and reports whether it had been recorded already
+func (c *Cache) Seen(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var now = time.Now()
+	c.evict(now)
+
+	if _, ok := c.expiries[key]; ok {
+		return true
+	}
+
+	var expiry = now.Add(c.ttl)
+	c.expiries[key] = expiry
+	c.order = append(c.order, key)
+
+	var err = c.append(key, expiry)
+	if err != nil {
+		logger.Error("AppendEventCache", "error", err)
+	}
+	return false
+}
+
+// Forget removes the key, so that it is new again, such as a reaction added again after it is removed
+func (c *Cache) Forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.expiries[key]; !ok {
+		return
+	}
+	delete(c.expiries, key)
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+
+	// the zero expiry removes the key on loading
+	var err = c.append(key, time.Unix(0, 0))
+	if err != nil {
+		logger.Error("AppendEventCache", "error", err)
+	}
+}
+
+// Scope returns the view of the cache whose keys are prefixed, such as by the Slack team ID
+func (c *Cache) Scope(prefix string) *Scope {
+	return &Scope{cache: c, prefix: prefix}
+}
+
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file == nil {
+		return nil
+	}
+	var err = c.file.Close()
+	c.file = nil
+	return err
+}
+
+// evict must be called with mu held
+func (c *Cache) evict(now time.Time) {
+	var i int
+	for ; i < len(c.order); i++ {
+		var key = c.order[i]
+		if len(c.order)-i <= c.size && c.expiries[key].After(now) {
+			break
+		}
+		delete(c.expiries, key)
+	}
+	c.order = c.order[i:]
+}
+
+func (c *Cache) load() error {
+	f, err := os.Open(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	type line struct {
+		key    string
+		expiry int64
+	}
+	var lines []line
+	// the last line of a key is used, as a key forgotten and recorded again is written twice
+	var last = map[string]int{}
+
+	var scanner = bufio.NewScanner(f)
+	for scanner.Scan() {
+		var fields = strings.SplitN(scanner.Text(), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		nsec, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		last[fields[1]] = len(lines)
+		lines = append(lines, line{fields[1], nsec})
+	}
+
+	for i, l := range lines {
+		if last[l.key] != i || l.expiry == 0 {
+			continue
+		}
+		c.expiries[l.key] = time.Unix(0, l.expiry)
+		c.order = append(c.order, l.key)
+	}
+	c.evict(time.Now())
+
+	return scanner.Err()
+}
+
+// append must be called with mu held
+func (c *Cache) append(key string, expiry time.Time) error {
+	if c.lines >= 2*c.size {
+		return c.compact()
+	}
+	if c.file == nil {
+		return nil
+	}
+
+	_, err := fmt.Fprintf(c.file, "%d %s\n", expiry.UnixNano(), key)
+	if err != nil {
+		return err
+	}
+	c.lines++
+	return nil
+}
+
+// compact rewrites the file with the keys in the cache, and must be called with mu held
+func (c *Cache) compact() error {
+	if c.path == "" {
+		return nil
+	}
+	if c.file != nil {
+		c.file.Close()
+		c.file = nil
+	}
+
+	var b strings.Builder
+	for _, key := range c.order {
+		fmt.Fprintf(&b, "%d %s\n", c.expiries[key].UnixNano(), key)
+	}
+
+	var tmp = c.path + ".tmp"
+	var err = ioutil.WriteFile(tmp, []byte(b.String()), 0644)
+	if err != nil {
+		return errors.Wrap(err, "WriteFile")
+	}
+	err = os.Rename(tmp, c.path)
+	if err != nil {
+		return errors.Wrap(err, "Rename")
+	}
+
+	c.file, err = os.OpenFile(c.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "OpenFile")
+	}
+	c.lines = len(c.order)
+	return nil
+}
+
+// Scope is a view of the cache whose keys are prefixed. A nil Scope records nothing.
+type Scope struct {
+	cache  *Cache
+	prefix string
+}
+
+// Seen records the key and reports whether it had been recorded already
+func (s *Scope) Seen(key string) bool {
+	if s == nil {
+		return false
+	}
+	return s.cache.Seen(s.prefix + ":" + key)
+}
+
+// Forget removes the key, so that it is new again
+func (s *Scope) Forget(key string) {
+	if s == nil {
+		return
+	}
+	s.cache.Forget(s.prefix + ":" + key)
+}
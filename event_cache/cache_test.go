@@ -0,0 +1,96 @@
+package event_cache
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSeen(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "events.log")
+
+	cache, err := Open(path, time.Hour, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cache.Seen("a") {
+		t.Fatal("Expected a to be new")
+	}
+	if !cache.Seen("a") {
+		t.Fatal("Expected a to be seen")
+	}
+	if cache.Scope("T1").Seen("a") {
+		t.Fatal("Expected a in the scope to be new")
+	}
+	cache.Close()
+
+	// keys survive restarts
+	cache, err = Open(path, time.Hour, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cache.Seen("a") || !cache.Scope("T1").Seen("a") {
+		t.Fatal("Expected a to be loaded")
+	}
+
+	// the oldest keys are evicted over the size
+	for i := 0; i < 3; i++ {
+		cache.Seen(fmt.Sprint(i))
+	}
+	if cache.Seen("a") {
+		t.Fatal("Expected a to be evicted")
+	}
+	cache.Close()
+
+	var scope *Scope
+	if scope.Seen("a") || scope.Seen("a") {
+		t.Fatal("Expected a nil scope to record nothing")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	cache, err := Open(filepath.Join(t.TempDir(), "events.log"), -time.Second, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Seen("a")
+	if cache.Seen("a") {
+		t.Fatal("Expected a to be expired")
+	}
+}
+
+func TestForget(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "events.log")
+
+	cache, err := Open(path, time.Hour, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Seen("a")
+	cache.Seen("b")
+	cache.Forget("a")
+	if cache.Seen("a") {
+		t.Fatal("Expected a to be forgotten")
+	}
+	cache.Forget("b")
+	cache.Close()
+
+	// forgotten keys stay forgotten after restarts, and keys recorded again are kept
+	cache, err = Open(path, time.Hour, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if !cache.Seen("a") || cache.Seen("b") {
+		t.Fatal("Expected a to be loaded and b to be forgotten")
+	}
+
+	var memory = NewMemory(time.Hour, 10)
+	if memory.Seen("a") || !memory.Seen("a") {
+		t.Fatal("Expected a to be recorded in memory")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/archive"
+	"github.com/pkg/errors"
+)
+
+// runExportCommand writes the transcript of an archived channel.
+// It is run as "DiscordSlackSync export -platform slack -channel C0123 -from 2021-04-01 -format html -o general.html".
+func runExportCommand(args []string) error {
+	var flags = flag.NewFlagSet(ProgramName+" export", flag.ContinueOnError)
+	var dir = flags.String("archive", os.Getenv("ARCHIVE_DIRECTORY"), "archive directory")
+	var platform = flags.String("platform", archive.PlatformSlack, "slack or discord")
+	var channelID = flags.String("channel", "", "channel ID")
+	var from = flags.String("from", "", "start of the range, such as 2021-04-01")
+	var to = flags.String("to", "", "end of the range, now if empty")
+	var format = flags.String("format", archive.FormatHTML, "html or markdown")
+	var title = flags.String("title", "", "title of the transcript, the channel ID if empty")
+	var output = flags.String("o", "", "output file, the standard output if empty")
+
+	var err = flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *dir == "" || *channelID == "" {
+		return errors.New("NoArchiveOrChannel")
+	}
+
+	var start time.Time
+	if *from != "" {
+		start, err = parseBackfillTime(*from)
+		if err != nil {
+			return errors.Wrap(err, "ParseFrom")
+		}
+	}
+	var end = time.Now()
+	if *to != "" {
+		end, err = parseBackfillTime(*to)
+		if err != nil {
+			return errors.Wrap(err, "ParseTo")
+		}
+	}
+
+	records, err := archive.Read(*dir, *platform, *channelID, start, end)
+	if err != nil {
+		return err
+	}
+
+	var options = archive.ExportOptions{Title: *title, AttachmentBase: *dir}
+	if options.Title == "" {
+		options.Title = *channelID
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return errors.Wrap(err, "Create")
+		}
+		defer f.Close()
+		w = f
+
+		// attachments are linked relative to the exported file
+		if rel, err := filepath.Rel(filepath.Dir(*output), *dir); err == nil {
+			options.AttachmentBase = filepath.ToSlash(rel)
+		}
+	}
+
+	err = archive.Export(w, *format, archive.Transcript(records), options)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		fmt.Printf("%d records are exported to %s\n", len(records), *output)
+	}
+	return nil
+}
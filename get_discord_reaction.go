@@ -4,6 +4,7 @@ import (
 	"strings"
 
 	"github.com/kmc-jp/DiscordSlackSynchronizer/discord_webhook"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/slack_emoji_block_maker"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/slack_webhook"
@@ -17,6 +18,7 @@ type DiscordReactionHandler struct {
 	messageFinder *MessageFinder
 
 	settings *settings.Handler
+	logger   *logging.Logger
 }
 
 func NewDiscordReactionHandler(slackHook *slack_webhook.Handler, discordHook *discord_webhook.Handler, messageFinder *MessageFinder, settings *settings.Handler) *DiscordReactionHandler {
@@ -25,10 +27,11 @@ func NewDiscordReactionHandler(slackHook *slack_webhook.Handler, discordHook *di
 		discordHook:   discordHook,
 		messageFinder: messageFinder,
 		settings:      settings,
+		logger:        logging.New("reaction"),
 	}
 }
 
-func (d DiscordReactionHandler) GetReaction(guildID, channelID, messageID string) error {
+func (d DiscordReactionHandler) GetReaction(correlationID, guildID, channelID, messageID string) error {
 	var sdt = d.settings.FindSlackChannel(channelID, guildID)
 	if sdt.SlackChannel == "" {
 		return nil
@@ -76,6 +79,7 @@ func (d DiscordReactionHandler) GetReaction(guildID, channelID, messageID string
 
 	srcMessage.Blocks = blocks
 	srcMessage.Channel = sdt.SlackChannel
+	srcMessage.CorrelationID = correlationID
 
 	_, err = d.slackHook.Update(*srcMessage)
 	if err != nil {
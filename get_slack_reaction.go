@@ -3,11 +3,11 @@ package main
 import (
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/discord_webhook"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/slack_emoji_imager"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/slack_webhook"
@@ -25,6 +25,7 @@ type SlackReactionHandler struct {
 	settings *settings.Handler
 
 	escaper MessageEscaper
+	logger  *logging.Logger
 }
 
 type ReactionImagerType interface {
@@ -40,6 +41,7 @@ func NewSlackReactionHandler(slackHook *slack_webhook.Handler, discordHook *disc
 		discordHook:   discordHook,
 		messageFinder: messageFinder,
 		settings:      settings,
+		logger:        logging.New("reaction"),
 	}
 }
 
@@ -51,9 +53,11 @@ func (d *SlackReactionHandler) SetMessageEscaper(escaper MessageEscaper) {
 	d.escaper = escaper
 }
 
-func (d *SlackReactionHandler) GetReaction(channel string, timestamp string) error {
+func (d *SlackReactionHandler) GetReaction(correlationID, channel, timestamp string) error {
 	const ReactionGifName = "reactions.gif"
 
+	var log = d.logger.With(logging.CorrelationKey, correlationID, "channel", channel, "ts", timestamp)
+
 	var cs, _ = d.settings.FindDiscordChannel(channel)
 	if !cs.Setting.SlackToDiscord {
 		return nil
@@ -89,7 +93,7 @@ func (d *SlackReactionHandler) GetReaction(channel string, timestamp string) err
 
 		resp, err := http.Get(attach.URL)
 		if err != nil {
-			log.Printf("GetFileError: %s\n", err.Error())
+			log.Warn("GetFile", "url", attach.URL, "error", err)
 			continue
 		}
 		defer resp.Body.Close()
@@ -122,6 +126,7 @@ func (d *SlackReactionHandler) GetReaction(channel string, timestamp string) err
 		return errors.Wrap(err, "MakeReactionImage")
 	}
 
+	message.CorrelationID = correlationID
 	newMessage, err := d.discordHook.Edit(message.ChannelID, message.ID, message, dFiles)
 	if err != nil {
 		return errors.Wrap(err, "DiscordMessageEdit")
@@ -174,13 +179,13 @@ func (d *SlackReactionHandler) GetReaction(channel string, timestamp string) err
 
 			sFile, err := d.slackHook.FilesRemoteInfo(externalID, "")
 			if err != nil {
-				log.Printf("FilesRemoteInfo: %s\n", err.Error())
+				log.Warn("FilesRemoteInfo", "error", err)
 				continue
 			}
 
 			err = d.slackHook.FilesRemoteRemove(externalID, "")
 			if err != nil {
-				log.Printf("FilesRemoteRemove: %s\n", err.Error())
+				log.Warn("FilesRemoteRemove", "error", err)
 			}
 
 			externalID = fmt.Sprintf("%s:%s/%s", ProgramName, newMessage.ChannelID, newMessage.Attachments[attachmentIndex].ID)
@@ -195,7 +200,7 @@ func (d *SlackReactionHandler) GetReaction(channel string, timestamp string) err
 			)
 
 			if err != nil {
-				log.Printf("FilesRemoteAdd: %s\n", err.Error())
+				log.Warn("FilesRemoteAdd", "error", err)
 				continue
 			}
 
@@ -203,6 +208,7 @@ func (d *SlackReactionHandler) GetReaction(channel string, timestamp string) err
 		}
 	}
 
+	srcContent.CorrelationID = correlationID
 	_, err = d.slackHook.Update(*srcContent)
 
 	return errors.Wrap(err, "UpdateSlackMessage")
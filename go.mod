@@ -1,6 +1,6 @@
 module github.com/kmc-jp/DiscordSlackSynchronizer
 
-go 1.15
+go 1.16
 
 require (
 	github.com/bwmarrin/discordgo v0.25.0
@@ -13,5 +13,6 @@ require (
 	github.com/stretchr/testify v1.7.1 // indirect
 	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e // indirect
 	golang.org/x/image v0.5.0
+	golang.org/x/text v0.7.0
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 )
@@ -0,0 +1,246 @@
+// Package logging writes leveled records with key-value fields in logfmt or JSON.
+// The level is configured for each subsystem, such as LOG_LEVEL=info,discord=debug.
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+
+	// CorrelationKey is the field of the ID given to each inbound event, which is carried to the API calls it causes
+	CorrelationKey = "correlation_id"
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses the name of the level such as "debug"
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return LevelInfo, errors.Errorf("UnknownLevel: %s", name)
+}
+
+var config = struct {
+	format string
+	level  Level
+	levels map[string]Level
+	out    io.Writer
+	mu     sync.Mutex
+}{
+	format: FormatLogfmt,
+	level:  LevelInfo,
+	levels: map[string]Level{},
+	out:    os.Stderr,
+}
+
+// Configure sets the format and the levels, which are the default level followed by levels of subsystems
+// such as "info,discord=debug,slack_webhook=warn"
+func Configure(format, levels string) error {
+	config.mu.Lock()
+	defer config.mu.Unlock()
+
+	switch format {
+	case "":
+	case FormatLogfmt, FormatJSON:
+		config.format = format
+	default:
+		return errors.Errorf("UnknownFormat: %s", format)
+	}
+
+	for _, item := range strings.Split(levels, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		var subsystem, name = "", item
+		if i := strings.Index(item, "="); i >= 0 {
+			subsystem, name = item[:i], item[i+1:]
+		}
+
+		level, err := ParseLevel(name)
+		if err != nil {
+			return err
+		}
+
+		if subsystem == "" {
+			config.level = level
+		} else {
+			config.levels[subsystem] = level
+		}
+	}
+	return nil
+}
+
+// SetOutput changes the writer of records, which is os.Stderr by default
+func SetOutput(w io.Writer) {
+	config.mu.Lock()
+	defer config.mu.Unlock()
+
+	config.out = w
+}
+
+// NewCorrelationID returns a random ID to trace an inbound event
+func NewCorrelationID() string {
+	var b = make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Logger writes records of a subsystem with the fields added by With
+type Logger struct {
+	subsystem string
+	fields    []interface{}
+}
+
+// New returns the logger of the subsystem, whose level is read on every record so that it can be configured later
+func New(subsystem string) *Logger {
+	return &Logger{subsystem: subsystem}
+}
+
+// With returns the logger adding the pairs of a key and a value to every record
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	var fields = make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	return &Logger{subsystem: l.subsystem, fields: fields}
+}
+
+// Enabled reports whether the records of the level are written
+func (l *Logger) Enabled(level Level) bool {
+	config.mu.Lock()
+	defer config.mu.Unlock()
+
+	return level >= l.level()
+}
+
+// level must be called with config.mu held
+func (l *Logger) level() Level {
+	if level, ok := config.levels[l.subsystem]; ok {
+		return level
+	}
+	return config.level
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.write(LevelDebug, msg, keyvals)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.write(LevelInfo, msg, keyvals)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.write(LevelWarn, msg, keyvals)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.write(LevelError, msg, keyvals)
+}
+
+func (l *Logger) write(level Level, msg string, keyvals []interface{}) {
+	config.mu.Lock()
+	defer config.mu.Unlock()
+
+	if level < l.level() {
+		return
+	}
+
+	var fields = []interface{}{
+		"time", time.Now().Format(time.RFC3339Nano),
+		"level", level.String(),
+		"subsystem", l.subsystem,
+		"msg", msg,
+	}
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "(missing)")
+	}
+
+	var line string
+	if config.format == FormatJSON {
+		line = formatJSON(fields)
+	} else {
+		line = formatLogfmt(fields)
+	}
+	io.WriteString(config.out, line+"\n")
+}
+
+func formatLogfmt(fields []interface{}) string {
+	var pairs []string
+	for i := 0; i < len(fields); i += 2 {
+		var value = valueString(fields[i+1])
+		if value == "" || strings.ContainsAny(value, " =\"\t\r\n") {
+			value = fmt.Sprintf("%q", value)
+		}
+		pairs = append(pairs, fmt.Sprint(fields[i])+"="+value)
+	}
+	return strings.Join(pairs, " ")
+}
+
+func formatJSON(fields []interface{}) string {
+	var pairs []string
+	for i := 0; i < len(fields); i += 2 {
+		key, _ := json.Marshal(fmt.Sprint(fields[i]))
+
+		var value []byte
+		switch v := fields[i+1].(type) {
+		case bool, int, int64, uint, uint64, float64:
+			value, _ = json.Marshal(v)
+		default:
+			value, _ = json.Marshal(valueString(v))
+		}
+		pairs = append(pairs, string(key)+":"+string(value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func valueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
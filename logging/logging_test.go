@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var b bytes.Buffer
+	SetOutput(&b)
+	defer SetOutput(os.Stderr)
+
+	err := Configure(FormatLogfmt, "warn,discord=debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	New("slack").Info("Ignored")
+	New("discord").With(CorrelationKey, "abc").Debug("SendMessage", "channel", "C1", "error", errors.New("not found"))
+
+	var lines = strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("lines: %q", lines)
+	}
+	for _, want := range []string{"level=debug", "subsystem=discord", "msg=SendMessage", "correlation_id=abc", "channel=C1", `error="not found"`} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("%q does not contain %q", lines[0], want)
+		}
+	}
+
+	b.Reset()
+	err = Configure(FormatJSON, "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	New("slack").Warn("Retry", "attempts", 3)
+	if !strings.Contains(b.String(), `"subsystem":"slack","msg":"Retry","attempts":3}`) {
+		t.Errorf("json: %s", b.String())
+	}
+
+	if Configure("xml", "") == nil || Configure("", "verbose") == nil {
+		t.Error("invalid configuration is accepted")
+	}
+}
@@ -5,21 +5,27 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 
+	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/alert"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/archive"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/configurator"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/dead_letter"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/discord_webhook"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/event_cache"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/metrics"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
-	"github.com/kmc-jp/DiscordSlackSynchronizer/slack_emoji_imager"
-	"github.com/kmc-jp/DiscordSlackSynchronizer/slack_webhook"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/supervisor"
 )
 
 type Token struct {
-	Slack struct {
-		API   string
-		Event string
-		User  string
-	}
+	// Slack is the default workspace, which is the first of SlackWorkspaces
+	Slack           SlackTokens
+	SlackWorkspaces []SlackTokens
+
 	Discord struct {
 		API string
 	}
@@ -30,82 +36,206 @@ type Token struct {
 
 var Tokens Token
 var SettingsFile string
+var StateDirectory string
+
+// logger is for the daemon, and the commands print to the terminal
+var logger = logging.New("main")
 
 const ProgramName = "DiscordSlackSync"
 
 func init() {
-	Tokens.Slack.API = os.Getenv("SLACK_API_TOKEN")
-	Tokens.Slack.Event = os.Getenv("SLACK_EVENT_TOKEN")
+	Tokens.SlackWorkspaces = loadSlackWorkspaceTokens()
+	Tokens.Slack = Tokens.SlackWorkspaces[0]
 	Tokens.Discord.API = os.Getenv("DISCORD_BOT_TOKEN")
-	Tokens.Slack.User = os.Getenv("SLACK_API_USER_TOKEN")
-	SettingsFile = filepath.Join(os.Getenv("STATE_DIRECTORY"), "settings.json")
+	StateDirectory = os.Getenv("STATE_DIRECTORY")
+	WebAssets, EmojiAssets = loadAssets(os.Getenv("ASSETS_DIRECTORY"))
+	SettingsFile = filepath.Join(StateDirectory, "settings.json")
 	if SettingsFile == "" {
 		SettingsFile = "settings.json"
 	}
 }
 
 func main() {
-	var setting = settings.New(Tokens.Slack.API, Tokens.Discord.API, SettingsFile)
-
-	imager, err := slack_emoji_imager.New(Tokens.Slack.User, Tokens.Slack.API)
+	var err = logging.Configure(os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))
 	if err != nil {
-		fmt.Println("Imager initialize error:", err)
+		fmt.Println("Logging configuration error:", err)
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		var err = runBackfillCommand(os.Args[2:])
+		if err != nil {
+			fmt.Println("Backfill error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		var err = runExportCommand(os.Args[2:])
+		if err != nil {
+			fmt.Println("Export error:", err)
+			os.Exit(1)
+		}
+		return
 	}
 
+	var setting = settings.New(Tokens.Slack.API, Tokens.Discord.API, SettingsFile)
+
 	if Tokens.Discord.API == "" {
-		fmt.Println("No discord token provided")
+		logger.Error("NoDiscordToken")
 		return
 	}
 
+	deadLetters, err := dead_letter.Open(filepath.Join(StateDirectory, "dead_letters.json"))
+	if err != nil {
+		logger.Error("OpenDeadLetters", "error", err)
+	}
+
 	var discordWebhookHandler = discord_webhook.New(Tokens.Discord.API)
-	var slackWebhookHandler = slack_webhook.New(Tokens.Slack.API)
+	err = discordWebhookHandler.EnableQueue(filepath.Join(StateDirectory, "queue", "discord"))
+	if err != nil {
+		logger.Error("EnableQueue", "queue", "discord", "error", err)
+	} else if deadLetters != nil {
+		deadLetters.Register("discord", discordWebhookHandler.Queue())
+	}
+	watchQueue("discord", discordWebhookHandler.Queue())
 
-	var messageFinder = NewMessageFinder(slackWebhookHandler, discordWebhookHandler)
+	// the Discord session is shared by all Slack workspaces
+	session, err := discordgo.New("Bot " + Tokens.Discord.API)
+	if err != nil {
+		logger.Error("NewDiscordSession", "error", err)
+		return
+	}
+
+	// operational notices are posted to the admin channels through the default workspace
+	var alerter = NewAdminAlerter(Tokens.Slack.API, session, setting)
+	var notifier = alert.New(alerter.Send)
+	alert.SetDefault(notifier)
 
-	var slackReactionHandler = NewSlackReactionHandler(slackWebhookHandler, discordWebhookHandler, messageFinder, setting)
-	slackReactionHandler.SetReactionImager(imager)
+	var stop = make(chan struct{})
+	var sup = supervisor.New()
+
+	eventCache, err := event_cache.Open(
+		filepath.Join(StateDirectory, "events.log"), event_cache.DefaultTTL, event_cache.DefaultSize,
+	)
+	if err != nil {
+		// redelivered events are still ignored until restart
+		logger.Warn("OpenEventCache", "error", err)
+		eventCache = event_cache.NewMemory(event_cache.DefaultTTL, event_cache.DefaultSize)
+	}
 
-	var discordReacionHandler = NewDiscordReactionHandler(slackWebhookHandler, discordWebhookHandler, messageFinder, setting)
+	var messageArchive *archive.Archive
+	if dir := os.Getenv("ARCHIVE_DIRECTORY"); dir != "" {
+		messageArchive, err = archive.Open(dir, os.Getenv("ARCHIVE_ATTACHMENTS") == "yes")
+		if err != nil {
+			logger.Error("OpenArchive", "error", err)
+		}
+	}
 
-	var Discord = NewDiscordBot(Tokens.Discord.API, setting)
-	Discord.SetSlackWebhook(slackWebhookHandler)
-	Discord.SetDiscordWebhook(discordWebhookHandler)
-	Discord.SetDiscordReactionHandler(discordReacionHandler)
-	Discord.EnableModify(os.Getenv("DISCORD_ENABLE_MODIFY_MESSAGES") == "yes")
+	var workspaces []*SlackWorkspace
+	for i, tokens := range Tokens.SlackWorkspaces {
+		workspace, err := NewSlackWorkspace(tokens, setting, i == 0, session, discordWebhookHandler)
+		if err != nil {
+			logger.Error("NewSlackWorkspace", "workspace", tokens.Name, "error", err)
+			continue
+		}
+		workspace.SetEventCache(eventCache)
+		workspace.SetArchive(messageArchive)
+		workspace.SetSupervisor(sup)
 
-	var Slack = NewSlackBot(Tokens.Slack.API, Tokens.Slack.Event, setting)
+		err = workspace.Hook.EnableQueue(filepath.Join(StateDirectory, "queue", "slack_"+workspace.TeamID))
+		if err != nil {
+			logger.Error("EnableQueue", "queue", "slack_"+workspace.TeamID, "error", err)
+		} else if deadLetters != nil {
+			deadLetters.Register("slack_"+workspace.TeamID, workspace.Hook.Queue())
+		}
+		watchQueue("slack_"+workspace.TeamID, workspace.Hook.Queue())
 
-	Slack.SetUserToken(Tokens.Slack.User)
-	Slack.SetDiscordWebhook(discordWebhookHandler)
-	Slack.SetSlackWebhook(slackWebhookHandler)
-	Slack.SetReactionHandler(slackReactionHandler)
-	Slack.SetFilePublishEmoji(os.Getenv("SLACK_FILE_PUBLISH_EMOJI"))
-	Slack.SetMessageFinder(messageFinder)
+		err = workspace.EnableCatchUp(filepath.Join(StateDirectory, "high_water_marks_"+workspace.TeamID+".json"), discordWebhookHandler)
+		if err != nil {
+			logger.Error("EnableCatchUp", "team", workspace.TeamID, "error", err)
+		}
 
-	messageFinder.SetMessageEscaper(Slack)
+		err = workspace.Start(stop)
+		if err != nil {
+			logger.Error("StartChannelMap", "team", workspace.TeamID, "error", err)
+		}
+		workspaces = append(workspaces, workspace)
+	}
 
-	go func() {
-		// start Discord session
-		err := Discord.Do()
+	if os.Getenv("ENABLE_DIRECT_MESSAGES") == "yes" && len(workspaces) > 0 {
+		// direct messages are relayed with the default workspace
+		var workspace = workspaces[0]
+		directMessageHandler, err := NewDirectMessageHandler(
+			workspace.Tokens.API, workspace.Hook, session, workspace.Settings, filepath.Join(StateDirectory, "direct_messages.json"),
+		)
 		if err != nil {
-			fmt.Println("Error opening Discord session: ", err)
+			logger.Error("NewDirectMessageHandler", "error", err)
+		}
+		directMessageHandler.SetMessageEscaper(workspace.Slack)
+		workspace.Discord.SetDirectMessageHandler(directMessageHandler)
+		workspace.Slack.SetDirectMessageHandler(directMessageHandler)
+	}
+
+	// backfill jobs left by the last run are resumed
+	backfillHandler, err := newBackfillHandler(session, discordWebhookHandler)
+	if err != nil {
+		logger.Error("LoadBackfillJobs", "error", err)
+	} else {
+		for _, workspace := range workspaces {
+			backfillHandler.AddSlackWorkspace(workspace.TeamID, workspace.Tokens.API, workspace.Hook)
 		}
+		go backfillHandler.Run(stop)
+		backfillHandler.Resume()
+	}
 
-		fmt.Println("Discord session is now running.  Press CTRL-C to exit.")
-	}()
-	// start Slack session
-	go Slack.Do()
+	if deadLetters != nil {
+		deadLetters.SetNotifier(notifyDeadLetter)
+	}
 
-	slackReactionHandler.SetMessageEscaper(Slack)
+	// start Discord session, which is opened again when it fails
+	go superviseDiscord(sup, session, stop)
+
+	alert.Notify(alert.KindLifecycle, "%sを起動しました", ProgramName)
 
 	var sockType = os.Getenv("SOCK_TYPE")
 	var listenAddr = os.Getenv("LISTEN_ADDRESS")
 
 	// start web configurator
 	var conf = configurator.New(Tokens.Discord.API, Tokens.Slack.API)
+	conf.SetAssets(WebAssets)
+	conf.SetDeadLetters(deadLetters)
+	conf.SetBackfill(backfillHandler)
+	conf.SetArchive(messageArchive)
+	conf.SetAnnounceSettingsChanges(os.Getenv("ANNOUNCE_SETTINGS_CHANGES") == "yes")
+	conf.SetAuth(configurator.AuthConfig{
+		BaseURL:   os.Getenv("CONFIGURATOR_URL"),
+		Discord:   configurator.OAuthClient{ID: os.Getenv("DISCORD_OAUTH_CLIENT_ID"), Secret: os.Getenv("DISCORD_OAUTH_CLIENT_SECRET")},
+		Slack:     configurator.OAuthClient{ID: os.Getenv("SLACK_OAUTH_CLIENT_ID"), Secret: os.Getenv("SLACK_OAUTH_CLIENT_SECRET")},
+		APITokens: strings.Split(os.Getenv("CONFIGURATOR_API_TOKENS"), ","),
+	})
+	if addr := os.Getenv("METRICS_ADDRESS"); addr != "" {
+		serveMonitoring(addr, sup)
+	} else {
+		conf.Handle(MetricsPath, metrics.Handler())
+		conf.Handle(HealthPath, sup.HealthHandler())
+		conf.Handle(ReadyPath, sup.ReadyHandler())
+	}
+	for _, workspace := range workspaces {
+		conf.AddSlackWorkspace(workspace.TeamID, workspace.Tokens.Name, workspace.Tokens.API, workspace.Settings)
+		if workspace.Events != nil {
+			conf.Handle(workspace.EventsPath(), workspace.Events)
+		}
+	}
 	switch sockType {
 	case "tcp", "unix":
-		controller, err := conf.Start(os.Getenv("HTTP_PATH_PREFIX"), sockType, listenAddr, setting)
+		// the default workspace holds the channel map shown in the configurator
+		var confSetting = setting
+		if len(workspaces) > 0 {
+			confSetting = workspaces[0].Settings
+		}
+
+		controller, err := conf.Start(os.Getenv("HTTP_PATH_PREFIX"), sockType, listenAddr, confSetting)
 		if err != nil {
 			panic(err)
 		}
@@ -121,7 +251,13 @@ func main() {
 			}
 		}()
 
-		fmt.Printf("Start Configuration Server on: %s:%s\n", sockType, listenAddr)
+		logger.Info("ConfiguratorStarted", "network", sockType, "address", listenAddr)
+	default:
+		for _, workspace := range workspaces {
+			if workspace.Events != nil {
+				logger.Warn("EventsAPINotServed", "workspace", workspace.Tokens.Name, "reason", "NoSockType")
+			}
+		}
 	}
 
 	// wait syscall
@@ -129,6 +265,15 @@ func main() {
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	<-sc
 
-	Discord.Close()
+	alert.Notify(alert.KindLifecycle, "%sを停止します", ProgramName)
+	notifier.Flush()
+
+	close(stop)
+	session.Close()
 	conf.Close()
+	eventCache.Close()
+	messageArchive.Close()
+	if backfillHandler != nil {
+		backfillHandler.Close()
+	}
 }
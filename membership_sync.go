@@ -0,0 +1,244 @@
+package main
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+)
+
+// MembershipSyncHandler keeps members of private Slack channels in sync with Discord channels hidden from @everyone.
+// Only users linked by user_links are invited or removed.
+type MembershipSyncHandler struct {
+	slack    *slack.Client
+	discord  *discordgo.Session
+	settings *settings.Handler
+	logger   *logging.Logger
+}
+
+func NewMembershipSyncHandler(slackToken string, discord *discordgo.Session, settings *settings.Handler) *MembershipSyncHandler {
+	return &MembershipSyncHandler{
+		slack:    slack.New(slackToken),
+		discord:  discord,
+		settings: settings,
+		logger:   logging.New("membership_sync"),
+	}
+}
+
+// SyncDiscordChannel invites linked users who can view the Discord channel to the Slack channel,
+// and removes linked users who cannot.
+func (h *MembershipSyncHandler) SyncDiscordChannel(guildID, discordID string) {
+	var links = h.settings.FindUserLinks(guildID)
+	if len(links) == 0 {
+		return
+	}
+
+	cs, paired := pairedSlackChannel(h.settings, guildID, discordID)
+	if !paired {
+		return
+	}
+
+	channel, err := h.channel(discordID)
+	if err != nil {
+		h.logger.Error("GetDiscordChannel", "error", err)
+		return
+	}
+	if !settings.IsHiddenFromEveryone(channel, guildID) {
+		return
+	}
+
+	ok, err := h.isPrivateSlackChannel(cs.SlackChannel)
+	if err != nil {
+		h.logger.Error("GetConversationInfo", "error", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	members, err := h.slackMembers(cs.SlackChannel)
+	if err != nil {
+		h.logger.Error("GetUsersInConversation", "error", err)
+		return
+	}
+
+	var invites []string
+	for _, link := range links {
+		if link.Slack == "" || link.Discord == "" {
+			continue
+		}
+
+		canView, err := h.canView(guildID, link.Discord, discordID)
+		if err != nil {
+			h.logger.Error("UserChannelPermissions", "error", err)
+			continue
+		}
+
+		switch {
+		case canView && !members[link.Slack]:
+			invites = append(invites, link.Slack)
+		case !canView && members[link.Slack]:
+			err = h.slack.KickUserFromConversation(cs.SlackChannel, link.Slack)
+			if err != nil {
+				h.logger.Error("KickUserFromConversation", "error", err)
+			}
+		}
+	}
+
+	if len(invites) > 0 {
+		_, err = h.slack.InviteUsersToConversation(cs.SlackChannel, invites...)
+		if err != nil {
+			h.logger.Error("InviteUsersToConversation", "error", err)
+		}
+	}
+}
+
+// SlackMemberJoined lets the linked Discord user view the paired channel
+func (h *MembershipSyncHandler) SlackMemberJoined(slackID, userID string) {
+	guildID, channel, discordUser := h.linkedDiscordChannel(slackID, userID)
+	if channel == nil {
+		return
+	}
+
+	canView, err := h.canView(guildID, discordUser, channel.ID)
+	if err != nil || canView {
+		return
+	}
+
+	// the other permissions of the user in the channel are kept
+	var allow, deny int64 = discordgo.PermissionViewChannel, 0
+	if overwrite := memberOverwrite(channel, discordUser); overwrite != nil {
+		allow |= overwrite.Allow
+		deny = overwrite.Deny &^ discordgo.PermissionViewChannel
+	}
+
+	err = h.discord.ChannelPermissionSet(channel.ID, discordUser, discordgo.PermissionOverwriteTypeMember, allow, deny)
+	if err != nil {
+		h.logger.Error("ChannelPermissionSet", "error", err)
+	}
+}
+
+// SlackMemberLeft removes the permission of the linked Discord user given to the paired channel.
+// Users who can view the channel by their roles are invited again on the next update of the channel.
+func (h *MembershipSyncHandler) SlackMemberLeft(slackID, userID string) {
+	_, channel, discordUser := h.linkedDiscordChannel(slackID, userID)
+	if channel == nil {
+		return
+	}
+
+	var overwrite = memberOverwrite(channel, discordUser)
+	if overwrite == nil || overwrite.Allow&discordgo.PermissionViewChannel == 0 {
+		return
+	}
+
+	// only the permission to view is removed, and the overwrite is deleted if nothing else is left
+	var allow = overwrite.Allow &^ discordgo.PermissionViewChannel
+	if allow == 0 && overwrite.Deny == 0 {
+		var err = h.discord.ChannelPermissionDelete(channel.ID, discordUser)
+		if err != nil {
+			h.logger.Error("ChannelPermissionDelete", "error", err)
+		}
+		return
+	}
+
+	var err = h.discord.ChannelPermissionSet(channel.ID, discordUser, discordgo.PermissionOverwriteTypeMember, allow, overwrite.Deny)
+	if err != nil {
+		h.logger.Error("ChannelPermissionSet", "error", err)
+	}
+}
+
+// memberOverwrite returns the permission overwrite of the user in the channel, or nil
+func memberOverwrite(channel *discordgo.Channel, userID string) *discordgo.PermissionOverwrite {
+	for _, overwrite := range channel.PermissionOverwrites {
+		if overwrite.Type == discordgo.PermissionOverwriteTypeMember && overwrite.ID == userID {
+			return overwrite
+		}
+	}
+	return nil
+}
+
+// linkedDiscordChannel returns the private Discord channel paired with the Slack channel and the user linked with the Slack user
+func (h *MembershipSyncHandler) linkedDiscordChannel(slackID, userID string) (string, *discordgo.Channel, string) {
+	var cs, guildID = h.settings.LookupDiscordChannel(slackID)
+	if cs.DiscordChannel == "" {
+		return "", nil, ""
+	}
+
+	var discordUser = h.settings.FindUserLinks(guildID).DiscordUser(userID)
+	if discordUser == "" {
+		return "", nil, ""
+	}
+
+	if _, paired := pairedSlackChannel(h.settings, guildID, cs.DiscordChannel); !paired {
+		return "", nil, ""
+	}
+
+	channel, err := h.channel(cs.DiscordChannel)
+	if err != nil {
+		h.logger.Error("GetDiscordChannel", "error", err)
+		return "", nil, ""
+	}
+	if !settings.IsHiddenFromEveryone(channel, guildID) {
+		return "", nil, ""
+	}
+
+	return guildID, channel, discordUser
+}
+
+func (h *MembershipSyncHandler) channel(channelID string) (*discordgo.Channel, error) {
+	channel, err := h.discord.State.Channel(channelID)
+	if err == nil {
+		return channel, nil
+	}
+	return h.discord.Channel(channelID)
+}
+
+func (h *MembershipSyncHandler) canView(guildID, userID, channelID string) (bool, error) {
+	// members are not always cached, such as in large guilds
+	if _, err := h.discord.State.Member(guildID, userID); err != nil {
+		member, err := h.discord.GuildMember(guildID, userID)
+		if err != nil {
+			return false, errors.Wrap(err, "GuildMember")
+		}
+		member.GuildID = guildID
+		h.discord.State.MemberAdd(member)
+	}
+
+	permissions, err := h.discord.State.UserChannelPermissions(userID, channelID)
+	if err != nil {
+		return false, err
+	}
+	return permissions&discordgo.PermissionViewChannel != 0, nil
+}
+
+// isPrivateSlackChannel reports whether the members of the Slack channel can be changed
+func (h *MembershipSyncHandler) isPrivateSlackChannel(channelID string) (bool, error) {
+	info, err := h.slack.GetConversationInfo(channelID, false)
+	if err != nil {
+		return false, err
+	}
+	return info.IsPrivate && !info.IsMpIM, nil
+}
+
+func (h *MembershipSyncHandler) slackMembers(channelID string) (map[string]bool, error) {
+	var members = map[string]bool{}
+	var cursor string
+	for {
+		users, next, err := h.slack.GetUsersInConversation(&slack.GetUsersInConversationParameters{
+			ChannelID: channelID,
+			Cursor:    cursor,
+			Limit:     1000,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			members[user] = true
+		}
+		if next == "" {
+			return members, nil
+		}
+		cursor = next
+	}
+}
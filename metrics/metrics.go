@@ -0,0 +1,261 @@
+// Package metrics keeps counters, gauges and histograms of the bridge,
+// which are exposed in the Prometheus text format by Handler.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Namespace is the prefix of the metrics of the bridge
+const Namespace = "discord_slack_sync"
+
+var (
+	// MessagesBridged counts the messages bridged for each direction and source channel
+	MessagesBridged = NewCounter("messages_bridged_total", "Messages bridged, by direction and source channel.", "direction", "channel")
+	// APIErrors counts the failed API requests for each endpoint and status, which is the HTTP status or the error of Slack
+	APIErrors = NewCounter("api_errors_total", "Failed API requests, by endpoint and status.", "endpoint", "status")
+	// RateLimitWaits observes the waits requested by the rate limits of each API
+	RateLimitWaits = NewHistogram("rate_limit_wait_seconds", "Waits requested by rate limits.", []float64{0.5, 1, 2, 5, 10, 30, 60}, "api")
+	// ReactionImageRender observes the time to render the image of Slack reactions
+	ReactionImageRender = NewHistogram("reaction_image_render_seconds", "Time to render the image of Slack reactions.", DefaultBuckets)
+	// PluginExec observes the time to execute the external plugin
+	PluginExec = NewHistogram("plugin_exec_seconds", "Time to execute the external user plugin.", DefaultBuckets, "command")
+	// QueueDepth is the number of messages waiting in the delivery queues
+	QueueDepth = NewGauge("queue_depth", "Messages waiting in the delivery queue.", "queue")
+	// Connected is 1 while the connection, such as the Discord Gateway or Slack Socket Mode, is up
+	Connected = NewGauge("connected", "Whether the connection is up.", "connection")
+	// ArchiveDropped counts the archive records dropped as the records waiting to be written are too many
+	ArchiveDropped = NewCounter("archive_records_dropped_total", "Archive records dropped as the buffer was full.")
+)
+
+// DefaultBuckets are the upper bounds of histograms of durations in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var registry struct {
+	metrics []*metric
+	mu      sync.Mutex
+}
+
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	series map[string]*series
+	mu     sync.Mutex
+}
+
+type series struct {
+	values []string
+	value  float64
+	fn     func() float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func register(kind, name, help string, labels []string) *metric {
+	var m = &metric{
+		name:   Namespace + "_" + name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: map[string]*series{},
+	}
+
+	registry.mu.Lock()
+	registry.metrics = append(registry.metrics, m)
+	registry.mu.Unlock()
+
+	return m
+}
+
+// get returns the series of the label values, which must be given in the order of the labels.
+// must be called with mu held
+func (m *metric) get(values []string) *series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels but %d values are given", m.name, len(m.labels), len(values)))
+	}
+
+	var key = strings.Join(values, "\xff")
+	var s, ok = m.series[key]
+	if !ok {
+		s = &series{values: append([]string{}, values...)}
+		if m.kind == "histogram" {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+type Counter struct {
+	m *metric
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{register("counter", name, help, labels)}
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) Add(v float64, values ...string) {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+
+	c.m.get(values).value += v
+}
+
+type Gauge struct {
+	m *metric
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{register("gauge", name, help, labels)}
+}
+
+func (g *Gauge) Set(v float64, values ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+
+	var s = g.m.get(values)
+	s.value = v
+	s.fn = nil
+}
+
+// SetFunc makes the value read from fn on every scrape, such as the length of a queue
+func (g *Gauge) SetFunc(fn func() float64, values ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+
+	g.m.get(values).fn = fn
+}
+
+type Histogram struct {
+	m *metric
+}
+
+// NewHistogram makes a histogram with the upper bounds of buckets in ascending order
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	var m = register("histogram", name, help, labels)
+	m.buckets = buckets
+	return &Histogram{m}
+}
+
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+
+	var s = h.m.get(values)
+	for i, bound := range h.m.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Handler serves all metrics in the Prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+}
+
+// Write writes all metrics in the Prometheus text format
+func Write(w io.Writer) error {
+	registry.mu.Lock()
+	var metrics = append([]*metric{}, registry.metrics...)
+	registry.mu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name < metrics[j].name
+	})
+
+	var b strings.Builder
+	for _, m := range metrics {
+		m.write(&b)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (m *metric) write(b *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", m.name, m.kind)
+
+	var keys = make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var s = m.series[key]
+		switch m.kind {
+		case "histogram":
+			for i, bound := range m.buckets {
+				fmt.Fprintf(b, "%s_bucket%s %d\n", m.name, m.labelText(s.values, "le", formatFloat(bound)), s.counts[i])
+			}
+			fmt.Fprintf(b, "%s_bucket%s %d\n", m.name, m.labelText(s.values, "le", "+Inf"), s.count)
+			fmt.Fprintf(b, "%s_sum%s %s\n", m.name, m.labelText(s.values), formatFloat(s.sum))
+			fmt.Fprintf(b, "%s_count%s %d\n", m.name, m.labelText(s.values), s.count)
+		default:
+			var value = s.value
+			if s.fn != nil {
+				value = s.fn()
+			}
+			fmt.Fprintf(b, "%s%s %s\n", m.name, m.labelText(s.values), formatFloat(value))
+		}
+	}
+}
+
+// labelText formats the labels such as {direction="slack_to_discord",channel="C0123"}, followed by extra pairs of a name and a value
+func (m *metric) labelText(values []string, extra ...string) string {
+	var pairs []string
+	for i, label := range m.labels {
+		pairs = append(pairs, label+"="+quote(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+quote(extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quote(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
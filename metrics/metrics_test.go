@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	var counter = NewCounter("test_total", "Test counter.", "channel")
+	counter.Inc("C1")
+	counter.Add(2, `quoted "C2"`)
+
+	var gauge = NewGauge("test_depth", "Test gauge.")
+	gauge.SetFunc(func() float64 { return 3 })
+
+	var histogram = NewHistogram("test_seconds", "Test histogram.", []float64{0.1, 1})
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(5)
+
+	var b strings.Builder
+	err := Write(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"# TYPE discord_slack_sync_test_total counter\n",
+		`discord_slack_sync_test_total{channel="C1"} 1` + "\n",
+		`discord_slack_sync_test_total{channel="quoted \"C2\""} 2` + "\n",
+		"discord_slack_sync_test_depth 3\n",
+		`discord_slack_sync_test_seconds_bucket{le="0.1"} 1` + "\n",
+		`discord_slack_sync_test_seconds_bucket{le="1"} 2` + "\n",
+		`discord_slack_sync_test_seconds_bucket{le="+Inf"} 3` + "\n",
+		"discord_slack_sync_test_seconds_sum 5.55\n",
+		"discord_slack_sync_test_seconds_count 3\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, b.String())
+		}
+	}
+}
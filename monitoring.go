@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/delivery_queue"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/metrics"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/supervisor"
+	"github.com/pkg/errors"
+)
+
+const (
+	// MetricsPath is the path of the Prometheus endpoint
+	MetricsPath = "/metrics"
+	// HealthPath fails when a connection is stuck, and ReadyPath fails while any connection is down
+	HealthPath = "/healthz"
+	ReadyPath  = "/readyz"
+
+	// DiscordConnection is the name of the Gateway connection shared by all workspaces
+	DiscordConnection = "discord_gateway"
+	// DiscordReconnectTimeout is how long discordgo may try to resume the session before it is opened again
+	DiscordReconnectTimeout = 5 * time.Minute
+)
+
+// watchQueue exposes the number of messages waiting in the queue, which is nil if the queue is not enabled
+func watchQueue(name string, queue *delivery_queue.Queue) {
+	if queue == nil {
+		return
+	}
+	metrics.QueueDepth.SetFunc(func() float64 {
+		return float64(queue.Len())
+	}, name)
+}
+
+// superviseDiscord opens the session until stop is closed.
+// discordgo reconnects by itself, and the session is opened again only when it stays disconnected.
+func superviseDiscord(sup *supervisor.Supervisor, session *discordgo.Session, stop <-chan struct{}) {
+	session.AddHandler(func(_ *discordgo.Session, _ *discordgo.Connect) {
+		sup.SetConnected(DiscordConnection, true)
+	})
+	session.AddHandler(func(_ *discordgo.Session, _ *discordgo.Disconnect) {
+		sup.SetConnected(DiscordConnection, false)
+	})
+
+	sup.Supervise(DiscordConnection, stop, func(stop <-chan struct{}) error {
+		var err = session.Open()
+		if err != nil && err != discordgo.ErrWSAlreadyOpen {
+			return errors.Wrap(err, "OpenDiscordSession")
+		}
+		logger.Info("DiscordSessionOpened")
+
+		var ticker = time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return nil
+			case <-ticker.C:
+				if sup.DownFor(DiscordConnection) > DiscordReconnectTimeout {
+					session.Close()
+					return errors.New("DiscordSessionStuck")
+				}
+			}
+		}
+	})
+}
+
+// serveMonitoring serves the metrics and the health checks on its own address, apart from the configurator
+func serveMonitoring(addr string, sup *supervisor.Supervisor) {
+	var mux = http.NewServeMux()
+	mux.Handle(MetricsPath, metrics.Handler())
+	mux.Handle(HealthPath, sup.HealthHandler())
+	mux.Handle(ReadyPath, sup.ReadyHandler())
+
+	go func() {
+		var err = http.ListenAndServe(addr, mux)
+		if err != nil {
+			logger.Error("ServeMonitoring", "error", err)
+		}
+	}()
+
+	logger.Info("MonitoringStarted", "address", addr)
+}
@@ -1,7 +1,8 @@
 package main
 
 type ReactionHandler interface {
-	GetReaction(channel string, timestamp string) error
+	// GetReaction updates the reactions of the bridged message, and correlationID is written in the logs of the API calls
+	GetReaction(correlationID, channel, timestamp string) error
 	GetEmojiURI(name string) string
 
 	AddEmoji(name, value string)
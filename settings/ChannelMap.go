@@ -2,11 +2,13 @@ package settings
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
 	"github.com/slack-go/slack"
 )
 
@@ -14,19 +16,51 @@ type ChannelMap struct {
 	slack   *slack.Client
 	discord *discordgo.Session
 
-	slackToDiscord   map[string]string
-	discordToSlack   map[string]string
-	slackIDByName    map[string]string
-	slackNameByID    map[string]string
-	discordIDBylName map[string]string
-	discordNameByID  map[string]string
-	slackSuffix      string
-	discordSuffix    string
-	lastUpdated      time.Time
-	mu               sync.RWMutex
+	slackIDByName map[string]string
+	slackNameByID map[string]string
+	guilds        map[string]*guildChannelMap
+	collisions    []Collision
+	mu            sync.RWMutex
+	createMu      sync.Mutex
+
+	errorReporter       func(error)
+	slackChannelCreated func(guildID, discordID, slackID string)
+	// settingsSource returns the settings applied on every reconcile, and is nil for the maps made only once
+	settingsSource func() ([]SlackDiscordTable, error)
 }
 
-const ChannelMapUpdateIntervals time.Duration = 20 * time.Second
+type guildChannelMap struct {
+	slackToDiscord    map[string]string
+	discordToSlack    map[string]string
+	discordIDBylName  map[string]string
+	discordNameByID   map[string]string
+	discordParentByID map[string]string
+	// discordPrivate holds channels hidden from @everyone
+	discordPrivate map[string]bool
+	slackSuffix    string
+	discordSuffix  string
+	rules          []compiledNameRule
+	ruleSource     []NameRule
+	// channels of guilds without settings are kept from events, but never mapped
+	configured bool
+}
+
+// Collision reports Discord channels whose names map to the same Slack channel.
+// Messages from Slack to these channels are not forwarded until it is resolved.
+type Collision struct {
+	SlackChannelName string             `json:"slack_channel_name"`
+	SlackChannelID   string             `json:"slack_channel_id"`
+	DiscordChannels  []CollisionChannel `json:"discord_channels"`
+}
+
+type CollisionChannel struct {
+	GuildID string `json:"guild_id"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+}
+
+// ChannelMapReconcileIntervals is the interval of the full fetch to recover missed channel events
+const ChannelMapReconcileIntervals time.Duration = 10 * time.Minute
 
 func NewChannelMap(slackToken, discordToken string) *ChannelMap {
 	discord, _ := discordgo.New("Bot " + discordToken)
@@ -34,114 +68,646 @@ func NewChannelMap(slackToken, discordToken string) *ChannelMap {
 		slack:   slack.New(slackToken),
 		discord: discord,
 
-		slackToDiscord:   map[string]string{},
-		discordToSlack:   map[string]string{},
-		slackIDByName:    map[string]string{},
-		slackNameByID:    map[string]string{},
-		discordIDBylName: map[string]string{},
-		discordNameByID:  map[string]string{},
+		slackIDByName: map[string]string{},
+		slackNameByID: map[string]string{},
+		guilds:        map[string]*guildChannelMap{},
+
+		errorReporter: func(err error) { logger.Error("ChannelMap", "error", err) },
 	}
 }
 
-func (c *ChannelMap) SlackToDiscord(slackID string) string {
+// SetErrorReporter sets the function which receives errors of background fetches and channel creation
+func (c *ChannelMap) SetErrorReporter(reporter func(error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errorReporter = reporter
+}
+
+// SetSlackChannelCreatedHandler sets the function called after a Slack channel is created for the Discord channel
+func (c *ChannelMap) SetSlackChannelCreatedHandler(handler func(guildID, discordID, slackID string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slackChannelCreated = handler
+}
+
+// SetSettingsSource sets the function which returns the settings applied on every reconcile,
+// so that the guilds added to the settings are fetched and the removed guilds are dropped
+func (c *ChannelMap) SetSettingsSource(source func() ([]SlackDiscordTable, error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.settingsSource = source
+}
+
+func (c *ChannelMap) reportError(err error) {
+	c.mu.RLock()
+	var reporter = c.errorReporter
+	c.mu.RUnlock()
+
+	reporter(err)
+}
+
+// guild returns the map of the guild, creating it if needed. c.mu must be held.
+func (c *ChannelMap) guild(guildID string) *guildChannelMap {
+	guild, ok := c.guilds[guildID]
+	if !ok {
+		guild = newGuildChannelMap()
+		c.guilds[guildID] = guild
+	}
+	return guild
+}
+
+func newGuildChannelMap() *guildChannelMap {
+	return &guildChannelMap{
+		slackToDiscord:    map[string]string{},
+		discordToSlack:    map[string]string{},
+		discordIDBylName:  map[string]string{},
+		discordNameByID:   map[string]string{},
+		discordParentByID: map[string]string{},
+		discordPrivate:    map[string]bool{},
+	}
+}
+
+// slackName returns the Slack channel name paired with the Discord channel name.
+// Name rules are tried in order before the suffix rule.
+func (g *guildChannelMap) slackName(discordName string) (string, bool) {
+	for _, rule := range g.rules {
+		if name, ok := rule.apply(discordName); ok {
+			return name, true
+		}
+	}
+
+	if !strings.HasSuffix(discordName, g.discordSuffix) {
+		return "", false
+	}
+
+	var name = fmt.Sprintf("%s%s", strings.TrimSuffix(discordName, g.discordSuffix), g.slackSuffix)
+	return NormalizeSlackChannelName(name), true
+}
+
+// discordName returns the Discord channel name paired with the Slack channel name by the suffix rule
+func (g *guildChannelMap) discordName(slackName string) (string, bool) {
+	if !strings.HasSuffix(slackName, g.slackSuffix) {
+		return "", false
+	}
+
+	var name = fmt.Sprintf("%s%s", strings.TrimSuffix(slackName, g.slackSuffix), g.discordSuffix)
+
+	// the name must be mapped back to the same Slack channel
+	mapped, ok := g.slackName(name)
+	return name, ok && mapped == slackName
+}
+
+func sameNameRules(a, b []NameRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *ChannelMap) SlackToDiscord(guildID, slackID string) string {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	return c.slackToDiscord[slackID]
+
+	guild, ok := c.guilds[guildID]
+	if !ok {
+		return ""
+	}
+	return guild.slackToDiscord[slackID]
 }
-func (c *ChannelMap) DiscordToSlack(discordID string, createIfNotExist bool) string {
-	channel := func() string {
+
+func (c *ChannelMap) DiscordToSlack(guildID, discordID string, createIfNotExist bool) string {
+	channel, name, isPrivate := func() (string, string, bool) {
 		c.mu.RLock()
 		defer c.mu.RUnlock()
-		return c.discordToSlack[discordID]
+
+		guild, ok := c.guilds[guildID]
+		if !ok {
+			return "", "", false
+		}
+
+		name, ok := guild.slackName(guild.discordNameByID[discordID])
+		if !ok {
+			name = ""
+		}
+		return guild.discordToSlack[discordID], name, guild.discordPrivate[discordID]
 	}()
 	if channel != "" {
 		return channel
 	}
-	if createIfNotExist {
-		name := c.discordNameByID[discordID]
-		name = fmt.Sprintf("%s%s", strings.TrimSuffix(name, c.discordSuffix), c.slackSuffix)
-		channel = c.CreateChannel(name)
-		return channel
+	if !createIfNotExist || name == "" {
+		return ""
+	}
+
+	// channels hidden from @everyone are created as private channels
+	channel = c.CreateChannel(name, isPrivate)
+	if channel == "" {
+		return ""
+	}
+
+	c.mu.RLock()
+	var created = c.slackChannelCreated
+	c.mu.RUnlock()
+	if created != nil {
+		created(guildID, discordID, channel)
+	}
+	return channel
+}
+
+// DiscordParent returns the category ID of the Discord text channel
+func (c *ChannelMap) DiscordParent(guildID, discordID string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	guild, ok := c.guilds[guildID]
+	if !ok {
+		return ""
+	}
+	return guild.discordParentByID[discordID]
+}
+
+// DiscordChannelInGuild reports whether the Discord text channel may be in the guild.
+// The channels of the guild not fetched yet are allowed unless they are known in the other guilds.
+func (c *ChannelMap) DiscordChannelInGuild(guildID, discordID string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if guild, ok := c.guilds[guildID]; ok {
+		if _, ok := guild.discordNameByID[discordID]; ok {
+			return true
+		}
+		if len(guild.discordNameByID) > 0 {
+			return false
+		}
+	}
+	for id, guild := range c.guilds {
+		if _, ok := guild.discordNameByID[discordID]; ok && id != guildID {
+			return false
+		}
+	}
+	return true
+}
+
+// UpdateDiscordChannel applies a created or updated Discord channel to the map
+func (c *ChannelMap) UpdateDiscordChannel(channel *discordgo.Channel) {
+	if channel.Type != discordgo.ChannelTypeGuildText {
+		return
 	}
-	return ""
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var guild = c.guild(channel.GuildID)
+
+	if oldName, ok := guild.discordNameByID[channel.ID]; ok && guild.discordIDBylName[oldName] == channel.ID {
+		delete(guild.discordIDBylName, oldName)
+	}
+	guild.addDiscordChannel(channel.GuildID, channel)
+	c.generateMap()
 }
-func (c *ChannelMap) CreateChannel(name string) string {
+
+// SetDiscordGuildChannels replaces all channels of the guild, such as on the GuildCreate event
+func (c *ChannelMap) SetDiscordGuildChannels(guildID string, channels []*discordgo.Channel) {
+	var fetched = newGuildChannelMap()
+	for _, channel := range channels {
+		fetched.addDiscordChannel(guildID, channel)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.guild(guildID).setDiscordChannels(fetched)
+	c.generateMap()
+}
+
+// RemoveDiscordChannel removes a deleted Discord channel from the map
+func (c *ChannelMap) RemoveDiscordChannel(guildID, discordID string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	channel, err := c.slack.CreateConversation(name, false)
+
+	guild, ok := c.guilds[guildID]
+	if !ok {
+		return
+	}
+
+	if name, ok := guild.discordNameByID[discordID]; ok && guild.discordIDBylName[name] == discordID {
+		delete(guild.discordIDBylName, name)
+	}
+	delete(guild.discordNameByID, discordID)
+	delete(guild.discordParentByID, discordID)
+	delete(guild.discordPrivate, discordID)
+	c.generateMap()
+}
+
+func (c *ChannelMap) CreateChannel(name string, isPrivate bool) string {
+	channel, err := c.slack.CreateConversation(name, isPrivate)
 	if err != nil {
-		fmt.Printf("Error creating conversation: %v\n", err)
+		c.reportError(errors.Wrap(err, "CreateConversation"))
 		return ""
 	}
-	c.slackIDByName[channel.ID] = channel.Name
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slackIDByName[channel.Name] = channel.ID
+	c.slackNameByID[channel.ID] = channel.Name
 	c.generateMap()
 	return channel.ID
 }
 
+// CreateDiscordChannel creates the Discord text channel paired with the Slack channel
+// under the category parentID, and returns its ID.
+func (c *ChannelMap) CreateDiscordChannel(guildID, slackID, parentID string) string {
+	// Discord accepts duplicated channel names, so creation is serialized
+	c.createMu.Lock()
+	defer c.createMu.Unlock()
+
+	if channel := c.SlackToDiscord(guildID, slackID); channel != "" {
+		return channel
+	}
+
+	slackName, ok := func() (string, bool) {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		name, ok := c.slackNameByID[slackID]
+		return name, ok
+	}()
+	if !ok {
+		info, err := c.slack.GetConversationInfo(slackID, false)
+		if err != nil {
+			c.reportError(errors.Wrap(err, "GetConversationInfo"))
+			return ""
+		}
+		slackName = info.Name
+
+		c.mu.Lock()
+		c.slackIDByName[info.Name] = info.ID
+		c.slackNameByID[info.ID] = info.Name
+		c.mu.Unlock()
+	}
+
+	discordName, ok := c.DiscordChannelNameFor(guildID, slackName)
+	if !ok {
+		return ""
+	}
+
+	channel, err := c.discord.GuildChannelCreateComplex(guildID, discordgo.GuildChannelCreateData{
+		Name:     discordName,
+		Type:     discordgo.ChannelTypeGuildText,
+		ParentID: parentID,
+	})
+	if err != nil {
+		c.reportError(errors.Wrap(err, "GuildChannelCreateComplex"))
+		return ""
+	}
+	channel.GuildID = guildID
+
+	c.UpdateDiscordChannel(channel)
+
+	return c.SlackToDiscord(guildID, slackID)
+}
+
+// SlackChannelNameFor returns the Slack channel name paired with the Discord channel name
+func (c *ChannelMap) SlackChannelNameFor(guildID, discordName string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	guild, ok := c.guilds[guildID]
+	if !ok {
+		return "", false
+	}
+	return guild.slackName(discordName)
+}
+
+// DiscordChannelNameFor returns the Discord channel name paired with the Slack channel name
+func (c *ChannelMap) DiscordChannelNameFor(guildID, slackName string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	guild, ok := c.guilds[guildID]
+	if !ok {
+		return "", false
+	}
+	return guild.discordName(slackName)
+}
+
+func (c *ChannelMap) SlackChannelName(slackID string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.slackNameByID[slackID]
+}
+
+func (c *ChannelMap) DiscordChannelName(guildID, discordID string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	guild, ok := c.guilds[guildID]
+	if !ok {
+		return ""
+	}
+	return guild.discordNameByID[discordID]
+}
+
+// UpdateSlackChannel applies a created, renamed or unarchived Slack channel to the map
+func (c *ChannelMap) UpdateSlackChannel(slackID, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if oldName, ok := c.slackNameByID[slackID]; ok && c.slackIDByName[oldName] == slackID {
+		delete(c.slackIDByName, oldName)
+	}
+	c.slackIDByName[name] = slackID
+	c.slackNameByID[slackID] = name
+	c.generateMap()
+}
+
+// RemoveSlackChannel removes an archived or deleted Slack channel from the map
+func (c *ChannelMap) RemoveSlackChannel(slackID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if name, ok := c.slackNameByID[slackID]; ok && c.slackIDByName[name] == slackID {
+		delete(c.slackIDByName, name)
+	}
+	delete(c.slackNameByID, slackID)
+	c.generateMap()
+}
+
+// Collisions returns the collisions found in the last map generation
+func (c *ChannelMap) Collisions() []Collision {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]Collision{}, c.collisions...)
+}
+
 func (c *ChannelMap) generateMap() {
-	for slackName, slackID := range c.slackIDByName {
-		if strings.HasSuffix(slackName, c.slackSuffix) {
-			slackNamePrefix := strings.TrimSuffix(slackName, c.slackSuffix)
-			discordName := fmt.Sprintf("%s%s", slackNamePrefix, c.discordSuffix)
-			discordID, ok := c.discordIDBylName[discordName]
-			if ok {
-				c.slackToDiscord[slackID] = discordID
-				c.discordToSlack[discordID] = slackID
+	type candidate struct {
+		guildID string
+		channel CollisionChannel
+	}
+
+	var candidates = map[string][]candidate{}
+
+	var guildIDs = make([]string, 0, len(c.guilds))
+	for guildID := range c.guilds {
+		guildIDs = append(guildIDs, guildID)
+	}
+	sort.Strings(guildIDs)
+
+	for _, guildID := range guildIDs {
+		var guild = c.guilds[guildID]
+		guild.slackToDiscord = map[string]string{}
+		guild.discordToSlack = map[string]string{}
+		if !guild.configured {
+			continue
+		}
+
+		for discordID, discordName := range guild.discordNameByID {
+			slackName, ok := guild.slackName(discordName)
+			if !ok {
+				continue
 			}
+			slackID, ok := c.slackIDByName[slackName]
+			if !ok {
+				continue
+			}
+
+			guild.discordToSlack[discordID] = slackID
+			candidates[slackID] = append(candidates[slackID], candidate{
+				guildID: guildID,
+				channel: CollisionChannel{GuildID: guildID, ID: discordID, Name: discordName},
+			})
+		}
+	}
+
+	c.collisions = []Collision{}
+	for slackID, channels := range candidates {
+		if len(channels) == 1 {
+			c.guilds[channels[0].guildID].slackToDiscord[slackID] = channels[0].channel.ID
+			continue
+		}
+
+		var collision = Collision{
+			SlackChannelID:   slackID,
+			SlackChannelName: c.slackNameByID[slackID],
 		}
+		for _, channel := range channels {
+			collision.DiscordChannels = append(collision.DiscordChannels, channel.channel)
+		}
+		sort.Slice(collision.DiscordChannels, func(i, j int) bool {
+			return collision.DiscordChannels[i].ID < collision.DiscordChannels[j].ID
+		})
+		c.collisions = append(c.collisions, collision)
 	}
+
+	sort.Slice(c.collisions, func(i, j int) bool {
+		return c.collisions[i].SlackChannelName < c.collisions[j].SlackChannelName
+	})
 }
 
-func (c *ChannelMap) FetchSlackChannels() {
+func (c *ChannelMap) FetchSlackChannels() (idByName, nameByID map[string]string, err error) {
+	idByName = map[string]string{}
+	nameByID = map[string]string{}
+
 	cursor := ""
 	for {
-		var err error
 		var channels []slack.Channel
 		channels, cursor, err = c.slack.GetConversations(&slack.GetConversationsParameters{
-			Cursor: cursor,
-			Limit:  1000,
+			Cursor:          cursor,
+			Limit:           1000,
+			ExcludeArchived: true,
+			// private channels are listed only if the bot is a member
+			Types: []string{"public_channel", "private_channel", "mpim"},
 		})
 		if err != nil {
-			fmt.Printf("Error fetchSlackChannels: %v", err)
-			return
+			return nil, nil, errors.Wrap(err, "GetConversations")
 		}
 		for _, channel := range channels {
-			c.slackIDByName[channel.Name] = channel.ID
-			c.slackIDByName[channel.ID] = channel.Name
+			idByName[channel.Name] = channel.ID
+			nameByID[channel.ID] = channel.Name
 		}
 		if cursor == "" {
 			break
 		}
 	}
+
+	return
 }
 
-func (c *ChannelMap) FetchDiscordChannel(guildID string) {
-	channels, _ := c.discord.GuildChannels(guildID)
+func (c *ChannelMap) FetchDiscordChannel(guildID string) (*guildChannelMap, error) {
+	channels, err := c.discord.GuildChannels(guildID)
+	if err != nil {
+		return nil, errors.Wrap(err, "GuildChannels")
+	}
 
+	var guild = newGuildChannelMap()
 	for _, channel := range channels {
-		if channel.Type != discordgo.ChannelTypeGuildText {
+		guild.addDiscordChannel(guildID, channel)
+	}
+
+	return guild, nil
+}
+
+func (g *guildChannelMap) addDiscordChannel(guildID string, channel *discordgo.Channel) {
+	if channel.Type != discordgo.ChannelTypeGuildText {
+		return
+	}
+	g.discordIDBylName[channel.Name] = channel.ID
+	g.discordNameByID[channel.ID] = channel.Name
+	g.discordParentByID[channel.ID] = channel.ParentID
+	g.discordPrivate[channel.ID] = IsHiddenFromEveryone(channel, guildID)
+}
+
+// IsHiddenFromEveryone reports whether the @everyone role is denied to view the channel
+func IsHiddenFromEveryone(channel *discordgo.Channel, guildID string) bool {
+	for _, overwrite := range channel.PermissionOverwrites {
+		// the ID of the @everyone role is the guild ID
+		if overwrite.Type == discordgo.PermissionOverwriteTypeRole && overwrite.ID == guildID {
+			return overwrite.Deny&discordgo.PermissionViewChannel != 0
+		}
+	}
+	return false
+}
+
+func (g *guildChannelMap) setDiscordChannels(fetched *guildChannelMap) {
+	g.discordIDBylName = fetched.discordIDBylName
+	g.discordNameByID = fetched.discordNameByID
+	g.discordParentByID = fetched.discordParentByID
+	g.discordPrivate = fetched.discordPrivate
+}
+
+func compileNameRules(rules []NameRule) []compiledNameRule {
+	var compiled = make([]compiledNameRule, 0, len(rules))
+	for _, rule := range rules {
+		c, err := rule.compile()
+		if err != nil {
+			logger.Warn("CompileNameRule", "error", err)
 			continue
 		}
-		c.discordIDBylName[channel.Name] = channel.ID
-		c.discordNameByID[channel.ID] = channel.Name
+		compiled = append(compiled, c)
 	}
+	return compiled
 }
 
-func (c *ChannelMap) UpdateChannels(guildID string, slackSuffix string, discordSuffix string) {
-	c.slackSuffix = slackSuffix
-	c.discordSuffix = discordSuffix
-	now := time.Now()
-	if now.Sub(c.lastUpdated) < ChannelMapUpdateIntervals {
+// Configure applies the suffixes and name rules of the table. It never fetches channels.
+func (c *ChannelMap) Configure(table SlackDiscordTable) {
+	c.mu.RLock()
+	guild, ok := c.guilds[table.Discord]
+	var changed = !ok || !guild.configured || guild.slackSuffix != table.SlackSuffix || guild.discordSuffix != table.DiscordSuffix ||
+		!sameNameRules(guild.ruleSource, table.NameRules)
+	c.mu.RUnlock()
+	if !changed {
 		return
 	}
-	c.lastUpdated = now
 
-	defer func() {
-		c.FetchSlackChannels()
-		c.FetchDiscordChannel(guildID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-		c.mu.Lock()
-		defer c.mu.Unlock()
+	guild = c.guild(table.Discord)
+	guild.configured = true
+	guild.slackSuffix = table.SlackSuffix
+	guild.discordSuffix = table.DiscordSuffix
+	guild.rules = compileNameRules(table.NameRules)
+	guild.ruleSource = append([]NameRule{}, table.NameRules...)
+	c.generateMap()
+}
+
+// Reconcile fetches every channel and replaces the map, to recover channel events missed while disconnected.
+// Guilds which failed to be fetched keep the current channels.
+func (c *ChannelMap) Reconcile() error {
+	var errs []string
+
+	c.mu.RLock()
+	var source = c.settingsSource
+	c.mu.RUnlock()
+	if source != nil {
+		dict, err := source()
+		if err != nil {
+			errs = append(errs, errors.Wrap(err, "GetChannelMap").Error())
+		} else {
+			c.configureAll(dict)
+		}
+	}
+
+	slackIDByName, slackNameByID, err := c.FetchSlackChannels()
+	if err != nil {
+		errs = append(errs, errors.Wrap(err, "FetchSlackChannels").Error())
+	}
+
+	c.mu.RLock()
+	var guildIDs = make([]string, 0, len(c.guilds))
+	for guildID := range c.guilds {
+		guildIDs = append(guildIDs, guildID)
+	}
+	c.mu.RUnlock()
+
+	var fetched = map[string]*guildChannelMap{}
+	for _, guildID := range guildIDs {
+		guild, err := c.FetchDiscordChannel(guildID)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "FetchDiscordChannel(%s)", guildID).Error())
+			continue
+		}
+		fetched[guildID] = guild
+	}
+
+	c.mu.Lock()
+	if slackIDByName != nil {
+		c.slackIDByName = slackIDByName
+		c.slackNameByID = slackNameByID
+	}
+	for guildID, guild := range fetched {
+		c.guild(guildID).setDiscordChannels(guild)
+	}
+	c.generateMap()
+	c.mu.Unlock()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// configureAll configures the guilds of the settings, and drops the other guilds
+func (c *ChannelMap) configureAll(dict []SlackDiscordTable) {
+	var guildIDs = map[string]bool{}
+	for _, table := range dict {
+		c.Configure(table)
+		guildIDs[table.Discord] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var removed bool
+	for guildID := range c.guilds {
+		if !guildIDs[guildID] {
+			delete(c.guilds, guildID)
+			removed = true
+		}
+	}
+	if removed {
 		c.generateMap()
-	}()
+	}
+}
+
+// Run reconciles the map every ChannelMapReconcileIntervals until stop is closed.
+// Changes between the intervals are applied from channel events.
+func (c *ChannelMap) Run(stop <-chan struct{}) {
+	for {
+		var err = c.Reconcile()
+		if err != nil {
+			c.reportError(errors.Wrap(err, "ReconcileChannelMap"))
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(ChannelMapReconcileIntervals):
+		}
+	}
 }
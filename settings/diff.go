@@ -0,0 +1,171 @@
+package settings
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DiffContext is the number of unchanged lines shown around changes
+const DiffContext = 3
+
+// maxDiffCells bounds the table of the diff, beyond which the changed lines are shown as replaced at once
+const maxDiffCells = 4000000
+
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// Diff returns the unified diff of the lines, with the numbers of added and removed lines
+func Diff(from, to string) (diff string, added, removed int) {
+	var a, b = splitLines(from), splitLines(to)
+
+	// the common lines at both ends are trimmed, as a change of settings is usually small
+	var prefix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	var suffix int
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var lines []diffLine
+	for _, text := range a[:prefix] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	lines = append(lines, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, text := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{' ', text})
+	}
+
+	for _, line := range lines {
+		switch line.op {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+	return formatHunks(lines), added, removed
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffMiddle returns the edit of the longest common subsequence
+func diffMiddle(a, b []string) []diffLine {
+	var lines []diffLine
+	if len(a)*len(b) > maxDiffCells {
+		for _, text := range a {
+			lines = append(lines, diffLine{'-', text})
+		}
+		for _, text := range b {
+			lines = append(lines, diffLine{'+', text})
+		}
+		return lines
+	}
+
+	// lcs[i][j] is the length of the common subsequence of a[i:] and b[j:]
+	var lcs = make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var i, j int
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	return lines
+}
+
+// formatHunks writes the changed lines with DiffContext lines around them
+func formatHunks(lines []diffLine) string {
+	var out strings.Builder
+	var lineA, lineB = 1, 1
+	for start := 0; start < len(lines); {
+		if lines[start].op == ' ' {
+			lineA++
+			lineB++
+			start++
+			continue
+		}
+
+		// the hunk starts before the change, and lasts until the context after the last close change
+		var begin = start - DiffContext
+		if begin < 0 {
+			begin = 0
+		}
+		var end, unchanged = start, 0
+		for end < len(lines) && unchanged < 2*DiffContext {
+			if lines[end].op == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			end++
+		}
+		if unchanged > DiffContext {
+			end -= unchanged - DiffContext
+		}
+
+		var fromA, fromB = lineA - (start - begin), lineB - (start - begin)
+		var countA, countB int
+		for _, line := range lines[begin:end] {
+			if line.op != '+' {
+				countA++
+			}
+			if line.op != '-' {
+				countB++
+			}
+		}
+		// an empty range is numbered by the line before it
+		if countA == 0 {
+			fromA--
+		}
+		if countB == 0 {
+			fromB--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", fromA, countA, fromB, countB)
+		for _, line := range lines[begin:end] {
+			out.WriteString(string(line.op) + line.text + "\n")
+		}
+
+		for _, line := range lines[start:end] {
+			if line.op != '+' {
+				lineA++
+			}
+			if line.op != '-' {
+				lineB++
+			}
+		}
+		start = end
+	}
+	return out.String()
+}
@@ -0,0 +1,289 @@
+package settings
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HistorySize is the number of versions of settings.json kept
+const HistorySize = 100
+
+// Version is a settings.json written through Commit
+type Version struct {
+	ID      int       `json:"id"`
+	Author  string    `json:"author"`
+	Comment string    `json:"comment,omitempty"`
+	Time    time.Time `json:"time"`
+	// Added and Removed are the numbers of lines changed from the previous version
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// versionFile is a version with the whole settings and the diff from the previous version
+type versionFile struct {
+	Version
+	Diff string `json:"diff"`
+	// Settings is kept as a string, so that the file is restored as it was written
+	Settings string `json:"settings"`
+}
+
+// historyMu serializes commits, as handlers are copied for each workspace
+var historyMu sync.Mutex
+
+func (s Handler) historyDirectory() string {
+	return filepath.Join(filepath.Dir(s.settingsFilePath), "settings_history")
+}
+
+func (s Handler) versionPath(id int) string {
+	return filepath.Join(s.historyDirectory(), fmt.Sprintf("%06d.json", id))
+}
+
+// Commit writes the settings as a new version by the author.
+// The settings.json written before the first commit, or edited since the last version, is kept as a version without the author.
+func (s Handler) Commit(dict []SlackDiscordTable, author, comment string) (Version, error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	b, err := json.MarshalIndent(dict, "", "    ")
+	if err != nil {
+		return Version{}, errors.Wrap(err, "MarshalIndent")
+	}
+
+	err = os.MkdirAll(s.historyDirectory(), 0755)
+	if err != nil {
+		return Version{}, errors.Wrap(err, "MkdirAll")
+	}
+
+	ids, err := s.versionIDs()
+	if err != nil {
+		return Version{}, err
+	}
+
+	// the diff is made from settings.json on disk, which may have been edited by hand since the last version
+	previous, err := ioutil.ReadFile(s.settingsFilePath)
+	if err != nil && !os.IsNotExist(err) {
+		return Version{}, errors.Wrap(err, "ReadSettings")
+	}
+
+	// a broken settings.json is not kept, so that it can be replaced
+	if err == nil && json.Valid(previous) {
+		var last []byte
+		var comment = "履歴を記録する前の設定"
+		if len(ids) > 0 {
+			file, err := s.readVersion(ids[len(ids)-1])
+			if err != nil {
+				return Version{}, err
+			}
+			last = []byte(file.Settings)
+			comment = "設定ファイルの直接の編集"
+		}
+
+		if len(ids) == 0 || string(last) != string(previous) {
+			var id = 1
+			if len(ids) > 0 {
+				id = ids[len(ids)-1] + 1
+			}
+			_, err = s.writeVersion(id, "", comment, last, previous)
+			if err != nil {
+				return Version{}, err
+			}
+			ids = append(ids, id)
+		}
+	}
+
+	var id = 1
+	if len(ids) > 0 {
+		id = ids[len(ids)-1] + 1
+	}
+
+	version, err := s.writeVersion(id, author, comment, previous, b)
+	if err != nil {
+		return Version{}, err
+	}
+
+	err = ioutil.WriteFile(s.settingsFilePath, b, 0644)
+	if err != nil {
+		os.Remove(s.versionPath(id))
+		return Version{}, errors.Wrap(err, "WriteSettings")
+	}
+
+	ids = append(ids, id)
+	for len(ids) > HistorySize {
+		os.Remove(s.versionPath(ids[0]))
+		ids = ids[1:]
+	}
+	return version, nil
+}
+
+func (s Handler) writeVersion(id int, author, comment string, previous, settings []byte) (Version, error) {
+	diff, added, removed := Diff(string(previous), string(settings))
+
+	var file = versionFile{
+		Version: Version{
+			ID:      id,
+			Author:  author,
+			Comment: comment,
+			Time:    time.Now(),
+			Added:   added,
+			Removed: removed,
+		},
+		Diff:     diff,
+		Settings: string(settings),
+	}
+
+	b, err := json.Marshal(file)
+	if err != nil {
+		return Version{}, errors.Wrap(err, "Marshal")
+	}
+
+	var tmp = s.versionPath(id) + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return Version{}, errors.Wrap(err, "WriteFile")
+	}
+	return file.Version, errors.Wrap(os.Rename(tmp, s.versionPath(id)), "Rename")
+}
+
+func (s Handler) readVersion(id int) (versionFile, error) {
+	var file versionFile
+
+	b, err := ioutil.ReadFile(s.versionPath(id))
+	if err != nil {
+		return file, errors.Wrap(err, "ReadFile")
+	}
+
+	err = json.Unmarshal(b, &file)
+	return file, errors.Wrap(err, "Unmarshal")
+}
+
+// versionIDs returns the IDs of the versions in ascending order
+func (s Handler) versionIDs() ([]int, error) {
+	files, err := ioutil.ReadDir(s.historyDirectory())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadDir")
+	}
+
+	var ids []int
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// Versions returns the versions, the newest first
+func (s Handler) Versions() ([]Version, error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	ids, err := s.versionIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var versions = []Version{}
+	for i := len(ids) - 1; i >= 0; i-- {
+		file, err := s.readVersion(ids[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Version %d", ids[i])
+		}
+		versions = append(versions, file.Version)
+	}
+	return versions, nil
+}
+
+// VersionDiff returns the diff of the version from the previous version
+func (s Handler) VersionDiff(id int) (Version, string, error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	file, err := s.readVersion(id)
+	return file.Version, file.Diff, err
+}
+
+// VersionSettings returns the settings of the version
+func (s Handler) VersionSettings(id int) ([]SlackDiscordTable, error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	file, err := s.readVersion(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var dict []SlackDiscordTable
+	err = json.Unmarshal([]byte(file.Settings), &dict)
+	return dict, errors.Wrap(err, "Unmarshal")
+}
+
+// VersionsOf returns the versions which changed the settings of the guilds kept by the filter, the newest first,
+// with the diffs and the numbers of lines made only from the settings of the guilds
+func (s Handler) VersionsOf(keep func(guildID string) bool) ([]Version, []string, error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	ids, err := s.versionIDs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var versions = []Version{}
+	var diffs = []string{}
+	var previous string
+	for _, id := range ids {
+		file, err := s.readVersion(id)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Version %d", id)
+		}
+
+		// a broken version has no guilds to show
+		var dict, kept []SlackDiscordTable
+		json.Unmarshal([]byte(file.Settings), &dict)
+		for _, guild := range dict {
+			if keep(guild.Discord) {
+				kept = append(kept, guild)
+			}
+		}
+
+		var current string
+		if len(kept) > 0 {
+			b, err := json.MarshalIndent(kept, "", "    ")
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "MarshalIndent")
+			}
+			current = string(b)
+		}
+
+		diff, added, removed := Diff(previous, current)
+		previous = current
+		if added+removed == 0 {
+			continue
+		}
+
+		var version = file.Version
+		version.Added, version.Removed = added, removed
+		versions = append([]Version{version}, versions...)
+		diffs = append([]string{diff}, diffs...)
+	}
+	return versions, diffs, nil
+}
@@ -0,0 +1,111 @@
+package settings
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommit(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "settings.json")
+	err := ioutil.WriteFile(path, []byte(`[{"discord_server": "G1", "channel": []}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s = New("", "", path)
+
+	_, err = s.Commit([]SlackDiscordTable{{Discord: "G1", SlackSuffix: "-discord"}}, "alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := s.Commit([]SlackDiscordTable{{Discord: "G2"}}, "bob", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := s.Versions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[0].ID != version.ID || versions[0].Author != "bob" || versions[2].Author != "" {
+		t.Fatalf("versions: %+v", versions)
+	}
+
+	_, diff, err := s.VersionDiff(version.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, `-        "discord_server": "G1",`) || !strings.Contains(diff, `+        "discord_server": "G2",`) {
+		t.Errorf("diff: %s", diff)
+	}
+
+	dict, err := s.VersionSettings(versions[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := s.GetChannelMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dict) != 1 || dict[0].SlackSuffix != "-discord" || current[0].Discord != "G2" {
+		t.Errorf("version %+v, current %+v", dict, current)
+	}
+}
+
+func TestCommitExternalEdit(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "settings.json")
+	var s = New("", "", path)
+
+	_, err := s.Commit([]SlackDiscordTable{{Discord: "G1"}}, "alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(path, []byte(`[{"discord_server": "G3", "channel": []}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := s.Commit([]SlackDiscordTable{{Discord: "G2"}}, "bob", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := s.Versions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[0].Author != "bob" || versions[1].Author != "" || versions[2].Author != "alice" {
+		t.Fatalf("versions: %+v", versions)
+	}
+
+	_, diff, err := s.VersionDiff(versions[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, `+[{"discord_server": "G3", "channel": []}]`) {
+		t.Errorf("external edit: %s", diff)
+	}
+
+	_, diff, err = s.VersionDiff(version.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, `-[{"discord_server": "G3", "channel": []}]`) || strings.Contains(diff, `"G1"`) {
+		t.Errorf("diff: %s", diff)
+	}
+
+	// a commit without an external edit adds only its own version
+	_, err = s.Commit([]SlackDiscordTable{{Discord: "G4"}}, "carol", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions, err = s.Versions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 4 {
+		t.Errorf("versions: %+v", versions)
+	}
+}
@@ -0,0 +1,50 @@
+package settings
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+)
+
+// AssignMappingIDs gives IDs to the channel settings without them.
+// The IDs are derived from the places and the channels, so that they are the same until the settings are written with them.
+func AssignMappingIDs(dict []SlackDiscordTable) {
+	var used = map[string]bool{}
+	for _, guild := range dict {
+		for _, c := range guild.Channel {
+			if c.ID != "" {
+				used[c.ID] = true
+			}
+		}
+	}
+
+	for i, guild := range dict {
+		for j := range guild.Channel {
+			var c = &guild.Channel[j]
+			if c.ID != "" {
+				continue
+			}
+
+			var sum = sha1.Sum([]byte(fmt.Sprintf("%d/%d/%s/%s/%s/%s/%s",
+				i, j, guild.Discord, c.SlackTeam, c.SlackChannel, c.DiscordChannel, c.DiscordCategory)))
+			var id = hex.EncodeToString(sum[:6])
+			for n := 2; used[id]; n++ {
+				id = fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:6]), n)
+			}
+			c.ID = id
+			used[id] = true
+		}
+	}
+}
+
+// FindMapping returns the indexes of the guild settings and the channel setting with the ID
+func FindMapping(dict []SlackDiscordTable, id string) (int, int, bool) {
+	for i, guild := range dict {
+		for j, c := range guild.Channel {
+			if c.ID == id {
+				return i, j, true
+			}
+		}
+	}
+	return -1, -1, false
+}
@@ -0,0 +1,81 @@
+package settings
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/unicode/norm"
+)
+
+// SlackChannelNameMaxLength is the longest channel name Slack accepts
+const SlackChannelNameMaxLength = 80
+
+// NameRule maps Discord channel names matching the Discord pattern
+// to the Slack channel name built from the Slack template.
+// The template may refer to capture groups like $1 or ${name}.
+type NameRule struct {
+	Discord string `json:"discord"`
+	Slack   string `json:"slack"`
+}
+
+type compiledNameRule struct {
+	pattern  *regexp.Regexp
+	template string
+}
+
+func (r NameRule) compile() (compiledNameRule, error) {
+	pattern, err := regexp.Compile(r.Discord)
+	if err != nil {
+		return compiledNameRule{}, errors.Wrapf(err, "CompileNameRule: %s", r.Discord)
+	}
+	return compiledNameRule{pattern: pattern, template: r.Slack}, nil
+}
+
+func (r compiledNameRule) apply(discordName string) (string, bool) {
+	var match = r.pattern.FindStringSubmatchIndex(discordName)
+	if match == nil {
+		return "", false
+	}
+	var name = r.pattern.ExpandString(nil, r.template, discordName, match)
+	return NormalizeSlackChannelName(string(name)), true
+}
+
+// CompileNameRules checks that every rule has a valid Discord pattern
+func CompileNameRules(rules []NameRule) error {
+	for _, rule := range rules {
+		if _, err := rule.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NormalizeSlackChannelName converts name into a valid Slack channel name.
+// Letters are NFKC normalized and lowercased, and any character Slack does not
+// accept is replaced by a hyphen.
+func NormalizeSlackChannelName(name string) string {
+	var builder strings.Builder
+	var lastHyphen bool
+
+	for _, r := range norm.NFKC.String(name) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_':
+			builder.WriteRune(unicode.ToLower(r))
+			lastHyphen = false
+		default:
+			if !lastHyphen {
+				builder.WriteRune('-')
+			}
+			lastHyphen = true
+		}
+	}
+
+	var normalized = []rune(strings.Trim(builder.String(), "-"))
+	if len(normalized) > SlackChannelNameMaxLength {
+		normalized = normalized[:SlackChannelNameMaxLength]
+	}
+
+	return string(normalized)
+}
@@ -0,0 +1,103 @@
+package settings
+
+import "testing"
+
+func TestNormalizeSlackChannelName(t *testing.T) {
+	var cases = map[string]string{
+		"general":       "general",
+		"Project Ａｌｐｈａ": "project-alpha",
+		"雑談・random!!":   "雑談-random",
+		"--trim--":      "trim",
+		"under_score":   "under_score",
+	}
+
+	for input, expected := range cases {
+		if got := NormalizeSlackChannelName(input); got != expected {
+			t.Errorf("NormalizeSlackChannelName(%q): expected %q, but got %q", input, expected, got)
+		}
+	}
+}
+
+func TestNameRules(t *testing.T) {
+	var guild = newGuildChannelMap()
+	guild.slackSuffix = "-discord"
+	guild.rules = compileNameRules([]NameRule{
+		{Discord: `^proj-(.*)$`, Slack: `p-$1-dc`},
+	})
+
+	if name, ok := guild.slackName("proj-Web"); !ok || name != "p-web-dc" {
+		t.Fatalf("Expected p-web-dc, but got %q", name)
+	}
+	if name, ok := guild.slackName("random"); !ok || name != "random-discord" {
+		t.Fatalf("Expected suffix fallback random-discord, but got %q", name)
+	}
+
+	guild.discordSuffix = "-slack"
+	if _, ok := guild.slackName("random"); ok {
+		t.Fatal("Expected no mapping for a channel without the discord suffix")
+	}
+}
+
+func TestCollisions(t *testing.T) {
+	var c = &ChannelMap{
+		slackIDByName: map[string]string{"general-discord": "C1", "p-web-dc": "C2"},
+		slackNameByID: map[string]string{"C1": "general-discord", "C2": "p-web-dc"},
+		guilds:        map[string]*guildChannelMap{},
+	}
+
+	var guildA = newGuildChannelMap()
+	guildA.configured = true
+	guildA.slackSuffix = "-discord"
+	guildA.discordNameByID = map[string]string{"1": "general", "2": "proj-web"}
+	guildA.rules = compileNameRules([]NameRule{{Discord: `^proj-(.*)$`, Slack: `p-$1-dc`}})
+
+	var guildB = newGuildChannelMap()
+	guildB.configured = true
+	guildB.slackSuffix = "-discord"
+	guildB.discordNameByID = map[string]string{"3": "general"}
+
+	c.guilds["A"] = guildA
+	c.guilds["B"] = guildB
+	c.generateMap()
+
+	if len(c.collisions) != 1 || c.collisions[0].SlackChannelID != "C1" {
+		t.Fatalf("Expected a collision on C1, but got %+v", c.collisions)
+	}
+	if guildA.slackToDiscord["C1"] != "" || guildB.slackToDiscord["C1"] != "" {
+		t.Fatal("Expected colliding Slack channel not to be mapped to Discord")
+	}
+	if guildB.discordToSlack["3"] != "C1" {
+		t.Fatal("Expected Discord to Slack mapping to be kept on collision")
+	}
+	if guildA.slackToDiscord["C2"] != "2" {
+		t.Fatalf("Expected C2 to be mapped to 2, but got %q", guildA.slackToDiscord["C2"])
+	}
+
+	guildB.configured = false
+	c.generateMap()
+	if len(c.collisions) != 0 || guildA.slackToDiscord["C1"] != "1" {
+		t.Fatalf("Expected guilds without settings to be ignored, but got %+v", c.collisions)
+	}
+}
+
+func TestDiscordChannelInGuild(t *testing.T) {
+	var c = &ChannelMap{guilds: map[string]*guildChannelMap{}}
+
+	var guildA = newGuildChannelMap()
+	guildA.discordNameByID = map[string]string{"1": "general"}
+	c.guilds["A"] = guildA
+	c.guilds["B"] = newGuildChannelMap()
+
+	if !c.DiscordChannelInGuild("A", "1") {
+		t.Error("Expected 1 to be in A")
+	}
+	if c.DiscordChannelInGuild("A", "2") {
+		t.Error("Expected a channel unknown to the fetched guild not to be in it")
+	}
+	if c.DiscordChannelInGuild("B", "1") {
+		t.Error("Expected a channel of A not to be in B")
+	}
+	if !c.DiscordChannelInGuild("B", "2") {
+		t.Error("Expected an unknown channel to be allowed before B is fetched")
+	}
+}
@@ -1,16 +1,30 @@
 package settings
 
 import (
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
+	"os"
+	"sync"
 
+	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/alert"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
 	"github.com/pkg/errors"
 )
 
+var logger = logging.New("settings")
+
 type Handler struct {
 	channelMap       *ChannelMap
 	settingsFilePath string
+	discordToken     string
+	slackToken       string
+
+	// slackTeam restricts the settings to a Slack workspace. Settings without slack_team belong to the default one.
+	slackTeam        string
+	defaultSlackTeam bool
 }
 
 //SlackDiscordTable dict of Channel
@@ -19,15 +33,25 @@ type SlackDiscordTable struct {
 	Channel       []ChannelSetting `json:"channel"`
 	SlackSuffix   string           `json:"slack_suffix"`
 	DiscordSuffix string           `json:"discord_suffix"`
+	NameRules     []NameRule       `json:"name_rules,omitempty"`
+	// UserLinks are used to keep members of private channels in sync
+	UserLinks UserLinks `json:"user_links,omitempty"`
+	// AdminSlackChannel and AdminDiscordChannel receive operational notices, such as failed deliveries and lost connections
+	AdminSlackChannel   string `json:"admin_slack_channel,omitempty"`
+	AdminDiscordChannel string `json:"admin_discord_channel,omitempty"`
 }
 
 //ChannelSetting Put send settings
 type ChannelSetting struct {
-	Comment        string      `json:"comment"`
-	SlackChannel   string      `json:"slack"`
-	DiscordChannel string      `json:"discord"`
-	Setting        SendSetting `json:"setting"`
-	Webhook        string      `json:"hook"`
+	// ID identifies the setting in the API, and is given by AssignMappingIDs
+	ID              string      `json:"id,omitempty"`
+	Comment         string      `json:"comment"`
+	SlackChannel    string      `json:"slack"`
+	DiscordChannel  string      `json:"discord"`
+	DiscordCategory string      `json:"discord_category,omitempty"`
+	SlackTeam       string      `json:"slack_team,omitempty"`
+	Setting         SendSetting `json:"setting"`
+	Webhook         string      `json:"hook"`
 }
 
 //SendSetting put send setting
@@ -38,30 +62,96 @@ type SendSetting struct {
 	SendVoiceState           bool `json:"SendVoiceState"`
 	SendMuteState            bool `json:"SendMuteState"`
 	CreateSlackChannelOnSend bool `json:"CreateSlackChannelOnSend"`
-	MuteSlackUsers           Users
+	// SyncChannelName and SyncChannelTopic propagate renames and topic changes to the other side.
+	// SyncChannelArchive archives the Slack channel when the Discord channel is deleted.
+	SyncChannelName    bool `json:"SyncChannelName"`
+	SyncChannelTopic   bool `json:"SyncChannelTopic"`
+	SyncChannelArchive bool `json:"SyncChannelArchive"`
+	// CreateDiscordChannelOnSend creates Discord channels for new Slack channels in DiscordChannelCategory
+	CreateDiscordChannelOnSend bool   `json:"CreateDiscordChannelOnSend"`
+	DiscordChannelCategory     string `json:"DiscordChannelCategory"`
+	MuteSlackUsers             Users
 }
 
 func New(slackToken, discordToken, settingsFilePath string) *Handler {
 	return &Handler{
 		settingsFilePath: settingsFilePath,
+		discordToken:     discordToken,
+		slackToken:       slackToken,
 		channelMap:       NewChannelMap(slackToken, discordToken),
 	}
 }
 
+// ForSlackTeam returns the handler which sees only the settings of the Slack workspace.
+// Settings without slack_team belong to the workspace whose isDefault is true.
+func (s Handler) ForSlackTeam(teamID, slackToken string, isDefault bool) *Handler {
+	return &Handler{
+		settingsFilePath: s.settingsFilePath,
+		discordToken:     s.discordToken,
+		slackToken:       slackToken,
+		channelMap:       NewChannelMap(slackToken, s.discordToken),
+		slackTeam:        teamID,
+		defaultSlackTeam: isDefault,
+	}
+}
+
+func (s Handler) inSlackTeam(c ChannelSetting) bool {
+	if s.slackTeam == "" {
+		return true
+	}
+	if c.SlackTeam == "" {
+		return s.defaultSlackTeam
+	}
+	return c.SlackTeam == s.slackTeam
+}
+
+// brokenSettings is the settings files which can not be parsed, with the mtime and the hash of the content notified
+var brokenSettings struct {
+	files map[string]string
+	mu    sync.Mutex
+}
+
+// GetChannelMap reads the settings file. A broken file is notified once until its content or mtime changes,
+// as the settings are read on every event.
 func (s Handler) GetChannelMap() ([]SlackDiscordTable, error) {
+	dict, dataBytes, err := s.readChannelMap()
+	if err == nil || dataBytes == nil {
+		return dict, err
+	}
+
+	var state = fmt.Sprintf("%x", sha256.Sum256(dataBytes))
+	if info, statErr := os.Stat(s.settingsFilePath); statErr == nil {
+		state += info.ModTime().String()
+	}
+
+	brokenSettings.mu.Lock()
+	defer brokenSettings.mu.Unlock()
+
+	if brokenSettings.files == nil {
+		brokenSettings.files = map[string]string{}
+	}
+	if brokenSettings.files[s.settingsFilePath] != state {
+		brokenSettings.files[s.settingsFilePath] = state
+		alert.Notify(alert.KindSettings, "%sを読み込めません: %s", s.settingsFilePath, errors.Cause(err))
+	}
+	return nil, err
+}
+
+// readChannelMap reads the settings file without notifying, and returns the content if it can not be parsed
+func (s Handler) readChannelMap() ([]SlackDiscordTable, []byte, error) {
 	var dict []SlackDiscordTable
 
 	dataBytes, err := ioutil.ReadFile(s.settingsFilePath)
 	if err != nil {
-		return nil, errors.Wrap(err, "ReadFile")
+		return nil, nil, errors.Wrap(err, "ReadFile")
 	}
 
 	err = json.Unmarshal(dataBytes, &dict)
 	if err != nil {
-		return nil, errors.Wrap(err, "Unmarshal")
+		return nil, dataBytes, errors.Wrap(err, "Unmarshal")
 	}
 
-	return dict, nil
+	return dict, nil, nil
 }
 
 func (s Handler) WriteChannelMap(dict []SlackDiscordTable) error {
@@ -74,51 +164,94 @@ func (s Handler) WriteChannelMap(dict []SlackDiscordTable) error {
 }
 
 func (s Handler) FindSlackChannel(DiscordChannel string, guildID string) ChannelSetting {
+	return s.findSlackChannel(DiscordChannel, guildID, true)
+}
+
+// LookupSlackChannel is FindSlackChannel which never creates Slack channels
+func (s Handler) LookupSlackChannel(DiscordChannel string, guildID string) ChannelSetting {
+	return s.findSlackChannel(DiscordChannel, guildID, false)
+}
+
+func (s Handler) findSlackChannel(DiscordChannel string, guildID string, create bool) ChannelSetting {
 	dict, err := s.GetChannelMap()
 	if err != nil {
-		log.Println(errors.Wrap(err, "GetChannelMap"))
+		logger.Error("GetChannelMap", "error", err)
 	}
 
+	result, _ := s.matchSlackChannel(s.channelMap, dict, DiscordChannel, guildID, create)
+	return result
+}
+
+// matchSlackChannel returns the setting of the first rule matching the Discord channel, with its index in the guild
+func (s Handler) matchSlackChannel(channelMap *ChannelMap, dict []SlackDiscordTable, DiscordChannel string, guildID string, create bool) (ChannelSetting, int) {
 	var result ChannelSetting
 	if dict == nil {
-		return ChannelSetting{}
+		return ChannelSetting{}, -1
 	}
 	for _, c := range dict {
-		s.channelMap.UpdateChannels(c.Discord, c.SlackSuffix, c.DiscordSuffix)
+		channelMap.Configure(c)
 
 		if c.Discord == guildID {
-			for _, channelSet := range c.Channel {
+			for i, channelSet := range c.Channel {
+				if !s.inSlackTeam(channelSet) {
+					continue
+				}
+				// Category Transfer
+				if channelSet.DiscordCategory != "" {
+					if channelMap.DiscordParent(guildID, DiscordChannel) != channelSet.DiscordCategory {
+						continue
+					}
+					result = channelSet
+					result.DiscordChannel = DiscordChannel
+					if channelSet.SlackChannel == "all" {
+						result.SlackChannel = channelMap.DiscordToSlack(
+							guildID, DiscordChannel, create && result.Setting.CreateSlackChannelOnSend)
+						if result.SlackChannel == "" {
+							continue
+						}
+					}
+					return result, i
+				}
 				if channelSet.DiscordChannel == DiscordChannel {
 					result = channelSet
-					return result
+					return result, i
 				}
 				// Complete Transfer
 				if channelSet.SlackChannel == "all" && channelSet.DiscordChannel == "all" {
 					result = channelSet
-					result.SlackChannel = s.channelMap.DiscordToSlack(
-						DiscordChannel, result.Setting.CreateSlackChannelOnSend)
+					result.SlackChannel = channelMap.DiscordToSlack(
+						guildID, DiscordChannel, create && result.Setting.CreateSlackChannelOnSend)
 					if result.SlackChannel == "" {
 						continue
 					}
 					result.DiscordChannel = DiscordChannel
-					return result
+					return result, i
 				}
 				// All-In-One Transfer
 				if channelSet.DiscordChannel == "all" {
 					result = channelSet
-					return result
+					return result, i
 				}
 			}
 		}
 	}
-	return result
+	return result, -1
 }
 
 // FindDiscordChannel find Discord channel from slack channel id
 func (s Handler) FindDiscordChannel(SlackChannel string) (ChannelSetting, string) {
+	return s.findDiscordChannel(SlackChannel, true)
+}
+
+// LookupDiscordChannel is FindDiscordChannel which never creates Discord channels
+func (s Handler) LookupDiscordChannel(SlackChannel string) (ChannelSetting, string) {
+	return s.findDiscordChannel(SlackChannel, false)
+}
+
+func (s Handler) findDiscordChannel(SlackChannel string, create bool) (ChannelSetting, string) {
 	dict, err := s.GetChannelMap()
 	if err != nil {
-		log.Println(errors.Wrap(err, "GetChannelMap"))
+		logger.Error("GetChannelMap", "error", err)
 	}
 
 	if dict == nil {
@@ -126,23 +259,174 @@ func (s Handler) FindDiscordChannel(SlackChannel string) (ChannelSetting, string
 	}
 
 	for _, c := range dict {
-		s.channelMap.UpdateChannels(c.Discord, c.SlackSuffix, c.DiscordSuffix)
+		s.channelMap.Configure(c)
 
 		for _, channelSet := range c.Channel {
+			if !s.inSlackTeam(channelSet) {
+				continue
+			}
+			// Category Transfer
+			// a category shared by one Slack channel is sent only from Discord to Slack
+			if channelSet.DiscordCategory != "" {
+				if channelSet.SlackChannel != "all" {
+					continue
+				}
+				var discordChannel = s.channelMap.SlackToDiscord(c.Discord, SlackChannel)
+				if discordChannel == "" && create && channelSet.Setting.CreateDiscordChannelOnSend {
+					discordChannel = s.channelMap.CreateDiscordChannel(c.Discord, SlackChannel, channelSet.DiscordCategory)
+				}
+				if discordChannel == "" || s.channelMap.DiscordParent(c.Discord, discordChannel) != channelSet.DiscordCategory {
+					continue
+				}
+				result := channelSet
+				result.DiscordChannel = discordChannel
+				result.SlackChannel = SlackChannel
+				return result, c.Discord
+			}
 			if channelSet.SlackChannel == SlackChannel && channelSet.DiscordChannel != "all" {
+				// the settings written by hand may name the channels of the other guilds
+				if !s.channelMap.DiscordChannelInGuild(c.Discord, channelSet.DiscordChannel) {
+					logger.Warn("DiscordChannelNotInGuild", "guild", c.Discord, "channel", channelSet.DiscordChannel)
+					continue
+				}
 				return channelSet, c.Discord
 			}
 			// Complete Transfer
 			if channelSet.SlackChannel == "all" && channelSet.DiscordChannel == "all" {
 				result := channelSet
-				result.DiscordChannel = s.channelMap.SlackToDiscord(SlackChannel)
+				result.DiscordChannel = s.channelMap.SlackToDiscord(c.Discord, SlackChannel)
+				if result.DiscordChannel == "" && create && result.Setting.CreateDiscordChannelOnSend {
+					result.DiscordChannel = s.channelMap.CreateDiscordChannel(
+						c.Discord, SlackChannel, result.Setting.DiscordChannelCategory)
+				}
 				if result.DiscordChannel == "" {
 					continue
 				}
 				result.SlackChannel = SlackChannel
-				return result, result.DiscordChannel
+				return result, c.Discord
 			}
 		}
 	}
 	return ChannelSetting{}, ""
 }
+
+// ChannelMapCollisions returns Discord channels of all-all settings that are mapped to the same Slack channel
+func (s Handler) ChannelMapCollisions() ([]Collision, error) {
+	dict, err := s.GetChannelMap()
+	if err != nil {
+		return nil, errors.Wrap(err, "GetChannelMap")
+	}
+
+	for _, c := range dict {
+		s.channelMap.Configure(c)
+	}
+
+	return s.channelMap.Collisions(), nil
+}
+
+// FindUserLinks returns the user links of the guild
+func (s Handler) FindUserLinks(guildID string) UserLinks {
+	dict, err := s.GetChannelMap()
+	if err != nil {
+		logger.Error("GetChannelMap", "error", err)
+		return nil
+	}
+
+	for _, c := range dict {
+		if c.Discord == guildID {
+			return c.UserLinks
+		}
+	}
+	return nil
+}
+
+// AllUserLinks returns the user links of all guilds
+func (s Handler) AllUserLinks() UserLinks {
+	dict, err := s.GetChannelMap()
+	if err != nil {
+		logger.Error("GetChannelMap", "error", err)
+		return nil
+	}
+
+	var links UserLinks
+	for _, c := range dict {
+		links = append(links, c.UserLinks...)
+	}
+	return links
+}
+
+// AdminChannels returns the admin Slack and Discord channels of all guilds.
+// The settings are read without notifying, as the notices are sent to these channels.
+func (s Handler) AdminChannels() (slackChannels, discordChannels []string, err error) {
+	dict, _, err := s.readChannelMap()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "GetChannelMap")
+	}
+
+	var found = map[string]bool{}
+	for _, c := range dict {
+		if c.AdminSlackChannel != "" && !found["slack:"+c.AdminSlackChannel] {
+			found["slack:"+c.AdminSlackChannel] = true
+			slackChannels = append(slackChannels, c.AdminSlackChannel)
+		}
+		if c.AdminDiscordChannel != "" && !found["discord:"+c.AdminDiscordChannel] {
+			found["discord:"+c.AdminDiscordChannel] = true
+			discordChannels = append(discordChannels, c.AdminDiscordChannel)
+		}
+	}
+	return slackChannels, discordChannels, nil
+}
+
+// SetSlackChannelCreatedHandler sets the function called after a Slack channel is created for the Discord channel
+func (s Handler) SetSlackChannelCreatedHandler(handler func(guildID, discordID, slackID string)) {
+	s.channelMap.SetSlackChannelCreatedHandler(handler)
+}
+
+// StartChannelMap configures the channel map from the settings file and keeps it reconciled until stop is closed.
+// The map runs even if the settings file can not be read, and the guilds are configured on reconciling after it is written.
+func (s Handler) StartChannelMap(stop <-chan struct{}) error {
+	s.channelMap.SetSettingsSource(s.GetChannelMap)
+
+	dict, err := s.GetChannelMap()
+	for _, c := range dict {
+		s.channelMap.Configure(c)
+	}
+
+	go s.channelMap.Run(stop)
+	return errors.Wrap(err, "GetChannelMap")
+}
+
+// SetChannelMapErrorReporter sets the function which receives errors of the channel map
+func (s Handler) SetChannelMapErrorReporter(reporter func(error)) {
+	s.channelMap.SetErrorReporter(reporter)
+}
+
+// SetDiscordGuildChannels reflects all channels of a guild, such as on the GuildCreate event
+func (s Handler) SetDiscordGuildChannels(guildID string, channels []*discordgo.Channel) {
+	s.channelMap.SetDiscordGuildChannels(guildID, channels)
+}
+
+// UpdateDiscordChannel reflects a created, renamed or moved Discord channel
+func (s Handler) UpdateDiscordChannel(channel *discordgo.Channel) {
+	s.channelMap.UpdateDiscordChannel(channel)
+}
+
+// RemoveDiscordChannel reflects a deleted Discord channel
+func (s Handler) RemoveDiscordChannel(guildID, channelID string) {
+	s.channelMap.RemoveDiscordChannel(guildID, channelID)
+}
+
+// UpdateSlackChannel reflects a created, renamed or unarchived Slack channel
+func (s Handler) UpdateSlackChannel(channelID, name string) {
+	s.channelMap.UpdateSlackChannel(channelID, name)
+}
+
+// RemoveSlackChannel reflects an archived or deleted Slack channel
+func (s Handler) RemoveSlackChannel(channelID string) {
+	s.channelMap.RemoveSlackChannel(channelID)
+}
+
+// ChannelMap returns the cache of channel names and all-all pairs
+func (s Handler) ChannelMap() *ChannelMap {
+	return s.channelMap
+}
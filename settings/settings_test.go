@@ -0,0 +1,49 @@
+package settings
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/alert"
+)
+
+func TestBrokenSettingsAlert(t *testing.T) {
+	var posts []string
+	var notifier = alert.New(func(text string) {
+		posts = append(posts, text)
+	})
+	alert.SetDefault(notifier)
+	defer alert.SetDefault(nil)
+
+	var path = filepath.Join(t.TempDir(), "settings.json")
+	var s = New("", "", path)
+
+	var write = func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`[{"discord_server": `)
+	for i := 0; i < 3; i++ {
+		if _, err := s.GetChannelMap(); err == nil {
+			t.Fatal("a broken file is read")
+		}
+	}
+	// the admin channels are read without notices, as they receive the notices
+	s.AdminChannels()
+	notifier.Flush()
+
+	if len(posts) != 1 || strings.Contains(posts[0], "回") {
+		t.Fatalf("posts: %q", posts)
+	}
+
+	write(`[{"discord_server": "G1"`)
+	s.GetChannelMap()
+	notifier.Flush()
+	if len(posts) != 2 {
+		t.Errorf("a changed file is not notified: %q", posts)
+	}
+}
@@ -1,5 +1,7 @@
 package settings
 
+import "strings"
+
 type User struct {
 	ID       string
 	NickName string
@@ -7,6 +9,49 @@ type User struct {
 
 type Users []User
 
+// UserLink pairs a Slack user with a Discord user.
+// Either of them may be empty for users living only on one side, who are still named for direct messages.
+type UserLink struct {
+	Name    string `json:"name,omitempty"`
+	Slack   string `json:"slack"`
+	Discord string `json:"discord"`
+}
+
+type UserLinks []UserLink
+
+// SlackUser returns the Slack user linked with the Discord user
+func (u UserLinks) SlackUser(discordID string) string {
+	for _, link := range u {
+		if link.Discord == discordID && discordID != "" {
+			return link.Slack
+		}
+	}
+	return ""
+}
+
+// DiscordUser returns the Discord user linked with the Slack user
+func (u UserLinks) DiscordUser(slackID string) string {
+	for _, link := range u {
+		if link.Slack == slackID && slackID != "" {
+			return link.Discord
+		}
+	}
+	return ""
+}
+
+// Find returns the link whose name, Slack ID or Discord ID matches
+func (u UserLinks) Find(name string) (UserLink, bool) {
+	if name == "" {
+		return UserLink{}, false
+	}
+	for _, link := range u {
+		if strings.EqualFold(link.Name, name) || link.Slack == name || link.Discord == name {
+			return link, true
+		}
+	}
+	return UserLink{}, false
+}
+
 func (u Users) Find(id string) bool {
 	if id == "" {
 		return false
@@ -0,0 +1,95 @@
+package settings
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// UserLinkConfirmation records that the Discord user accepted the link with the Slack user in the guild.
+// Confirmations are kept apart from settings.json, which the manager of any guild can edit.
+type UserLinkConfirmation struct {
+	Guild   string    `json:"guild"`
+	Slack   string    `json:"slack"`
+	Discord string    `json:"discord"`
+	Time    time.Time `json:"time"`
+}
+
+// confirmationMu serializes the changes of the confirmations, as handlers are copied for each workspace
+var confirmationMu sync.Mutex
+
+func (s Handler) confirmationPath() string {
+	return filepath.Join(filepath.Dir(s.settingsFilePath), "user_link_confirmations.json")
+}
+
+// UserLinkConfirmed reports whether the Discord user of the link confirmed it in the guild
+func (s Handler) UserLinkConfirmed(guildID string, link UserLink) bool {
+	confirmationMu.Lock()
+	defer confirmationMu.Unlock()
+
+	confirmations, err := s.readConfirmations()
+	if err != nil {
+		logger.Error("ReadUserLinkConfirmations", "error", err)
+		return false
+	}
+	for _, c := range confirmations {
+		if c.Guild == guildID && c.Slack == link.Slack && c.Discord == link.Discord {
+			return true
+		}
+	}
+	return false
+}
+
+// SetUserLinkConfirmed records or removes the confirmation of the link in the guild
+func (s Handler) SetUserLinkConfirmed(guildID string, link UserLink, confirmed bool) error {
+	confirmationMu.Lock()
+	defer confirmationMu.Unlock()
+
+	confirmations, err := s.readConfirmations()
+	if err != nil {
+		return err
+	}
+
+	var kept = []UserLinkConfirmation{}
+	for _, c := range confirmations {
+		if c.Guild == guildID && c.Slack == link.Slack && c.Discord == link.Discord {
+			continue
+		}
+		kept = append(kept, c)
+	}
+	if confirmed {
+		kept = append(kept, UserLinkConfirmation{Guild: guildID, Slack: link.Slack, Discord: link.Discord, Time: time.Now()})
+	}
+
+	b, err := json.MarshalIndent(kept, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "MarshalIndent")
+	}
+
+	var tmp = s.confirmationPath() + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return errors.Wrap(err, "WriteFile")
+	}
+	return errors.Wrap(os.Rename(tmp, s.confirmationPath()), "Rename")
+}
+
+// readConfirmations must be called with confirmationMu held
+func (s Handler) readConfirmations() ([]UserLinkConfirmation, error) {
+	b, err := ioutil.ReadFile(s.confirmationPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+
+	var confirmations []UserLinkConfirmation
+	err = json.Unmarshal(b, &confirmations)
+	return confirmations, errors.Wrap(err, "Unmarshal")
+}
@@ -0,0 +1,198 @@
+package settings
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// ProblemError is a setting which does not work, and the settings are not saved with it unless forced
+	ProblemError = "error"
+	// ProblemWarning is a setting which works, but probably not as intended
+	ProblemWarning = "warning"
+)
+
+// Problem is found in the settings by Check or the checks against the APIs
+type Problem struct {
+	Level string `json:"level"`
+	Guild string `json:"guild,omitempty"`
+	// Rule is the index of the channel setting in the guild, which is -1 for the guild itself
+	Rule    int    `json:"rule"`
+	Message string `json:"message"`
+}
+
+func NewProblem(level, guild string, rule int, format string, args ...interface{}) Problem {
+	return Problem{Level: level, Guild: guild, Rule: rule, Message: fmt.Sprintf(format, args...)}
+}
+
+// Route is a Discord channel and the Slack channel it is bridged with by the settings
+type Route struct {
+	Guild          string `json:"guild"`
+	DiscordChannel string `json:"discord_channel"`
+	DiscordName    string `json:"discord_name"`
+	SlackChannel   string `json:"slack_channel"`
+	SlackName      string `json:"slack_name"`
+	// SlackTeam is the workspace whose settings are applied, which is empty for a single workspace
+	SlackTeam      string `json:"slack_team,omitempty"`
+	SlackToDiscord bool   `json:"slack2discord"`
+	DiscordToSlack bool   `json:"discord2slack"`
+	// Rule is the index of the channel setting in the guild which the route comes from
+	Rule int `json:"rule"`
+}
+
+// Check finds the problems of the settings which are known without the APIs,
+// such as missing channels, invalid name rules and rules never used as earlier rules match first
+func Check(dict []SlackDiscordTable) []Problem {
+	var problems []Problem
+	var guilds = map[string]bool{}
+	// slackRules is the first rule sending messages of the Slack channel to a specific Discord channel
+	var slackRules = map[string]string{}
+
+	for _, guild := range dict {
+		if guild.Discord == "" {
+			problems = append(problems, NewProblem(ProblemError, "", -1, "discord_serverが指定されていません"))
+			continue
+		}
+		if guilds[guild.Discord] {
+			problems = append(problems, NewProblem(ProblemWarning, guild.Discord, -1,
+				"同じサーバの設定が複数あり、サフィックスと名前の規則は最後の設定が使われます"))
+		}
+		guilds[guild.Discord] = true
+
+		err := CompileNameRules(guild.NameRules)
+		if err != nil {
+			problems = append(problems, NewProblem(ProblemError, guild.Discord, -1, "名前の規則が正しくありません: %s", err))
+		}
+
+		for i, rule := range guild.Channel {
+			problems = append(problems, checkRule(guild, i, slackRules)...)
+			if rule.SlackChannel != "all" && rule.DiscordChannel != "all" && rule.DiscordCategory == "" &&
+				rule.SlackChannel != "" && rule.DiscordChannel != "" {
+				var key = rule.SlackTeam + "/" + rule.SlackChannel
+				if _, ok := slackRules[key]; !ok {
+					slackRules[key] = fmt.Sprintf("%s のルール%d", guild.Discord, i)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Level == ProblemError && problems[j].Level != ProblemError
+	})
+	return problems
+}
+
+func checkRule(guild SlackDiscordTable, i int, slackRules map[string]string) []Problem {
+	var problems []Problem
+	var rule = guild.Channel[i]
+	var add = func(level, format string, args ...interface{}) {
+		problems = append(problems, NewProblem(level, guild.Discord, i, format, args...))
+	}
+
+	if rule.SlackChannel == "" {
+		add(ProblemError, "Slackのチャンネルが指定されていません")
+	}
+	if rule.DiscordChannel == "" && rule.DiscordCategory == "" {
+		add(ProblemError, "Discordのチャンネルが指定されていません")
+	}
+	if !rule.Setting.SlackToDiscord && !rule.Setting.DiscordToSlack {
+		add(ProblemWarning, "どちらの方向にも転送しません")
+	}
+	// a category shared by one Slack channel has no Discord channel to send to
+	if rule.DiscordCategory != "" && rule.SlackChannel != "" && rule.SlackChannel != "all" && rule.Setting.SlackToDiscord {
+		add(ProblemWarning, "カテゴリを1つのSlackのチャンネルに対応させたルールは、Slackからのメッセージを転送しません")
+	}
+
+	// the rules are tried in order for messages from Discord
+	for j, earlier := range guild.Channel[:i] {
+		if earlier.SlackTeam != rule.SlackTeam || earlier.DiscordCategory != "" {
+			continue
+		}
+		if earlier.DiscordChannel == "all" && earlier.SlackChannel != "all" {
+			add(ProblemWarning, "先にあるルール%dがすべてのチャンネルに一致するため、Discordからのメッセージには使われません", j)
+			break
+		}
+		if rule.DiscordCategory == "" && rule.DiscordChannel != "all" && earlier.DiscordChannel == rule.DiscordChannel {
+			add(ProblemWarning, "先にあるルール%dと同じDiscordのチャンネルのため、Discordからのメッセージには使われません", j)
+			break
+		}
+	}
+
+	// the first rule of the Slack channel in all guilds is used for messages from Slack
+	if rule.SlackChannel != "all" && rule.DiscordChannel != "all" && rule.DiscordCategory == "" {
+		if earlier, ok := slackRules[rule.SlackTeam+"/"+rule.SlackChannel]; ok {
+			add(ProblemWarning, "%sと同じSlackのチャンネルのため、Slackからのメッセージには使われません", earlier)
+		}
+	}
+	return problems
+}
+
+// PreviewRoutes fetches the channels and returns the routes of all Discord channels by the settings before they are written,
+// with the collisions of the names. Neither the channel map in use nor channels are changed.
+func (s Handler) PreviewRoutes(dict []SlackDiscordTable) ([]Route, []Collision, error) {
+	var channelMap = NewChannelMap(s.slackToken, s.discordToken)
+	for _, c := range dict {
+		channelMap.Configure(c)
+	}
+	var err = channelMap.Reconcile()
+
+	var routes = []Route{}
+	var done = map[string]bool{}
+	for _, guild := range dict {
+		if done[guild.Discord] {
+			continue
+		}
+		done[guild.Discord] = true
+
+		channelMap.mu.RLock()
+		var channels []string
+		if g, ok := channelMap.guilds[guild.Discord]; ok {
+			for id := range g.discordNameByID {
+				channels = append(channels, id)
+			}
+		}
+		channelMap.mu.RUnlock()
+
+		for _, discordID := range channels {
+			setting, rule := s.matchSlackChannel(channelMap, dict, discordID, guild.Discord, false)
+			if rule < 0 || setting.SlackChannel == "" || setting.SlackChannel == "all" {
+				continue
+			}
+			// the messages of the Slack channel shared by the category are not sent to Discord
+			var oneWay = sharedCategoryRule(dict, guild.Discord, rule, setting)
+			routes = append(routes, Route{
+				Guild:          guild.Discord,
+				DiscordChannel: discordID,
+				DiscordName:    channelMap.DiscordChannelName(guild.Discord, discordID),
+				SlackChannel:   setting.SlackChannel,
+				SlackName:      channelMap.SlackChannelName(setting.SlackChannel),
+				SlackTeam:      s.slackTeam,
+				SlackToDiscord: setting.Setting.SlackToDiscord && !oneWay,
+				DiscordToSlack: setting.Setting.DiscordToSlack,
+				Rule:           rule,
+			})
+		}
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Guild != routes[j].Guild {
+			return routes[i].Guild < routes[j].Guild
+		}
+		return routes[i].DiscordName < routes[j].DiscordName
+	})
+	return routes, channelMap.Collisions(), err
+}
+
+// sharedCategoryRule reports whether the matched setting comes from a category rule with one Slack channel,
+// as the Slack channel of the setting is already resolved for the rules of all channels
+func sharedCategoryRule(dict []SlackDiscordTable, guildID string, rule int, setting ChannelSetting) bool {
+	if setting.DiscordCategory == "" {
+		return false
+	}
+	for _, c := range dict {
+		if c.Discord == guildID && rule < len(c.Channel) && c.Channel[rule].DiscordCategory == setting.DiscordCategory {
+			return c.Channel[rule].SlackChannel != "all"
+		}
+	}
+	return false
+}
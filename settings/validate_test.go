@@ -0,0 +1,57 @@
+package settings
+
+import (
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	var both = SendSetting{SlackToDiscord: true, DiscordToSlack: true}
+	var dict = []SlackDiscordTable{
+		{
+			Discord: "G1",
+			Channel: []ChannelSetting{
+				{SlackChannel: "S1", DiscordChannel: "D1", Setting: both},
+				{SlackChannel: "S2", DiscordChannel: "all", Setting: both},
+				{SlackChannel: "S3", DiscordChannel: "D3", Setting: both},
+				{SlackChannel: "", DiscordChannel: "D4", Setting: both},
+			},
+		},
+		{
+			Discord: "G2",
+			Channel: []ChannelSetting{
+				{SlackChannel: "S1", DiscordChannel: "D5", Setting: both},
+				{SlackChannel: "S6", DiscordChannel: "D6"},
+				{SlackChannel: "S7", DiscordCategory: "K7", Setting: both},
+			},
+		},
+	}
+
+	type key struct {
+		level string
+		guild string
+		rule  int
+	}
+	var want = map[key]bool{
+		{ProblemError, "G1", 3}:   true, // no Slack channel
+		{ProblemWarning, "G1", 2}: true, // after the all rule
+		{ProblemWarning, "G1", 3}: true, // after the all rule
+		{ProblemWarning, "G2", 0}: true, // the Slack channel of G1 rule 0
+		{ProblemWarning, "G2", 1}: true, // no direction
+		{ProblemWarning, "G2", 2}: true, // the category shared by one Slack channel
+	}
+
+	var problems = Check(dict)
+	for _, problem := range problems {
+		var k = key{problem.Level, problem.Guild, problem.Rule}
+		if !want[k] {
+			t.Errorf("unexpected problem: %+v", problem)
+		}
+		delete(want, k)
+	}
+	for k := range want {
+		t.Errorf("missing problem: %+v", k)
+	}
+	if len(problems) > 0 && problems[0].Level != ProblemError {
+		t.Errorf("errors are not first: %+v", problems)
+	}
+}
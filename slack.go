@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -10,9 +12,15 @@ import (
 	"strings"
 	"time"
 
+	"github.com/kmc-jp/DiscordSlackSynchronizer/archive"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/delivery_queue"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/discord_webhook"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/event_cache"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/metrics"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
 	"github.com/kmc-jp/DiscordSlackSynchronizer/slack_webhook"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/supervisor"
 	"github.com/pkg/errors"
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
@@ -49,6 +57,14 @@ type SlackHandler struct {
 	settings *settings.Handler
 
 	reactionHandler  ReactionHandler
+	channelSync      *ChannelSyncHandler
+	membershipSync   *MembershipSyncHandler
+	directMessage    *DirectMessageHandler
+	events           *event_cache.Scope
+	catchUp          *CatchUpHandler
+	archive          *archive.Archive
+	supervisor       *supervisor.Supervisor
+	logger           *logging.Logger
 	filePublishEmoji string
 }
 
@@ -72,6 +88,7 @@ func NewSlackBot(apiToken, eventToken string, settings *settings.Handler) *Slack
 
 	slackBot.settings = settings
 	slackBot.filePublishEmoji = "#"
+	slackBot.logger = logging.New("slack")
 
 	res, _ := slackBot.api.AuthTest()
 	slackBot.workspaceURI = res.URL
@@ -85,18 +102,38 @@ func NewSlackBot(apiToken, eventToken string, settings *settings.Handler) *Slack
 	return &slackBot
 }
 
-func (s *SlackHandler) Do() {
+// Run connects Socket Mode until stop is closed, and returns the error if the connection is lost
+func (s *SlackHandler) Run(stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	go func() {
-		var err = s.scm.Run()
-		if err != nil {
-			fmt.Println(err)
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
 		}
 	}()
 
+	return s.scm.RunContext(ctx)
+}
+
+// ConnectionName is the name of the Socket Mode connection reported by the supervisor
+func (s *SlackHandler) ConnectionName() string {
+	return "slack_socket_mode_" + s.hook.Identity.TeamID
+}
+
+// Do handles the events received by Run
+func (s *SlackHandler) Do() {
 	for ev := range s.scm.Events {
 		switch ev.Type {
 		case scm.EventTypeConnected:
-			fmt.Printf("Start websocket connection with Slack\n")
+			s.logger.Info("SocketModeConnected", "team", s.hook.Identity.TeamID)
+			s.supervisor.SetConnected(s.ConnectionName(), true)
+			// messages sent while disconnected are replayed before new events
+			s.catchUp.CatchUpSlack()
+		case scm.EventTypeConnecting, scm.EventTypeConnectionError, scm.EventTypeDisconnect:
+			s.supervisor.SetConnected(s.ConnectionName(), false)
 		case scm.EventTypeEventsAPI:
 			s.scm.Ack(*ev.Request)
 
@@ -104,37 +141,82 @@ func (s *SlackHandler) Do() {
 			if !ok {
 				continue
 			}
-			switch evp.Type {
-			case slackevents.CallbackEvent:
-				switch evi := evp.InnerEvent.Data.(type) {
-				case *slackevents.AppMentionEvent:
-				case *slackevents.MessageEvent:
-					s.messageHandle(evi)
-				case *slackevents.EmojiChangedEvent:
-					s.emojiChangeHandle(evi)
-				case *slackevents.ReactionAddedEvent:
-					if evi.Item.Type == "message" {
-						if evi.Reaction == s.filePublishEmoji {
-							var err = s.FilePublish(evi.Item.Channel, evi.Item.Timestamp, evi.User)
-							if err != nil {
-								log.Println(err)
-							}
-						}
-						s.reactionHandle(evi.Item.Channel, evi.Item.Timestamp)
+			s.dispatch(evp)
+		}
+	}
+}
+
+// dispatch handles an event received over Socket Mode or the HTTP Events API.
+// Events redelivered by Slack are handled only once.
+func (s *SlackHandler) dispatch(evp slackevents.EventsAPIEvent) {
+	switch evp.Type {
+	case slackevents.CallbackEvent:
+		if callback, ok := evp.Data.(*slackevents.EventsAPICallbackEvent); ok && callback.EventID != "" {
+			if s.events.Seen("slack:event:" + callback.EventID) {
+				return
+			}
+		}
+
+		switch evi := evp.InnerEvent.Data.(type) {
+		case *slackevents.AppMentionEvent:
+		case *slackevents.MessageEvent:
+			s.replayMessage(evi)
+		case *slackevents.EmojiChangedEvent:
+			s.emojiChangeHandle(evi)
+		case *slackevents.MemberJoinedChannelEvent:
+			s.memberJoinedHandle(evi)
+		case *slackevents.MemberLeftChannelEvent:
+			if s.membershipSync != nil {
+				s.membershipSync.SlackMemberLeft(evi.Channel, evi.User)
+			}
+		case *slackevents.ChannelCreatedEvent:
+			s.settings.UpdateSlackChannel(evi.Channel.ID, evi.Channel.Name)
+		case *slackevents.ChannelRenameEvent:
+			s.channelRenameHandle(evi.Channel.ID, evi.Channel.Name)
+		case *slackevents.GroupRenameEvent:
+			s.channelRenameHandle(evi.Channel.ID, evi.Channel.Name)
+		case *slackevents.ChannelArchiveEvent:
+			s.channelArchiveHandle(evi.Channel)
+		case *slackevents.GroupArchiveEvent:
+			s.channelArchiveHandle(evi.Channel)
+		case *slackevents.ChannelDeletedEvent:
+			s.settings.RemoveSlackChannel(evi.Channel)
+		case *slackevents.GroupDeletedEvent:
+			s.settings.RemoveSlackChannel(evi.Channel)
+		case *slackevents.ChannelUnarchiveEvent:
+			s.channelUnarchiveHandle(evi.Channel)
+		case *slackevents.GroupUnarchiveEvent:
+			s.channelUnarchiveHandle(evi.Channel)
+		case *slackevents.ReactionAddedEvent:
+			if evi.Item.Type == "message" {
+				var correlationID = logging.NewCorrelationID()
+				if evi.Reaction == s.filePublishEmoji {
+					var err = s.FilePublish(evi.Item.Channel, evi.Item.Timestamp, evi.User)
+					if err != nil {
+						s.logger.Error("FilePublish", logging.CorrelationKey, correlationID, "error", err)
 					}
-				case *slackevents.ReactionRemovedEvent:
-					if evi.Item.Type == "message" {
-						if evi.Reaction == s.filePublishEmoji {
-							var err = s.FileRevokePublicLink(evi.Item.Channel, evi.Item.Timestamp, evi.User)
-							if err != nil {
-								log.Println(err)
-							}
-						}
-						s.reactionHandle(evi.Item.Channel, evi.Item.Timestamp)
+				}
+				s.reactionHandle(correlationID, evi.Item.Channel, evi.Item.Timestamp)
+				s.archiveReaction(evi.Item.Channel, evi.Item.Timestamp, evi.User, evi.Reaction, true)
+				if s.directMessage != nil {
+					s.directMessage.SlackReaction(evi.Item.Channel, evi.Item.Timestamp, evi.User, evi.Reaction, true)
+				}
+			}
+		case *slackevents.ReactionRemovedEvent:
+			if evi.Item.Type == "message" {
+				var correlationID = logging.NewCorrelationID()
+				if evi.Reaction == s.filePublishEmoji {
+					var err = s.FileRevokePublicLink(evi.Item.Channel, evi.Item.Timestamp, evi.User)
+					if err != nil {
+						s.logger.Error("FileRevokePublicLink", logging.CorrelationKey, correlationID, "error", err)
 					}
 				}
+				s.reactionHandle(correlationID, evi.Item.Channel, evi.Item.Timestamp)
+				s.archiveReaction(evi.Item.Channel, evi.Item.Timestamp, evi.User, evi.Reaction, false)
+				if s.directMessage != nil {
+					s.directMessage.SlackReaction(evi.Item.Channel, evi.Item.Timestamp, evi.User, evi.Reaction, false)
+				}
 			}
-
 		}
 	}
 }
@@ -147,6 +229,46 @@ func (s *SlackHandler) SetMessageFinder(handler *MessageFinder) {
 	s.messageFinder = handler
 }
 
+func (s *SlackHandler) SetChannelSyncHandler(handler *ChannelSyncHandler) {
+	s.channelSync = handler
+}
+
+func (s *SlackHandler) SetMembershipSyncHandler(handler *MembershipSyncHandler) {
+	s.membershipSync = handler
+}
+
+func (s *SlackHandler) SetDirectMessageHandler(handler *DirectMessageHandler) {
+	s.directMessage = handler
+}
+
+// replayMessage handles the message unless it has been handled, which is also used on catching up
+func (s *SlackHandler) replayMessage(ev *slackevents.MessageEvent) {
+	// the same message can be sent again as another event, such as by the retry of the client
+	if ev.SubType == "" && ev.ClientMsgID != "" && s.events.Seen("slack:client_msg:"+ev.ClientMsgID) {
+		return
+	}
+	s.messageHandle(ev)
+}
+
+func (s *SlackHandler) SetCatchUpHandler(handler *CatchUpHandler) {
+	s.catchUp = handler
+}
+
+// SetEventCache sets the cache of handled events, which is shared with the Discord handler of the workspace
+func (s *SlackHandler) SetEventCache(events *event_cache.Scope) {
+	s.events = events
+}
+
+// SetArchive makes the bridged messages, edits, deletions and reactions recorded
+func (s *SlackHandler) SetArchive(archive *archive.Archive) {
+	s.archive = archive
+}
+
+// SetSupervisor makes the state of the Socket Mode connection and the bridged messages reported
+func (s *SlackHandler) SetSupervisor(supervisor *supervisor.Supervisor) {
+	s.supervisor = supervisor
+}
+
 func (s *SlackHandler) SetFilePublishEmoji(emoji string) {
 	s.filePublishEmoji = emoji
 }
@@ -165,11 +287,11 @@ func (s *SlackHandler) SetUserToken(token string) {
 	s.userAPI = slack.New(token)
 }
 
-func (s *SlackHandler) reactionHandle(channel string, timestamp string) {
+func (s *SlackHandler) reactionHandle(correlationID, channel, timestamp string) {
 	if s.reactionHandler != nil {
-		err := s.reactionHandler.GetReaction(channel, timestamp)
+		err := s.reactionHandler.GetReaction(correlationID, channel, timestamp)
 		if err != nil {
-			log.Println("GetReaction:", err)
+			s.logger.Error("GetReaction", logging.CorrelationKey, correlationID, "channel", channel, "ts", timestamp, "error", err)
 		}
 	}
 }
@@ -192,7 +314,58 @@ func (s *SlackHandler) emojiChangeHandle(ev *slackevents.EmojiChangedEvent) {
 	}
 }
 
+// memberJoinedHandle prepares the Discord channel when the bot is invited to a Slack channel
+func (s *SlackHandler) memberJoinedHandle(ev *slackevents.MemberJoinedChannelEvent) {
+	if ev.User != s.hook.Identity.UserID {
+		if s.membershipSync != nil {
+			s.membershipSync.SlackMemberJoined(ev.Channel, ev.User)
+		}
+		return
+	}
+
+	// FindDiscordChannel creates the Discord channel if CreateDiscordChannelOnSend is enabled
+	s.settings.FindDiscordChannel(ev.Channel)
+}
+
+func (s *SlackHandler) channelRenameHandle(channelID, name string) {
+	if s.channelSync != nil {
+		s.channelSync.SlackChannelRenamed(channelID, name)
+		return
+	}
+	s.settings.UpdateSlackChannel(channelID, name)
+}
+
+func (s *SlackHandler) channelArchiveHandle(channelID string) {
+	if s.channelSync != nil {
+		s.channelSync.SlackChannelArchived(channelID)
+		return
+	}
+	s.settings.RemoveSlackChannel(channelID)
+}
+
+func (s *SlackHandler) channelUnarchiveHandle(channelID string) {
+	if s.channelSync != nil {
+		s.channelSync.SlackChannelUnarchived(channelID)
+	}
+}
+
 func (s *SlackHandler) messageHandle(ev *slackevents.MessageEvent) {
+	// direct messages to the bot
+	if ev.ChannelType == "im" {
+		if s.directMessage != nil {
+			s.directMessage.SlackMessage(ev)
+		}
+		return
+	}
+
+	switch ev.SubType {
+	case "channel_topic", "channel_purpose", "group_topic", "group_purpose":
+		if s.channelSync != nil {
+			s.channelSync.SlackTopicChanged(ev.Channel)
+		}
+		return
+	}
+
 	var cs, discordID = s.settings.FindDiscordChannel(ev.Channel)
 	//Confirm Slack to Discord setting
 	if !cs.Setting.SlackToDiscord {
@@ -213,13 +386,21 @@ func (s *SlackHandler) messageHandle(ev *slackevents.MessageEvent) {
 	switch ev.SubType {
 	case "", "file_share":
 		break
+	case "message_changed":
+		s.archiveChange(ev)
+		return
 	case "message_deleted":
 		// TODO: delete discord messages
+		s.archiveChange(ev)
 		return
 	default:
 		return
 	}
 
+	var correlationID = logging.NewCorrelationID()
+	var log = s.logger.With(logging.CorrelationKey, correlationID, "channel", ev.Channel, "ts", ev.TimeStamp)
+	log.Debug("ReceivedMessage")
+
 	type imageFileType struct {
 		info   slackevents.File
 		reader io.Reader
@@ -295,15 +476,28 @@ func (s *SlackHandler) messageHandle(ev *slackevents.MessageEvent) {
 		ChannelID: cs.DiscordChannel,
 		Content:   text,
 	}
+	message.Event, _ = json.Marshal(ev)
+	message.CorrelationID = correlationID
 
+	// a queued message is delivered later, though it cannot be reposted without the message posted
 	newMessage, err := s.discordHook.Send(cs.DiscordChannel, message, true, dFiles)
-	if err != nil {
-		log.Println(errors.Wrap(err, "ResendingFileMessage: "))
+	var queued = err == delivery_queue.ErrQueued
+	if err != nil && !queued {
+		log.Error("SendMessageToDiscord", "error", err)
 		return
 	}
+	if queued {
+		log.Warn("SendMessageToDiscord", "error", err)
+		newMessage = &discord_webhook.Message{}
+	}
+	s.catchUp.MarkSlack(ev.Channel, ev.TimeStamp)
+	metrics.MessagesBridged.Inc("slack_to_discord", ev.Channel)
+	s.supervisor.MarkBridged("slack_to_discord")
+
+	var ts = ev.TimeStamp
 
 	// if user api token is provided, delete message and repost it.
-	if s.userAPI != nil {
+	if s.userAPI != nil && !queued {
 		_, _, err := s.userAPI.DeleteMessage(ev.Channel, ev.TimeStamp)
 		if err == nil {
 			var content = ev.Text + " " + s.messageFinder.CreateURIformat(newMessage.Timestamp.Format(time.RFC3339), ev.User, "")
@@ -328,7 +522,7 @@ func (s *SlackHandler) messageHandle(ev *slackevents.MessageEvent) {
 					},
 				)
 				if err != nil {
-					log.Printf("FilesRemoteAddError: %s\n", err.Error())
+					log.Error("FilesRemoteAdd", "error", err)
 					continue
 				}
 				blocks = append(blocks, slack_webhook.FileBlock(externalID))
@@ -349,13 +543,19 @@ func (s *SlackHandler) messageHandle(ev *slackevents.MessageEvent) {
 				UnfurlLinks: true,
 				UnfurlMedia: true,
 				LinkNames:   true,
+
+				CorrelationID: correlationID,
 			}
 
 			// Send message to Slack
-			s.hook.Send(message)
+			if reposted, err := s.hook.Send(message); err == nil {
+				ts = reposted
+			}
 		}
 	}
 
+	s.archiveMessage(ev, name, ts, discordID, newMessage)
+	log.Debug("BridgedMessage", "discord_message", newMessage.ID)
 }
 
 func (s *SlackHandler) EscapeMessage(content string) (output string, err error) {
@@ -428,13 +628,13 @@ func (s *SlackHandler) FilePublish(channel, timestamp, userID string) error {
 
 			file, _, _, err := s.userAPI.ShareFilePublicURL(fileID)
 			if err != nil {
-				log.Println("FilePublish: ShareFilePublicURL: ", err)
+				s.logger.Warn("ShareFilePublicURL", "file", fileID, "error", err)
 				continue
 			}
 
 			err = s.hook.FilesRemoteRemove(block.ExternalID, "")
 			if err != nil {
-				log.Println("FilePublish: FilesRemoteRemove: ", err)
+				s.logger.Warn("FilesRemoteRemove", "file", fileID, "error", err)
 				continue
 			}
 
@@ -477,13 +677,13 @@ func (s *SlackHandler) FileRevokePublicLink(channel, timestamp, userID string) e
 
 			file, err := s.userAPI.RevokeFilePublicURL(fileID)
 			if err != nil {
-				log.Println("FileRevokePublicLink: RevokeFilePublicURL: ", err)
+				s.logger.Warn("RevokeFilePublicURL", "file", fileID, "error", err)
 				continue
 			}
 
 			err = s.hook.FilesRemoteRemove(block.ExternalID, "")
 			if err != nil {
-				log.Println("FileRevokePublicLink: FilesRemoteRemove: ", err)
+				s.logger.Warn("FilesRemoteRemove", "file", fileID, "error", err)
 				continue
 			}
 
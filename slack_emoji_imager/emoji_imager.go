@@ -11,17 +11,21 @@ import (
 	_ "image/jpeg"
 	_ "image/png"
 	"io"
-	"log"
+	"io/fs"
 	"net/http"
 	"net/url"
+	"os"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/image/draw"
 
 	"github.com/golang/freetype/truetype"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/metrics"
 	"github.com/pkg/errors"
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/gofont/gobold"
@@ -33,6 +37,9 @@ var ErrorNoReactions = errors.Errorf("NoReactions")
 const SlackAPIEndpoint = "https://slack.com/api"
 
 const reactionEmojiSize = 50
+
+var logger = logging.New("slack_emoji_imager")
+
 const reactionNumSize = 50
 const reactionMerginSize = 5
 const laneReactionNum = 8
@@ -48,6 +55,10 @@ type Imager struct {
 	EmojiList EmojiList
 	userToken string
 	botToken  string
+
+	// emojiAssets has the images of the default emojis, which are decoded when they are used first
+	emojiAssets fs.FS
+	emojiImages sync.Map
 }
 
 type EmojiList map[string]string
@@ -77,16 +88,26 @@ type reactionsGetResponse struct {
 
 func New(userToken, botToken string) (*Imager, error) {
 	var imager = &Imager{
-		EmojiList: make(EmojiList),
-		userToken: userToken,
-		botToken:  botToken,
+		EmojiList:   make(EmojiList),
+		userToken:   userToken,
+		botToken:    botToken,
+		emojiAssets: os.DirFS(emojiFilePath),
 	}
 	err := imager.getEmojiList()
 
 	return imager, err
 }
 
+// SetEmojiAssets sets the images of the default emojis, named like emoji_u1f600.png
+func (s *Imager) SetEmojiAssets(assets fs.FS) {
+	s.emojiAssets = assets
+}
+
 func (s *Imager) MakeReactionsImage(channel string, timestamp string) (r io.Reader, err error) {
+	defer func(start time.Time) {
+		metrics.ReactionImageRender.Observe(time.Since(start).Seconds())
+	}(time.Now())
+
 	// Get Slack Message Reactions
 	reactions, err := s.getSlackReactions(channel, timestamp)
 	if err != nil {
@@ -104,7 +125,7 @@ func (s *Imager) MakeReactionsImage(channel string, timestamp string) (r io.Read
 	for i := range reactions {
 		reactions[i], nframe, err = s.resize(reactions[i])
 		if err != nil {
-			log.Println(errors.Wrap(err, "Resize").Error())
+			logger.Warn("Resize", "error", err)
 		}
 
 		if nframe > maxFrame {
@@ -9,8 +9,6 @@ import (
 	_ "image/png"
 	"math"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/kyokomi/emoji"
@@ -155,34 +153,10 @@ func (s *Imager) resize(reaction slackReaction) (resizedReaction slackReaction,
 
 		var emojiRune = []rune(emojiStr)
 
-		var path = filepath.Join(emojiFilePath, fmt.Sprintf("emoji_u%x.png", emojiRune[0]))
-		fp, err := os.Open(path)
+		resizedPaletted, err := s.defaultEmojiImage(fmt.Sprintf("emoji_u%x.png", emojiRune[0]))
 		if err != nil {
-			return reaction, 0, errors.New("EmojiFileOpen")
+			return reaction, 0, err
 		}
-		defer fp.Close()
-
-		srcImage, _, err := image.Decode(fp)
-		if err != nil {
-			return reaction, 0, errors.New("DecodeImage")
-		}
-
-		var width, height = float64(srcImage.Bounds().Size().X), float64(srcImage.Bounds().Size().Y)
-
-		var ratio float64
-		if width > height {
-			ratio = float64(reactionEmojiSize) / width
-		} else {
-			ratio = float64(reactionEmojiSize) / height
-		}
-		srcImage = resize.Resize(
-			uint(math.Floor(width*ratio)),
-			uint(math.Floor(height*ratio)),
-			srcImage, resize.Lanczos3,
-		)
-
-		resizedPaletted := image.NewPaletted(srcImage.Bounds(), colorPalette)
-		draw.FloydSteinberg.Draw(resizedPaletted, srcImage.Bounds(), srcImage, image.Point{})
 
 		reaction.image.other = resizedPaletted
 		if 1 > maxFrame {
@@ -192,3 +166,41 @@ func (s *Imager) resize(reaction slackReaction) (resizedReaction slackReaction,
 
 	return reaction, maxFrame, nil
 }
+
+// defaultEmojiImage returns the resized image of the default emoji, which is kept once it is decoded
+func (s *Imager) defaultEmojiImage(name string) (*image.Paletted, error) {
+	if cached, ok := s.emojiImages.Load(name); ok {
+		return cached.(*image.Paletted), nil
+	}
+
+	fp, err := s.emojiAssets.Open(name)
+	if err != nil {
+		return nil, errors.New("EmojiFileOpen")
+	}
+	defer fp.Close()
+
+	srcImage, _, err := image.Decode(fp)
+	if err != nil {
+		return nil, errors.New("DecodeImage")
+	}
+
+	var width, height = float64(srcImage.Bounds().Size().X), float64(srcImage.Bounds().Size().Y)
+
+	var ratio float64
+	if width > height {
+		ratio = float64(reactionEmojiSize) / width
+	} else {
+		ratio = float64(reactionEmojiSize) / height
+	}
+	srcImage = resize.Resize(
+		uint(math.Floor(width*ratio)),
+		uint(math.Floor(height*ratio)),
+		srcImage, resize.Lanczos3,
+	)
+
+	resizedPaletted := image.NewPaletted(srcImage.Bounds(), colorPalette)
+	draw.FloydSteinberg.Draw(resizedPaletted, srcImage.Bounds(), srcImage, image.Point{})
+
+	s.emojiImages.Store(name, resizedPaletted)
+	return resizedPaletted, nil
+}
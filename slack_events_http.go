@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+const (
+	// SlackEventsPath is the path of the HTTP Events API endpoint under HTTP_PATH_PREFIX
+	SlackEventsPath = "/slack/events"
+
+	slackEventsMaxBodySize = 1 << 20
+	slackEventsQueueSize   = 100
+)
+
+// SlackEventsHTTPHandler receives the Slack Events API over HTTP instead of Socket Mode.
+// Events are handed to the same dispatch as Do, one at a time in the order of arrival,
+// which ignores events retried by Slack with the event cache.
+type SlackEventsHTTPHandler struct {
+	// dispatch is the dispatch of the SlackHandler, which is replaced in tests
+	dispatch      func(evp slackevents.EventsAPIEvent)
+	signingSecret string
+
+	events chan slackevents.EventsAPIEvent
+	logger *logging.Logger
+}
+
+func NewSlackEventsHTTPHandler(handler *SlackHandler, signingSecret string) *SlackEventsHTTPHandler {
+	var h = &SlackEventsHTTPHandler{
+		dispatch:      handler.dispatch,
+		signingSecret: signingSecret,
+		events:        make(chan slackevents.EventsAPIEvent, slackEventsQueueSize),
+		logger:        logging.New("slack"),
+	}
+
+	go func() {
+		for evp := range h.events {
+			h.dispatch(evp)
+		}
+	}()
+
+	return h
+}
+
+func (h *SlackEventsHTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, slackEventsMaxBodySize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.verify(r.Header, body)
+	if err != nil {
+		h.logger.Error("VerifySlackRequest", "error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	evp, err := slackevents.ParseEvent(json.RawMessage(body), slackevents.OptionNoVerifyToken())
+	if err != nil {
+		// events unknown to slackevents are dropped, as Slack would retry them if they were refused
+		h.logger.Error("ParseEvent", "error", err)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch evp.Type {
+	case slackevents.URLVerification:
+		var challenge slackevents.ChallengeResponse
+		err = json.Unmarshal(body, &challenge)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(challenge.Challenge))
+	case slackevents.CallbackEvent:
+		// answer without waiting for the dispatch, as Slack retries events not answered within three seconds
+		select {
+		case h.events <- evp:
+			w.WriteHeader(http.StatusOK)
+		default:
+			// let Slack retry the event later rather than blocking the request
+			h.logger.Warn("SlackEventsQueueFull", "size", slackEventsQueueSize)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (h *SlackEventsHTTPHandler) verify(header http.Header, body []byte) error {
+	verifier, err := slack.NewSecretsVerifier(header, h.signingSecret)
+	if err != nil {
+		return err
+	}
+
+	_, err = verifier.Write(body)
+	if err != nil {
+		return err
+	}
+	return verifier.Ensure()
+}
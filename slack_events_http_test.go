@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestSlackEventsHTTPHandler(t *testing.T) {
+	const secret = "signing-secret"
+
+	var h = NewSlackEventsHTTPHandler(&SlackHandler{}, secret)
+	var dispatched = make(chan slackevents.EventsAPIEvent, 1)
+	h.dispatch = func(evp slackevents.EventsAPIEvent) {
+		dispatched <- evp
+	}
+
+	var post = func(body, signingSecret string) (int, string) {
+		var timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+		var mac = hmac.New(sha256.New, []byte(signingSecret))
+		mac.Write([]byte("v0:" + timestamp + ":" + body))
+
+		var r = httptest.NewRequest("POST", SlackEventsPath, strings.NewReader(body))
+		r.Header.Set("X-Slack-Request-Timestamp", timestamp)
+		r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+		var w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code, w.Body.String()
+	}
+
+	code, body := post(`{"type": "url_verification", "token": "t", "challenge": "challenge-value"}`, secret)
+	if code != 200 || body != "challenge-value" {
+		t.Errorf("url_verification: %d %s", code, body)
+	}
+
+	var event = `{"type": "event_callback", "team_id": "T1", "event_id": "Ev1",
+		"event": {"type": "message", "channel": "C1", "user": "U1", "text": "hello", "ts": "1700000000.000100"}}`
+
+	code, body = post(event, "wrong-secret")
+	if code != 401 {
+		t.Errorf("bad signature: %d %s", code, body)
+	}
+	select {
+	case evp := <-dispatched:
+		t.Fatalf("an event with a bad signature is dispatched: %+v", evp)
+	default:
+	}
+
+	code, body = post(event, secret)
+	if code != 200 {
+		t.Fatalf("event: %d %s", code, body)
+	}
+	select {
+	case evp := <-dispatched:
+		if evp.Type != slackevents.CallbackEvent || evp.TeamID != "T1" || evp.InnerEvent.Type != "message" {
+			t.Errorf("unexpected event: %+v", evp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the event is not dispatched")
+	}
+}
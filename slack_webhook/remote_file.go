@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"mime/multipart"
 	"net/http"
 	"net/url"
@@ -162,7 +161,7 @@ func (s *Handler) FilesRemoteAdd(file FilesRemoteAddParameters) (*slack.File, er
 		defer func() {
 			err := recover()
 			if err != nil {
-				log.Printf("Panic in Decoding JSON: %v\n", err)
+				logger.Error("PanicInDecodingJSON", "error", err)
 			}
 		}()
 		return json.NewDecoder(resp.Body).Decode(&responseAttr)
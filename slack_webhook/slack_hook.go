@@ -6,22 +6,29 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"mime/multipart"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/kmc-jp/DiscordSlackSynchronizer/delivery_queue"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/metrics"
 	"github.com/pkg/errors"
 	"github.com/slack-go/slack"
 )
 
 const SlackAPIEndpoint = "https://slack.com/api"
 
+var logger = logging.New("slack_webhook")
+
 type Handler struct {
 	token    string
 	Identity BasicIdentity
+
+	queue *delivery_queue.Queue
 }
 
 //HookMessage SlackにIncommingWebhook経由のMessage送信形式
@@ -49,6 +56,13 @@ type Message struct {
 
 	IconURL   string `json:"icon_url,omitempty"`
 	IconEmoji string `json:"icon_emoji,omitempty"`
+
+	// Event is the original event of the message, which is kept as a dead letter if the delivery fails
+	Event json.RawMessage `json:"-"`
+	// CorrelationID is the ID of the inbound event which causes the message, written in the logs of the delivery
+	CorrelationID string `json:"-"`
+	// Raw is the message returned by GetMessages, which has fields such as subtype and files
+	Raw json.RawMessage `json:"-"`
 }
 
 // Attachment contains all the information for an attachment
@@ -141,56 +155,141 @@ func New(token string) *Handler {
 	return handler
 }
 
-func (s *Handler) send(jsondataBytes []byte, method string) (string, error) {
-	var req *http.Request
+func (s *Handler) send(jsondataBytes []byte, method string, event []byte, correlationID string) (string, error) {
+	if s.queue == nil {
+		ts, _, err := s.deliver(jsondataBytes, method, correlationID)
+		return ts, err
+	}
+
+	var target struct {
+		Channel string `json:"channel"`
+	}
+	json.Unmarshal(jsondataBytes, &target)
+
+	job, _ := json.Marshal(queuedMessage{Method: method, Body: jsondataBytes, CorrelationID: correlationID})
+	ts, err := s.queue.Push(target.Channel, job, event, delivery_queue.LiveWait)
+	return string(ts), err
+}
+
+// queuedMessage is the payload of the delivery queue
+type queuedMessage struct {
+	Method        string          `json:"method"`
+	Body          json.RawMessage `json:"body"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+}
+
+// EnableQueue makes messages delivered in order for each channel through the queue stored in the directory,
+// which are retried when Slack is not available or rate limited
+func (s *Handler) EnableQueue(dir string) error {
+	queue, err := delivery_queue.Open(dir, func(_ string, payload []byte) ([]byte, time.Duration, error) {
+		var message queuedMessage
+		var err = json.Unmarshal(payload, &message)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "Unmarshal")
+		}
+
+		ts, next, err := s.deliver(message.Body, message.Method, message.CorrelationID)
+		return []byte(ts), next, err
+	})
+	if err != nil {
+		return errors.Wrap(err, "OpenQueue")
+	}
+
+	s.queue = queue
+	return nil
+}
+
+// Queue returns the delivery queue, which is nil unless enabled
+func (s *Handler) Queue() *delivery_queue.Queue {
+	return s.queue
+}
+
+func (s *Handler) deliver(jsondataBytes []byte, method, correlationID string) (ts string, next time.Duration, err error) {
+	var endpoint string
 	switch method {
 	case "update":
-		req, _ = http.NewRequest("POST", SlackAPIEndpoint+"/chat.update", bytes.NewBuffer(jsondataBytes))
+		endpoint = "chat.update"
 	case "delete":
-		req, _ = http.NewRequest("POST", SlackAPIEndpoint+"/chat.delete", bytes.NewBuffer(jsondataBytes))
+		endpoint = "chat.delete"
 	case "send":
-		req, _ = http.NewRequest("POST", SlackAPIEndpoint+"/chat.postMessage", bytes.NewBuffer(jsondataBytes))
+		endpoint = "chat.postMessage"
 	}
 
+	defer func() {
+		var log = logger.With(logging.CorrelationKey, correlationID, "endpoint", endpoint)
+		if err != nil {
+			log.Warn("DeliveryFailed", "error", err)
+		} else {
+			log.Debug("Delivered", "ts", ts)
+		}
+	}()
+	req, _ := http.NewRequest("POST", SlackAPIEndpoint+"/"+endpoint, bytes.NewBuffer(jsondataBytes))
+
 	req.Header.Set("Authorization", "Bearer "+s.token)
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("MessageSendError(Slack): %w", err)
+		metrics.APIErrors.Inc("slack/"+endpoint, "network")
+		return "", 0, delivery_queue.Retry(fmt.Errorf("MessageSendError(Slack): %w", err), 0)
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("readall: %w", err)
+		return "", 0, delivery_queue.Retry(fmt.Errorf("readall: %w", err), 0)
 	}
 	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("failed send slack: body: %s", body)
+		metrics.APIErrors.Inc("slack/"+endpoint, strconv.Itoa(resp.StatusCode))
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		metrics.RateLimitWaits.Observe(retryAfter(resp.Header).Seconds(), "slack")
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return "", 0, delivery_queue.Retry(fmt.Errorf("failed send slack: body: %s", body), retryAfter(resp.Header))
+	}
+	if resp.StatusCode != 200 {
+		return "", 0, fmt.Errorf("failed send slack: body: %s", body)
 	}
 	var r struct {
-		OK bool   `json:"ok"`
-		TS string `json:"ts"`
+		OK    bool   `json:"ok"`
+		TS    string `json:"ts"`
+		Error string `json:"error"`
 	}
 	err = json.Unmarshal(body, &r)
 	if err != nil {
-		return "", fmt.Errorf("unmarshal: %w", err)
+		return "", 0, fmt.Errorf("unmarshal: %w", err)
 	}
 	if !r.OK {
-		return "", fmt.Errorf("failed send slack: body: %s", body)
+		metrics.APIErrors.Inc("slack/"+endpoint, r.Error)
+	}
+	if r.Error == "ratelimited" {
+		metrics.RateLimitWaits.Observe(retryAfter(resp.Header).Seconds(), "slack")
+		return "", 0, delivery_queue.Retry(fmt.Errorf("failed send slack: body: %s", body), retryAfter(resp.Header))
+	}
+	if !r.OK {
+		return "", 0, fmt.Errorf("failed send slack: body: %s", body)
 	}
 
-	return r.TS, nil
+	return r.TS, 0, nil
+}
+
+func retryAfter(header http.Header) time.Duration {
+	seconds, err := strconv.Atoi(header.Get("Retry-After"))
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
 }
 
 //Send json形式で指定したURLにPOSTする。
 func (s *Handler) Send(message Message) (string, error) {
 	jsonDataBytes, _ := json.Marshal(message)
-	return s.send(jsonDataBytes, "send")
+	return s.send(jsonDataBytes, "send", message.Event, message.CorrelationID)
 }
 
 func (s *Handler) Update(message Message) (string, error) {
 	jsonDataBytes, _ := json.Marshal(message)
-	return s.send(jsonDataBytes, "update")
+	return s.send(jsonDataBytes, "update", message.Event, message.CorrelationID)
 }
 
 func (s Handler) Remove(channel, ts string) (string, error) {
@@ -203,7 +302,7 @@ func (s Handler) Remove(channel, ts string) (string, error) {
 		TS:      ts,
 	}
 	jsonDataBytes, _ := json.Marshal(removeMessage)
-	return s.send(jsonDataBytes, "delete")
+	return s.send(jsonDataBytes, "delete", nil, "")
 }
 
 func (s *Handler) GetMessages(channelID, timestamp string, limit int) ([]Message, error) {
@@ -225,51 +324,88 @@ func (s *Handler) GetMessages(channelID, timestamp string, limit int) ([]Message
 		}
 		requestAttr.Set("cursor", cursor)
 
-		req, _ := http.NewRequest("GET", SlackAPIEndpoint+"/conversations.history?"+requestAttr.Encode(), nil)
-
-		req.Header.Set("Authorization", "Bearer "+s.token)
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("MessageSendError(Slack): %w", err)
-		}
-		defer resp.Body.Close()
-		body, err := ioutil.ReadAll(resp.Body)
+		page, more, next, err := s.history(requestAttr)
 		if err != nil {
-			return nil, fmt.Errorf("readall: %w", err)
-		}
-		if resp.StatusCode != 200 {
-			return nil, fmt.Errorf("failed send slack: body: %s", body)
+			return nil, err
 		}
+		messages = append(messages, page...)
+		hasMore = more
+		cursor = next
+	}
 
-		var r struct {
-			OK           bool      `json:"ok"`
-			Messages     []Message `json:"messages"`
-			HasMore      bool      `json:"has_more"`
-			ResponseMeta struct {
-				NextCursor string `json:"next_cursor"`
-			} `json:"response_metadata"`
-		}
+	return messages, nil
+}
 
-		err = json.Unmarshal(body, &r)
-		if err != nil {
-			return nil, fmt.Errorf("unmarshal: %w", err)
-		}
-		if !r.OK {
-			return nil, fmt.Errorf("failed send slack: body: %s", body)
-		}
+// GetMessagesAfter returns the messages newer than the timestamp, up to the limit of a page and the oldest first.
+// hasMore reports whether newer messages are left, which are fetched from the last message returned.
+func (s *Handler) GetMessagesAfter(channelID, timestamp string, limit int) (messages []Message, hasMore bool, err error) {
+	var requestAttr = url.Values{}
 
-		if err != nil {
-			return nil, err
-		}
+	// without latest, Slack returns the messages right after oldest
+	requestAttr.Set("channel", channelID)
+	requestAttr.Set("oldest", timestamp)
+	requestAttr.Set("limit", strconv.Itoa(limit))
 
-		messages = append(messages, r.Messages...)
-		hasMore = r.HasMore
-		cursor = r.ResponseMeta.NextCursor
+	messages, hasMore, _, err = s.history(requestAttr)
+	if err != nil {
+		return nil, false, err
 	}
 
-	return messages, nil
+	// messages are returned the newest first
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, hasMore, nil
+}
+
+// history requests a page of conversations.history
+func (s *Handler) history(requestAttr url.Values) (messages []Message, hasMore bool, cursor string, err error) {
+	req, _ := http.NewRequest("GET", SlackAPIEndpoint+"/conversations.history?"+requestAttr.Encode(), nil)
+
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, false, "", fmt.Errorf("MessageSendError(Slack): %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, "", fmt.Errorf("readall: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		return nil, false, "", fmt.Errorf("failed send slack: body: %s", body)
+	}
+
+	var r struct {
+		OK           bool              `json:"ok"`
+		Messages     []json.RawMessage `json:"messages"`
+		HasMore      bool              `json:"has_more"`
+		ResponseMeta struct {
+			NextCursor string `json:"next_cursor"`
+		} `json:"response_metadata"`
+	}
+
+	err = json.Unmarshal(body, &r)
+	if err != nil {
+		return nil, false, "", fmt.Errorf("unmarshal: %w", err)
+	}
+	if !r.OK {
+		return nil, false, "", fmt.Errorf("failed send slack: body: %s", body)
+	}
+
+	messages = []Message{}
+	for _, raw := range r.Messages {
+		var message Message
+		err = json.Unmarshal(raw, &message)
+		if err != nil {
+			return nil, false, "", fmt.Errorf("unmarshal: %w", err)
+		}
+		message.Raw = raw
+		messages = append(messages, message)
+	}
+	return messages, r.HasMore, r.ResponseMeta.NextCursor, nil
 }
 
 func (s *Handler) GetMessage(channelID, timestamp string) (*Message, error) {
@@ -368,7 +504,7 @@ func (s *Handler) FilesUpload(file File, channels ...string) (*slack.File, error
 		defer func() {
 			err := recover()
 			if err != nil {
-				log.Printf("Panic in Decoding JSON: %v\n", err)
+				logger.Error("PanicInDecodingJSON", "error", err)
 			}
 		}()
 		return json.NewDecoder(resp.Body).Decode(&responseAttr)
@@ -416,7 +552,7 @@ func (s *Handler) ChatUnfURL(parameters UnfURLsParameters) error {
 		defer func() {
 			err := recover()
 			if err != nil {
-				log.Printf("Error At Json Decoding: %v", err)
+				logger.Error("PanicInDecodingJSON", "error", err)
 			}
 		}()
 		return json.NewDecoder(resp.Body).Decode(&responseAttr)
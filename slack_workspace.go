@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/archive"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/discord_webhook"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/event_cache"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/settings"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/slack_emoji_imager"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/slack_webhook"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/supervisor"
+	"github.com/pkg/errors"
+)
+
+type SlackTokens struct {
+	Name  string
+	API   string
+	Event string
+	User  string
+	// SigningSecret enables the HTTP Events API, which is used instead of Socket Mode without Event
+	SigningSecret string
+}
+
+// SlackWorkspace bridges a Slack workspace and the shared Discord session.
+// Every workspace has its own Socket Mode connection or HTTP Events API endpoint and sees only the settings of its slack_team.
+type SlackWorkspace struct {
+	Tokens   SlackTokens
+	TeamID   string
+	Settings *settings.Handler
+
+	Hook    *slack_webhook.Handler
+	Slack   *SlackHandler
+	Discord *DiscordHandler
+	// Events is nil unless the signing secret is given
+	Events *SlackEventsHTTPHandler
+
+	membershipSync *MembershipSyncHandler
+	catchUp        *CatchUpHandler
+	supervisor     *supervisor.Supervisor
+}
+
+// loadSlackWorkspaceTokens reads the tokens of the workspaces listed in SLACK_WORKSPACES,
+// such as SLACK_API_TOKEN_NAME and SLACK_SIGNING_SECRET_NAME for the workspace NAME.
+// Without SLACK_WORKSPACES, the single workspace of SLACK_API_TOKEN is used.
+func loadSlackWorkspaceTokens() []SlackTokens {
+	var names = strings.Split(os.Getenv("SLACK_WORKSPACES"), ",")
+
+	var workspaces []SlackTokens
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		var suffix = "_" + strings.ToUpper(name)
+		workspaces = append(workspaces, SlackTokens{
+			Name:  name,
+			API:   os.Getenv("SLACK_API_TOKEN" + suffix),
+			Event: os.Getenv("SLACK_EVENT_TOKEN" + suffix),
+			User:  os.Getenv("SLACK_API_USER_TOKEN" + suffix),
+
+			SigningSecret: os.Getenv("SLACK_SIGNING_SECRET" + suffix),
+		})
+	}
+
+	if len(workspaces) == 0 {
+		workspaces = append(workspaces, SlackTokens{
+			API:   os.Getenv("SLACK_API_TOKEN"),
+			Event: os.Getenv("SLACK_EVENT_TOKEN"),
+			User:  os.Getenv("SLACK_API_USER_TOKEN"),
+
+			SigningSecret: os.Getenv("SLACK_SIGNING_SECRET"),
+		})
+	}
+	return workspaces
+}
+
+// NewSlackWorkspace creates the handlers of the workspace. Settings without slack_team are handled by the default workspace.
+func NewSlackWorkspace(tokens SlackTokens, setting *settings.Handler, isDefault bool, session *discordgo.Session, discordHook *discord_webhook.Handler) (*SlackWorkspace, error) {
+	var w = SlackWorkspace{Tokens: tokens}
+
+	w.Hook = slack_webhook.New(tokens.API)
+	w.TeamID = w.Hook.Identity.TeamID
+	if w.TeamID == "" {
+		return nil, fmt.Errorf("NoTeamID: %s", tokens.Name)
+	}
+
+	w.Settings = setting.ForSlackTeam(w.TeamID, tokens.API, isDefault)
+
+	imager, err := slack_emoji_imager.New(tokens.User, tokens.API)
+	if err != nil {
+		logger.Error("NewImager", "error", err)
+	}
+	imager.SetEmojiAssets(EmojiAssets)
+
+	var messageFinder = NewMessageFinder(w.Hook, discordHook)
+
+	var slackReactionHandler = NewSlackReactionHandler(w.Hook, discordHook, messageFinder, w.Settings)
+	slackReactionHandler.SetReactionImager(imager)
+
+	var discordReactionHandler = NewDiscordReactionHandler(w.Hook, discordHook, messageFinder, w.Settings)
+
+	w.Discord = NewDiscordBotWithSession(session, w.Settings)
+	w.Discord.SetSlackWebhook(w.Hook)
+	w.Discord.SetDiscordWebhook(discordHook)
+	w.Discord.SetDiscordReactionHandler(discordReactionHandler)
+	w.Discord.EnableModify(os.Getenv("DISCORD_ENABLE_MODIFY_MESSAGES") == "yes")
+
+	w.Slack = NewSlackBot(tokens.API, tokens.Event, w.Settings)
+	w.Slack.SetUserToken(tokens.User)
+	w.Slack.SetDiscordWebhook(discordHook)
+	w.Slack.SetSlackWebhook(w.Hook)
+	w.Slack.SetReactionHandler(slackReactionHandler)
+	w.Slack.SetFilePublishEmoji(os.Getenv("SLACK_FILE_PUBLISH_EMOJI"))
+	w.Slack.SetMessageFinder(messageFinder)
+
+	messageFinder.SetMessageEscaper(w.Slack)
+	slackReactionHandler.SetMessageEscaper(w.Slack)
+
+	var channelSyncHandler = NewChannelSyncHandler(tokens.API, session, w.Settings)
+	w.Discord.SetChannelSyncHandler(channelSyncHandler)
+	w.Slack.SetChannelSyncHandler(channelSyncHandler)
+
+	w.membershipSync = NewMembershipSyncHandler(tokens.API, session, w.Settings)
+	w.Discord.SetMembershipSyncHandler(w.membershipSync)
+	w.Slack.SetMembershipSyncHandler(w.membershipSync)
+	w.Settings.SetSlackChannelCreatedHandler(func(guildID, discordID, slackID string) {
+		w.membershipSync.SyncDiscordChannel(guildID, discordID)
+	})
+
+	if tokens.SigningSecret != "" {
+		w.Events = NewSlackEventsHTTPHandler(w.Slack, tokens.SigningSecret)
+	}
+
+	return &w, nil
+}
+
+// EventsPath returns the path of the HTTP Events API endpoint of the workspace
+func (w *SlackWorkspace) EventsPath() string {
+	if w.Tokens.Name == "" {
+		return SlackEventsPath
+	}
+	return SlackEventsPath + "/" + w.Tokens.Name
+}
+
+// SetEventCache makes the handlers of the workspace ignore redelivered events.
+// Keys are scoped by the team ID, as every workspace handles the same Discord events.
+func (w *SlackWorkspace) SetEventCache(cache *event_cache.Cache) {
+	var events = cache.Scope(w.TeamID)
+	w.Slack.SetEventCache(events)
+	w.Discord.SetEventCache(events)
+}
+
+// SetArchive makes the bridged traffic of the workspace recorded in the archive
+func (w *SlackWorkspace) SetArchive(archive *archive.Archive) {
+	w.Slack.SetArchive(archive)
+	w.Discord.SetArchive(archive)
+}
+
+// SetSupervisor makes the Socket Mode connection of the workspace reconnected by the supervisor
+func (w *SlackWorkspace) SetSupervisor(supervisor *supervisor.Supervisor) {
+	w.supervisor = supervisor
+	w.Slack.SetSupervisor(supervisor)
+	w.Discord.SetSupervisor(supervisor)
+}
+
+// EnableCatchUp makes the messages sent while the bridge was down replayed on connecting,
+// with the high-water marks stored in the file
+func (w *SlackWorkspace) EnableCatchUp(path string, discordHook *discord_webhook.Handler) error {
+	catchUp, err := NewCatchUpHandler(path, w.Hook, discordHook, w.Settings)
+	if err != nil {
+		return err
+	}
+	catchUp.SetSlackHandler(w.Slack)
+	catchUp.SetDiscordHandler(w.Discord)
+
+	w.Slack.SetCatchUpHandler(catchUp)
+	w.Discord.SetCatchUpHandler(catchUp)
+	w.catchUp = catchUp
+	return nil
+}
+
+// Start starts the channel map and the Socket Mode connection of the workspace.
+// Without the app-level token, events are received only by the HTTP Events API.
+func (w *SlackWorkspace) Start(stop <-chan struct{}) error {
+	var err = w.Settings.StartChannelMap(stop)
+	if w.Tokens.Event != "" {
+		go w.Slack.Do()
+		go w.supervisor.Supervise(w.Slack.ConnectionName(), stop, w.Slack.Run)
+	} else {
+		// Socket Mode catches up on connecting
+		go w.catchUp.CatchUpSlack()
+	}
+	return errors.Wrap(err, "StartChannelMap")
+}
@@ -1,8 +1,30 @@
 var Settings = [];
+// CSRFToken is sent with the requests which change something, and is empty without the login
+var CSRFToken = "";
 
 class GuildSettings {
     constructor(guild_setting) {
         this.discord_server = guild_setting.discord_server
+        this.slack_suffix = String(guild_setting.slack_suffix || "")
+        this.discord_suffix = String(guild_setting.discord_suffix || "")
+        this.name_rules = []
+        if (guild_setting.name_rules) {
+            for (let rule of guild_setting.name_rules) {
+                this.name_rules.push({ discord: String(rule.discord), slack: String(rule.slack) })
+            }
+        }
+        this.user_links = []
+        if (guild_setting.user_links) {
+            for (let link of guild_setting.user_links) {
+                this.user_links.push({ slack: String(link.slack), discord: String(link.discord) })
+            }
+        }
+        if (guild_setting.admin_slack_channel) {
+            this.admin_slack_channel = String(guild_setting.admin_slack_channel)
+        }
+        if (guild_setting.admin_discord_channel) {
+            this.admin_discord_channel = String(guild_setting.admin_discord_channel)
+        }
         this.channel = []
         for (let chan of guild_setting.channel) {
             this.channel.push(new ChannelSettings(chan))
@@ -12,8 +34,13 @@ class GuildSettings {
 
 class ChannelSettings {
     constructor(channel_setting) {
+        if (channel_setting.id) {
+            this.id = String(channel_setting.id);
+        }
         this.slack = String(channel_setting.slack);
         this.discord = String(channel_setting.discord);
+        this.discord_category = String(channel_setting.discord_category || "");
+        this.slack_team = String(channel_setting.slack_team || "");
         this.comment = String(channel_setting.comment);
         if (channel_setting.setting) {
             this.setting = {
@@ -22,6 +49,12 @@ class ChannelSettings {
                 ShowChannelName: Boolean(channel_setting.setting.ShowChannelName),
                 SendMuteState: Boolean(channel_setting.setting.SendMuteState),
                 SendVoiceState: Boolean(channel_setting.setting.SendVoiceState),
+                CreateSlackChannelOnSend: Boolean(channel_setting.setting.CreateSlackChannelOnSend),
+                CreateDiscordChannelOnSend: Boolean(channel_setting.setting.CreateDiscordChannelOnSend),
+                DiscordChannelCategory: String(channel_setting.setting.DiscordChannelCategory || ""),
+                SyncChannelName: Boolean(channel_setting.setting.SyncChannelName),
+                SyncChannelTopic: Boolean(channel_setting.setting.SyncChannelTopic),
+                SyncChannelArchive: Boolean(channel_setting.setting.SyncChannelArchive),
                 MuteSlackUsers: []
             }
 
@@ -37,7 +70,13 @@ class ChannelSettings {
 
     set Comment(comment) { this.comment = String(comment) }
     set SlackChannel(slack) { this.slack = String(slack) }
+    set SlackTeam(team) { this.slack_team = String(team || "") }
     set DiscordChannel(discord) { this.discord = String(discord) }
+    set DiscordCategory(category) { this.discord_category = String(category) }
+    set CreateSlackChannelOnSend(ok) { this.setting.CreateSlackChannelOnSend = Boolean(ok) }
+    set SyncChannelName(ok) { this.setting.SyncChannelName = Boolean(ok) }
+    set SyncChannelTopic(ok) { this.setting.SyncChannelTopic = Boolean(ok) }
+    set SyncChannelArchive(ok) { this.setting.SyncChannelArchive = Boolean(ok) }
     set SlackToDiscord(ok) { this.setting.slack2discord = Boolean(ok) }
     set DiscordToSlack(ok) { this.setting.discord2slack = Boolean(ok) }
     set ShowChannelName(ok) { this.setting.ShowChannelName = Boolean(ok) }
@@ -46,7 +85,13 @@ class ChannelSettings {
 
     get Comment() { return this.comment }
     get SlackChannel() { return this.slack }
+    get SlackTeam() { return this.slack_team }
     get DiscordChannel() { return this.discord }
+    get DiscordCategory() { return this.discord_category }
+    get CreateSlackChannelOnSend() { return this.setting.CreateSlackChannelOnSend }
+    get SyncChannelName() { return this.setting.SyncChannelName }
+    get SyncChannelTopic() { return this.setting.SyncChannelTopic }
+    get SyncChannelArchive() { return this.setting.SyncChannelArchive }
     get SlackToDiscord() { return this.setting.slack2discord }
     get DiscordToSlack() { return this.setting.discord2slack }
     get ShowChannelName() { return this.setting.ShowChannelName }
@@ -71,8 +116,20 @@ window.onload = async() => {
     let save = document.querySelector("#save")
     save.onclick = async() => {
         if (!save.disabled) {
-            if (window.confirm("現在の設定を保存しますか")) {
-                await save_settings();
+            const validation = await validate_settings()
+            show_validation(validation)
+
+            const errors = validation.problems.filter(p => p.level == "error").length
+            const warnings = validation.problems.length - errors
+            let message = "現在の設定を保存しますか"
+            if (errors > 0) {
+                message = `エラーが${errors}件あります。このまま保存しますか`
+            } else if (warnings > 0) {
+                message = `警告が${warnings}件あります。保存しますか`
+            }
+
+            if (window.confirm(message)) {
+                await save_settings(errors > 0);
                 make_alert("成功しました")
             }
         }
@@ -80,9 +137,28 @@ window.onload = async() => {
 
     let user_name = await get_client_info()
     document.querySelector("#username").textContent = "ようこそ、" + user_name.UserName + "さん"
+    CSRFToken = user_name.CSRFToken
+    if (CSRFToken) {
+        document.querySelector("#logout").classList.remove("d-none")
+    }
 
     await get_current_settings();
     await make_guild_selection();
+
+    document.querySelector("#reload_settings_versions").onclick = make_settings_version_list
+    await make_settings_version_list();
+
+    document.querySelector("#reload_dead_letters").onclick = make_dead_letter_list
+    await make_dead_letter_list();
+
+    document.querySelector("#reload_backfill_jobs").onclick = make_backfill_job_list
+    document.querySelector("#start_backfill").onclick = start_backfill
+    await make_backfill_job_list();
+
+    make_search_channel_selection()
+    document.querySelector("#search").onclick = search
+
+    await make_user_link_list();
 }
 
 const make_alert = (text, mode) => {
@@ -122,6 +198,9 @@ const get_json = async(action, params) => {
         credentials: 'same-origin',
     })
 
+    if (response.status == 401) {
+        location.href = new URL("auth/login", location.origin + location.pathname)
+    }
     if (!response.ok) {
         throw "Get Json Error"
     }
@@ -138,8 +217,9 @@ const post_json = async(action, data) => {
             method: "POST",
             credentials: "same-origin",
             body: JSON.stringify(data),
-            header: {
-                'Content-Type': 'application/json'
+            headers: {
+                'Content-Type': 'application/json',
+                'X-CSRF-Token': CSRFToken,
             }
         },
     )
@@ -163,17 +243,21 @@ const get_current_settings = async() => {
     return Settings
 }
 
-const save_settings = async() => {
+const save_settings = async(force) => {
     let uri = new URL("api/", location.origin + location.pathname)
 
     uri.searchParams.append("action", "setSettings")
+    if (force) {
+        uri.searchParams.append("force", "yes")
+    }
     let response = await fetch(
         uri, {
             method: "POST",
             credentials: "same-origin",
             body: JSON.stringify(Settings),
-            header: {
-                'Content-Type': 'application/json'
+            headers: {
+                'Content-Type': 'application/json',
+                'X-CSRF-Token': CSRFToken,
             }
         },
     )
@@ -185,7 +269,47 @@ const save_settings = async() => {
     return response
 }
 
+const validate_settings = async() => await post_json("validateSettings", Settings)
+
+const show_validation = (validation) => {
+    const problems = document.querySelector("#settings_problems")
+    const routes = document.querySelector("#settings_routes")
+    problems.innerHTML = ""
+    routes.innerHTML = ""
+
+    for (let problem of validation.problems) {
+        const item = document.createElement("li")
+        item.className = problem.level == "error" ? "text-danger" : "text-warning"
+        let place = problem.guild || ""
+        if (problem.rule >= 0) {
+            place += ` ルール${problem.rule}`
+        }
+        item.textContent = `${problem.level == "error" ? "エラー" : "警告"} ${place}: ${problem.message}`
+        problems.appendChild(item)
+    }
+
+    for (let route of validation.routes) {
+        const row = document.querySelector("#template-settings-route").content.cloneNode(true)
+        row.querySelector(".settings-route-discord").textContent = `#${route.discord_name}`
+        row.querySelector(".settings-route-slack").textContent = `#${route.slack_name || route.slack_channel}`
+        let direction = "×"
+        if (route.slack2discord && route.discord2slack) {
+            direction = "⇄"
+        } else if (route.discord2slack) {
+            direction = "→ Slack"
+        } else if (route.slack2discord) {
+            direction = "→ Discord"
+        }
+        row.querySelector(".settings-route-direction").textContent = direction
+        row.querySelector(".settings-route-rule").textContent = route.rule
+        routes.appendChild(row)
+    }
+
+    document.querySelector("#settings_preview").classList.remove("d-none")
+}
+
 const get_slack_channels = async() => await get_json("getSlackChannels")
+const get_channel_map_collisions = async() => await get_json("getChannelMapCollisions")
 const set_settings = async(settings) => await post_json("setSettings", settings)
 const get_discord_channels = async(guild_id) => await get_json("getDiscordChannels", { "guild_id": guild_id })
 const get_discord_guild_identitiy = async(guild_id) => {
@@ -209,6 +333,274 @@ const restart = async() => {
     return
 }
 
+const get_settings_versions = async() => await get_json("getSettingsVersions")
+const get_settings_diff = async(id) => await get_json("getSettingsDiff", { "id": id })
+
+const make_settings_version_list = async() => {
+    const versions = await get_settings_versions()
+    const tbody = document.querySelector("#settings_versions")
+    const diff = document.querySelector("#settings_diff")
+    tbody.innerHTML = ""
+    diff.classList.add("d-none")
+
+    for (let version of versions) {
+        const row = document.querySelector("#template-settings-version").content.cloneNode(true)
+        row.querySelector(".settings-version-time").textContent = `#${version.id} ${new Date(version.time).toLocaleString()}`
+        row.querySelector(".settings-version-author").textContent = version.author
+        row.querySelector(".settings-version-change").textContent = `+${version.added} -${version.removed} ${version.comment || ""}`
+
+        row.querySelector(".settings-version-diff").onclick = async() => {
+            const full = await get_settings_diff(version.id)
+            diff.textContent = full.diff
+            diff.classList.remove("d-none")
+        }
+        row.querySelector(".settings-version-rollback").onclick = async() => {
+            if (!window.confirm(`設定をバージョン${version.id}に戻しますか`)) {
+                return
+            }
+            try {
+                try {
+                    await post_action("rollbackSettings", version.id)
+                } catch (e) {
+                    if (!String(e).startsWith("BadRequest: InvalidSettings") || !window.confirm(`${e}\nこのまま戻しますか`)) {
+                        throw e
+                    }
+                    await post_action("rollbackSettings", version.id, { "force": "yes" })
+                }
+                make_alert("設定を戻しました。再読み込みしてください")
+            } catch (e) {
+                make_alert(e, "error")
+            }
+            await make_settings_version_list()
+        }
+
+        tbody.appendChild(row)
+    }
+}
+
+const get_dead_letters = async() => await get_json("getDeadLetters")
+const get_dead_letter = async(id) => await get_json("getDeadLetter", { "id": id })
+
+const post_action = async(action, id, params) => {
+    let uri = new URL("api/", location.origin + location.pathname)
+
+    uri.searchParams.append("action", action)
+    uri.searchParams.append("id", id)
+    if (params) {
+        for (p in params) {
+            uri.searchParams.append(p, params[p])
+        }
+    }
+    let response = await fetch(uri, {
+        method: "POST",
+        credentials: "same-origin",
+        headers: {
+            'X-CSRF-Token': CSRFToken,
+        },
+    })
+
+    if (!response.ok) {
+        throw await response.text()
+    }
+}
+
+const make_user_link_list = async() => {
+    const links = await get_json("getUserLinks")
+    const tbody = document.querySelector("#user_links")
+    tbody.innerHTML = ""
+    document.querySelector("#user_links_card").classList.toggle("d-none", links.length == 0)
+
+    for (let link of links) {
+        const row = document.querySelector("#template-user-link").content.cloneNode(true)
+        row.querySelector(".user-link-guild").textContent = link.guild_name || link.guild
+        row.querySelector(".user-link-slack").textContent = link.name ? `${link.name} (${link.slack})` : link.slack
+        row.querySelector(".user-link-status").textContent = link.confirmed ? "承認済み" : "未承認"
+
+        const toggle = row.querySelector(".user-link-toggle")
+        toggle.textContent = link.confirmed ? "取り消す" : "承認する"
+        toggle.onclick = async() => {
+            if (!link.confirmed && !window.confirm(`${link.slack}がSlackでログインしたとき、あなたとして扱いますか`)) {
+                return
+            }
+            try {
+                await post_action("confirmUserLink", "", { "guild": link.guild, "slack": link.slack, "confirmed": link.confirmed ? "no" : "yes" })
+            } catch (e) {
+                make_alert(e, "error")
+            }
+            await make_user_link_list()
+        }
+
+        tbody.appendChild(row)
+    }
+}
+
+const make_dead_letter_list = async() => {
+    const letters = await get_dead_letters()
+    const tbody = document.querySelector("#dead_letters")
+    const detail = document.querySelector("#dead_letter_detail")
+    tbody.innerHTML = ""
+    detail.classList.add("d-none")
+
+    for (let letter of letters) {
+        const row = document.querySelector("#template-dead-letter").content.cloneNode(true)
+        row.querySelector(".dead-letter-failed").textContent = new Date(letter.failed).toLocaleString()
+        row.querySelector(".dead-letter-target").textContent = `${letter.queue} / ${letter.target}`
+        row.querySelector(".dead-letter-error").textContent = letter.error
+
+        row.querySelector(".dead-letter-inspect").onclick = async() => {
+            const full = await get_dead_letter(letter.id)
+            detail.textContent = JSON.stringify(full, null, 2)
+            detail.classList.remove("d-none")
+        }
+        row.querySelector(".dead-letter-retry").onclick = async() => {
+            try {
+                await post_action("retryDeadLetter", letter.id)
+                make_alert("再送しました")
+            } catch (e) {
+                make_alert(e, "error")
+            }
+            await make_dead_letter_list()
+        }
+        row.querySelector(".dead-letter-discard").onclick = async() => {
+            if (!window.confirm("このメッセージを破棄しますか")) {
+                return
+            }
+            try {
+                await post_action("discardDeadLetter", letter.id)
+            } catch (e) {
+                make_alert(e, "error")
+            }
+            await make_dead_letter_list()
+        }
+
+        tbody.appendChild(row)
+    }
+}
+
+const get_backfill_jobs = async() => await get_json("getBackfillJobs")
+
+const start_backfill = async() => {
+    const from = document.querySelector("#backfill_from").value
+    const to = document.querySelector("#backfill_to").value
+    if (!from) {
+        make_alert("開始日を指定してください", "error")
+        return
+    }
+
+    let request = {
+        "direction": document.querySelector("#backfill_direction").value,
+        "slack_team": document.querySelector("#backfill_slack_team").value,
+        "slack_channel": document.querySelector("#backfill_slack_channel").value,
+        "discord_channel": document.querySelector("#backfill_discord_channel").value,
+        "from": new Date(from + "T00:00").toISOString(),
+    }
+    if (to) {
+        // the day given as the end is included
+        let end = new Date(to + "T00:00")
+        end.setDate(end.getDate() + 1)
+        request["to"] = end.toISOString()
+    }
+
+    try {
+        await post_json("startBackfill", request)
+        make_alert("取り込みを開始しました")
+    } catch (e) {
+        make_alert(e, "error")
+    }
+    await make_backfill_job_list()
+}
+
+const make_backfill_job_list = async() => {
+    const jobs = await get_backfill_jobs()
+    const tbody = document.querySelector("#backfill_jobs")
+    tbody.innerHTML = ""
+
+    for (let job of jobs) {
+        const row = document.querySelector("#template-backfill-job").content.cloneNode(true)
+        const arrow = job.direction == "slack2discord" ? "→" : "←"
+        row.querySelector(".backfill-created").textContent = new Date(job.created).toLocaleString()
+        row.querySelector(".backfill-channels").textContent = `${job.slack_channel} ${arrow} ${job.discord_channel}`
+        row.querySelector(".backfill-status").textContent = job.error ? `${job.status}: ${job.error}` : job.status
+        row.querySelector(".backfill-progress").textContent = `${job.done} / ${job.total}`
+
+        const resume = row.querySelector(".backfill-resume")
+        resume.disabled = job.status != "failed"
+        resume.onclick = async() => {
+            try {
+                await post_action("resumeBackfill", job.id)
+                make_alert("取り込みを再開しました")
+            } catch (e) {
+                make_alert(e, "error")
+            }
+            await make_backfill_job_list()
+        }
+
+        tbody.appendChild(row)
+    }
+}
+
+const make_search_channel_selection = () => {
+    const select = document.querySelector("#search_channel")
+    for (let guild of Settings) {
+        for (let channel of guild.channel) {
+            if (!channel.SlackChannel || !channel.DiscordChannel) {
+                continue
+            }
+            let option = document.createElement("option")
+            option.value = JSON.stringify({ slack_channel: channel.SlackChannel, discord_channel: channel.DiscordChannel })
+            option.textContent = channel.Comment || `${channel.SlackChannel} ↔ ${channel.DiscordChannel}`
+            select.appendChild(option)
+        }
+    }
+}
+
+const search = async() => {
+    let params = {
+        "q": document.querySelector("#search_text").value,
+        "author": document.querySelector("#search_author").value,
+        "from": document.querySelector("#search_from").value,
+        "to": document.querySelector("#search_to").value,
+    }
+    const channel = document.querySelector("#search_channel").value
+    if (channel) {
+        Object.assign(params, JSON.parse(channel))
+    }
+
+    let results
+    try {
+        results = await get_json("search", params)
+    } catch (e) {
+        make_alert("検索できませんでした。メッセージの保存が有効か確認してください", "error")
+        return
+    }
+
+    const tbody = document.querySelector("#search_results")
+    tbody.innerHTML = ""
+    for (let result of results) {
+        const row = document.querySelector("#template-search-result").content.cloneNode(true)
+        row.querySelector(".search-time").textContent = new Date(result.time).toLocaleString()
+        row.querySelector(".search-author").textContent = result.user_name
+        row.querySelector(".search-text").textContent = result.edited ? `${result.text} (編集済み)` : result.text
+
+        for (let [name, url] of [
+                ["slack", result.slack_url],
+                ["discord", result.discord_url]
+            ]) {
+            const link = row.querySelector(`.search-${name}`)
+            if (url) {
+                link.href = url
+            } else {
+                link.classList.add("d-none")
+            }
+        }
+
+        tbody.appendChild(row)
+    }
+    if (results.length == 0) {
+        make_alert("見つかりませんでした", "info")
+    }
+}
+
 const get_guild_setting = async(guild_id) => {
     return Settings.find(setting => setting.discord_server == guild_id)
 }
@@ -250,14 +642,34 @@ const make_guild_selection = async() => {
         guild_select.appendChild(option)
     })
 
-    guild_select.onchange = event => {
-        make_settings_list(event.target.value)
+    guild_select.onchange = async event => {
+        await make_settings_list(event.target.value)
+        await show_collisions(event.target.value)
     }
 
     document.querySelector("#save").disabled = false
 
 }
 
+const show_collisions = async(guild_id) => {
+    const collisions = await get_channel_map_collisions()
+    const messages = []
+    for (let collision of collisions) {
+        const channels = collision.discord_channels.filter(chan => chan.guild_id == guild_id)
+        if (channels.length < 1) {
+            continue
+        }
+        const names = collision.discord_channels.map(chan => "#" + chan.name).join(", ")
+        messages.push(`${names} → #${collision.slack_channel_name}`)
+    }
+
+    if (messages.length > 0) {
+        make_alert("同じSlackチャンネルに対応するDiscordチャンネルがあります\n" + messages.join("\n"), "error")
+    } else {
+        make_alert("", "remove")
+    }
+}
+
 const make_settings_list = async(guild_id, discord_channel_list, slack_channel_list) => {
     if (!slack_channel_list && !discord_channel_list) {
         discord_channel_list = await get_discord_channels(guild_id);
@@ -296,7 +708,11 @@ const make_settings_list = async(guild_id, discord_channel_list, slack_channel_l
         const icon = setting_channel.querySelector(".button-icon-setting");
 
         let discord_channel = discord_channel_list.find(chan => { return chan.id == setting.DiscordChannel });
-        if (discord_channel !== undefined) {
+        let discord_category = discord_channel_list.find(chan => { return chan.type === 4 && chan.id == setting.DiscordCategory });
+        if (discord_category !== undefined) {
+            icon.classList.add("fa-folder");
+            span.innerText = discord_category.name;
+        } else if (discord_channel !== undefined) {
             switch (discord_channel.type) {
                 case 0:
                     icon.classList.add("fa-hashtag");
@@ -350,7 +766,7 @@ const make_settings_list = async(guild_id, discord_channel_list, slack_channel_l
             }
 
             let option = select_discord.querySelector("option")
-            if (!this_setting.DiscordChannel) {
+            if (!this_setting.DiscordChannel && !this_setting.DiscordCategory) {
                 option.selected = true;
             }
 
@@ -366,6 +782,25 @@ const make_settings_list = async(guild_id, discord_channel_list, slack_channel_l
                     select_discord.appendChild(option)
                 }
             }
+
+            if (!isTextChannel) {
+                return
+            }
+
+            // カテゴリ内の全てのテキストチャンネル
+            for (channel of discord_channel_list) {
+                if (channel.type !== 4) {
+                    continue
+                }
+                const option = document.createElement("option")
+                if (this_setting.DiscordCategory == channel.id) {
+                    option.selected = true;
+                }
+                option.innerText = "📁 " + channel.name;
+                option.setAttribute("data-discordid", channel.id)
+                option.setAttribute("data-discordcategory", "true")
+                select_discord.appendChild(option)
+            }
         }
         regenerate_select_discord(select_discord, isTextChannel)
 
@@ -376,8 +811,15 @@ const make_settings_list = async(guild_id, discord_channel_list, slack_channel_l
                 document.querySelector(`#${setting_id} .button-title-setting`).textContent = " NewSetting"
                 return;
             }
+            const selected = event.target.options[event.target.selectedIndex];
             this_setting.Comment = "#" + event.target.value;
-            this_setting.DiscordChannel = event.target.options[event.target.selectedIndex].getAttribute("data-discordid");
+            if (selected.getAttribute("data-discordcategory")) {
+                this_setting.DiscordChannel = "";
+                this_setting.DiscordCategory = selected.getAttribute("data-discordid");
+            } else {
+                this_setting.DiscordChannel = selected.getAttribute("data-discordid");
+                this_setting.DiscordCategory = "";
+            }
             document.querySelector(`#${setting_id} .button-title-setting`).innerText = event.target.value;
         };
 
@@ -389,13 +831,33 @@ const make_settings_list = async(guild_id, discord_channel_list, slack_channel_l
             option.selected = true;
         }
 
+        // チャンネルごとに対応するSlackチャンネルへ転送
+        const option_all = document.createElement("option")
+        if (setting.SlackChannel == "all") {
+            option_all.selected = true;
+        }
+        option_all.innerText = "(チャンネル名で対応)";
+        option_all.setAttribute("data-slackid", "all")
+        select_slack.appendChild(option_all)
+
         for (channel of slack_channel_list) {
             const option = document.createElement("option")
             if (setting.SlackChannel == channel.id) {
                 option.selected = true;
             }
 
-            option.innerText = channel.name;
+            if (channel.is_mpim) {
+                option.innerText = `👥 ${channel.name}`;
+            } else if (channel.is_private) {
+                option.innerText = `🔒 ${channel.name}`;
+            } else {
+                option.innerText = channel.name;
+            }
+            // 複数のワークスペースを使う場合
+            if (channel.team_id) {
+                option.innerText = `[${channel.team_name || channel.team_id}] ${option.innerText}`;
+                option.setAttribute("data-slackteam", channel.team_id)
+            }
             option.setAttribute("data-slackid", channel.id)
             select_slack.appendChild(option)
         }
@@ -405,7 +867,9 @@ const make_settings_list = async(guild_id, discord_channel_list, slack_channel_l
                 setting.SlackChannel = "";
                 return;
             }
-            this_setting.SlackChannel = event.target.options[event.target.selectedIndex].getAttribute("data-slackid");
+            const selected = event.target.options[event.target.selectedIndex];
+            this_setting.SlackChannel = selected.getAttribute("data-slackid");
+            this_setting.SlackTeam = selected.getAttribute("data-slackteam");
         };
 
 
@@ -478,6 +942,31 @@ const make_settings_list = async(guild_id, discord_channel_list, slack_channel_l
             this_setting.ShowChannelName = event.target.checked == true
         }
 
+        // Create Slack Channel
+        const create_slack_channel_input = setting_channel.querySelector(`.create-slack-channel-setting`);
+        if (setting.CreateSlackChannelOnSend) {
+            create_slack_channel_input.checked = "checked"
+        }
+        create_slack_channel_input.onchange = (event) => {
+            this_setting.CreateSlackChannelOnSend = event.target.checked == true
+        }
+
+        // Channel Sync
+        const sync_inputs = {
+            ".sync-channel-name-setting": "SyncChannelName",
+            ".sync-channel-topic-setting": "SyncChannelTopic",
+            ".sync-channel-archive-setting": "SyncChannelArchive",
+        };
+        for (const [selector, key] of Object.entries(sync_inputs)) {
+            const sync_input = setting_channel.querySelector(selector);
+            if (setting[key]) {
+                sync_input.checked = "checked"
+            }
+            sync_input.onchange = (event) => {
+                this_setting[key] = event.target.checked == true
+            }
+        }
+
         const remove_button = setting_channel.querySelector(".remove-setting");
         remove_button.onclick = () => {
             settings.channel.splice(index, 1);
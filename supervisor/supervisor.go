@@ -0,0 +1,270 @@
+// Package supervisor keeps the connections to Discord and Slack up,
+// and reports their state for health checks.
+package supervisor
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kmc-jp/DiscordSlackSynchronizer/alert"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/logging"
+	"github.com/kmc-jp/DiscordSlackSynchronizer/metrics"
+	"github.com/pkg/errors"
+)
+
+const (
+	// MinBackoff and MaxBackoff bound the wait before reconnecting after a failure
+	MinBackoff = time.Second
+	MaxBackoff = 5 * time.Minute
+	// StuckTimeout is how long a connection can be down before the instance is reported unhealthy
+	StuckTimeout = 10 * time.Minute
+	// LostAlertDelay is how long a connection can be down before it is notified, so that a quick reconnect is not
+	LostAlertDelay = 30 * time.Second
+)
+
+var logger = logging.New("supervisor")
+
+// Status is the state of a connection
+type Status struct {
+	Connected bool `json:"connected"`
+	// Since is when the connection was made or lost
+	Since    time.Time `json:"since"`
+	Error    string    `json:"error,omitempty"`
+	Restarts int       `json:"restarts"`
+}
+
+// Report is the body of the health and readiness endpoints
+type Report struct {
+	Status      string            `json:"status"`
+	Connections map[string]Status `json:"connections"`
+	// LastBridged is the time of the last message bridged successfully for each direction
+	LastBridged map[string]time.Time `json:"last_bridged"`
+}
+
+type Supervisor struct {
+	connections map[string]*Status
+	bridged     map[string]time.Time
+
+	// lostTimers notify the connections still down after alertDelay, and alerted are the connections notified
+	alertDelay time.Duration
+	lostTimers map[string]*time.Timer
+	alerted    map[string]bool
+
+	mu sync.Mutex
+}
+
+func New() *Supervisor {
+	return &Supervisor{
+		connections: map[string]*Status{},
+		bridged:     map[string]time.Time{},
+		alertDelay:  LostAlertDelay,
+		lostTimers:  map[string]*time.Timer{},
+		alerted:     map[string]bool{},
+	}
+}
+
+// Add registers the connection, which is down until connected
+func (s *Supervisor) Add(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status(name)
+}
+
+// status returns the state of the connection, and must be called with mu held
+func (s *Supervisor) status(name string) *Status {
+	status, ok := s.connections[name]
+	if !ok {
+		status = &Status{Since: time.Now()}
+		s.connections[name] = status
+		metrics.Connected.Set(0, name)
+	}
+	return status
+}
+
+// SetConnected records that the connection is made or lost
+func (s *Supervisor) SetConnected(name string, connected bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var status = s.status(name)
+	if status.Connected == connected {
+		return
+	}
+	status.Connected = connected
+	status.Since = time.Now()
+	if connected {
+		if timer, ok := s.lostTimers[name]; ok {
+			timer.Stop()
+			delete(s.lostTimers, name)
+		}
+		if s.alerted[name] {
+			alert.Notify(alert.KindConnection, "%sに再接続しました", name)
+			delete(s.alerted, name)
+		}
+		status.Error = ""
+		metrics.Connected.Set(1, name)
+	} else {
+		s.watchLost(name)
+		metrics.Connected.Set(0, name)
+	}
+}
+
+// watchLost notifies the connection if it is still down after alertDelay, and must be called with mu held
+func (s *Supervisor) watchLost(name string) {
+	if _, ok := s.lostTimers[name]; ok || s.alerted[name] {
+		return
+	}
+	s.lostTimers[name] = time.AfterFunc(s.alertDelay, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(s.lostTimers, name)
+		var status = s.status(name)
+		if status.Connected {
+			return
+		}
+		s.alerted[name] = true
+		if status.Error != "" {
+			alert.Notify(alert.KindConnection, "%sの接続が切れました: %s", name, status.Error)
+		} else {
+			alert.Notify(alert.KindConnection, "%sの接続が切れました", name)
+		}
+	})
+}
+
+func (s *Supervisor) fail(name string, err error) {
+	s.SetConnected(name, false)
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var status = s.status(name)
+	status.Restarts++
+	status.Error = err.Error()
+	// the connections failing before they are made are notified as well
+	s.watchLost(name)
+}
+
+// DownFor returns how long the connection has been down, which is 0 while connected
+func (s *Supervisor) DownFor(name string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var status = s.status(name)
+	if status.Connected {
+		return 0
+	}
+	return time.Since(status.Since)
+}
+
+// MarkBridged records the message bridged successfully in the direction
+func (s *Supervisor) MarkBridged(direction string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bridged[direction] = time.Now()
+}
+
+// Supervise calls run until stop is closed. run blocks while the connection is up,
+// and is called again with backoff when it returns.
+func (s *Supervisor) Supervise(name string, stop <-chan struct{}, run func(stop <-chan struct{}) error) {
+	s.Add(name)
+
+	var wait = MinBackoff
+	for {
+		var started = time.Now()
+		var err = run(stop)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		if err == nil {
+			err = errors.New("Closed")
+		}
+		s.fail(name, err)
+		// the backoff is reset if the connection has been up for a while
+		if time.Since(started) > MaxBackoff {
+			wait = MinBackoff
+		}
+		logger.Warn("ConnectionLost", "connection", name, "reconnect_in", wait, "error", err)
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+
+		wait *= 2
+		if wait > MaxBackoff {
+			wait = MaxBackoff
+		}
+	}
+}
+
+// Report returns the state of the connections. The instance is healthy unless a connection is down for StuckTimeout,
+// and ready when all connections are up.
+func (s *Supervisor) Report() (report Report, healthy, ready bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	healthy, ready = true, true
+	report.Connections = map[string]Status{}
+	for name, status := range s.connections {
+		report.Connections[name] = *status
+		if !status.Connected {
+			ready = false
+			if time.Since(status.Since) > StuckTimeout {
+				healthy = false
+			}
+		}
+	}
+
+	report.LastBridged = map[string]time.Time{}
+	for direction, t := range s.bridged {
+		report.LastBridged[direction] = t
+	}
+	return
+}
+
+// HealthHandler serves the liveness, which fails when a connection is stuck and the instance should be restarted
+func (s *Supervisor) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, healthy, _ := s.Report()
+		writeReport(w, report, healthy)
+	})
+}
+
+// ReadyHandler serves the readiness, which fails while any connection is down
+func (s *Supervisor) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, _, ready := s.Report()
+		writeReport(w, report, ready)
+	})
+}
+
+func writeReport(w http.ResponseWriter, report Report, ok bool) {
+	report.Status = "ok"
+	w.Header().Add("Content-type", "application/json")
+	if !ok {
+		report.Status = "unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
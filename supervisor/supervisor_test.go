@@ -0,0 +1,100 @@
+package supervisor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	var s = New()
+	s.Add("discord")
+	s.Add("slack")
+
+	_, healthy, ready := s.Report()
+	if !healthy || ready {
+		t.Errorf("starting: healthy %v, ready %v", healthy, ready)
+	}
+
+	s.SetConnected("discord", true)
+	s.SetConnected("slack", true)
+	s.MarkBridged("slack_to_discord")
+
+	report, healthy, ready := s.Report()
+	if !healthy || !ready {
+		t.Errorf("connected: healthy %v, ready %v", healthy, ready)
+	}
+	if report.LastBridged["slack_to_discord"].IsZero() {
+		t.Errorf("last bridged: %+v", report.LastBridged)
+	}
+
+	s.SetConnected("slack", false)
+	s.connections["slack"].Since = time.Now().Add(-StuckTimeout - time.Second)
+
+	var w = httptest.NewRecorder()
+	s.HealthHandler().ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("stuck: healthz %d", w.Code)
+	}
+}
+
+func TestSupervise(t *testing.T) {
+	var s = New()
+	var stop = make(chan struct{})
+	var done = make(chan struct{})
+	var calls int
+
+	go func() {
+		s.Supervise("slack", stop, func(stop <-chan struct{}) error {
+			calls++
+			if calls == 1 {
+				return nil
+			}
+			s.SetConnected("slack", true)
+			<-stop
+			return nil
+		})
+		close(done)
+	}()
+
+	for i := 0; i < 100 && s.DownFor("slack") > 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	close(stop)
+	<-done
+
+	report, _, _ := s.Report()
+	if calls != 2 || report.Connections["slack"].Restarts != 1 {
+		t.Errorf("calls %d, status %+v", calls, report.Connections["slack"])
+	}
+}
+
+func TestLostAlert(t *testing.T) {
+	var s = New()
+	s.alertDelay = 50 * time.Millisecond
+
+	var alerted = func(name string) bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.alerted[name]
+	}
+
+	// a quick reconnect is not notified
+	s.SetConnected("discord", true)
+	s.SetConnected("discord", false)
+	s.SetConnected("discord", true)
+
+	s.SetConnected("slack", true)
+	s.SetConnected("slack", false)
+
+	time.Sleep(200 * time.Millisecond)
+	if alerted("discord") || !alerted("slack") {
+		t.Errorf("discord %v, slack %v", alerted("discord"), alerted("slack"))
+	}
+
+	s.SetConnected("slack", true)
+	if alerted("slack") {
+		t.Error("the reconnected connection is still alerted")
+	}
+}